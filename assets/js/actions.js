@@ -11,7 +11,7 @@ function initActions() {
 
         uuid = $(this).data("uuid");
         $.ajax({
-            type: 'GET',
+            type: 'POST',
             url: '/action/enable_strategy/' + uuid,
             data: {},
             success: function() {
@@ -34,7 +34,7 @@ function initActions() {
 
         uuid = $(this).data("uuid");
         $.ajax({
-            type: 'GET',
+            type: 'POST',
             url: '/action/disable_strategy/' + uuid,
             data: {},
             success: function() {
@@ -63,7 +63,7 @@ function initActions() {
         }
 
         $.ajax({
-            type: 'GET',
+            type: 'POST',
             url: '/action/reset_strategy/' + uuid,
             data: {},
             success: function() {
@@ -121,7 +121,7 @@ function initActions() {
         }
 
         $.ajax({
-            type: 'GET',
+            type: 'POST',
             url: '/action/close_position/' + uuid,
             data: {},
             success: function(data) {
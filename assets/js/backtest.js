@@ -0,0 +1,60 @@
+// initBacktest posts the backtest form along with the strategy form if given, the strategy form is used to
+// backtest the unsaved strategy, and renders the result
+function initBacktest(strategyForm) {
+    var reasons = { entry: "開倉", stop_loss: "停損", take_profit: "停利", end: "回測結束平倉" };
+
+    $("#backtest-form").on("submit", function(event) {
+        event.preventDefault();
+
+        var data = new FormData(this);
+        if (strategyForm) {
+            $(strategyForm).serializeArray().forEach(function(field) {
+                data.append(field.name, field.value);
+            });
+        }
+
+        // loading
+        $('#backtest-button').addClass('d-none');
+        $('#backtest-loading').removeClass('d-none');
+
+        $.ajax({
+            type: 'POST',
+            url: '/strategy/backtest',
+            data: data,
+            processData: false,
+            contentType: false,
+            success: function(resp) {
+                renderBacktest(resp.data);
+            },
+        }).fail(function(data) {
+            alert(data.responseJSON.error);
+        }).always(function() {
+            $('#backtest-button').removeClass('d-none');
+            $('#backtest-loading').addClass('d-none');
+        });
+    });
+
+    var renderBacktest = function(result) {
+        $('#backtest-result').removeClass('d-none');
+        $('#backtest-candles').text(result.candles);
+        $('#backtest-pnl').text(result.pnl + " (" + result.pnl_percent + "%)")
+            .toggleClass('text-success', parseFloat(result.pnl) > 0)
+            .toggleClass('text-danger', parseFloat(result.pnl) < 0);
+        $('#backtest-fees').text(result.fees);
+        $('#backtest-drawdown').text(result.max_drawdown + " (" + result.max_drawdown_percent + "%)");
+
+        var rows = $('#backtest-fills').empty();
+        if (result.fills.length == 0) {
+            rows.append($('<tr>').append($('<td colspan="4" class="text-muted">').text("未觸發開倉")));
+        }
+        result.fills.forEach(function(f) {
+            rows.append($('<tr>')
+                .append($('<td>').text(f.time.substring(0, 16).replace("T", " ")))
+                .append($('<td>').text(reasons[f.reason] || f.reason))
+                .append($('<td>').text(f.price))
+                .append($('<td>').text(f.size)));
+        });
+
+        drawPriceChart($('#backtest-chart')[0], result.points, result.fills);
+    };
+}
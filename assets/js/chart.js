@@ -0,0 +1,103 @@
+// drawPriceChart draws the close price and the trigger lines of the points on the canvas,
+// points: [{time, close, trendline, entry, stop_loss, take_profit}], prices are strings and empty if not set
+// fills: [{time, reason, price}], drawn as markers
+// returns the scales between the pixels and the time (ms) and price, or null if there's nothing to draw
+function drawPriceChart(canvas, points, fills) {
+    var ctx = canvas.getContext("2d");
+    var width = canvas.width = canvas.clientWidth;
+    var height = canvas.height;
+    var padding = { top: 10, right: 70, bottom: 20, left: 10 };
+    ctx.clearRect(0, 0, width, height);
+    if (!points || points.length == 0) {
+        return null;
+    }
+
+    var series = [
+        { key: "close", color: "#212529" },
+        { key: "trendline", color: "#6f42c1" },
+        { key: "entry", color: "#0d6efd" },
+        { key: "stop_loss", color: "#dc3545" },
+        { key: "take_profit", color: "#198754" },
+    ];
+
+    // Range of all the prices
+    var min = Infinity, max = -Infinity;
+    points.forEach(function(p) {
+        series.forEach(function(s) {
+            if (p[s.key] !== undefined && p[s.key] !== "") {
+                min = Math.min(min, parseFloat(p[s.key]));
+                max = Math.max(max, parseFloat(p[s.key]));
+            }
+        });
+    });
+    if (min == max) {
+        min -= 1;
+        max += 1;
+    }
+
+    var startTime = new Date(points[0].time).getTime();
+    var endTime = new Date(points[points.length - 1].time).getTime();
+    var x = function(time) {
+        if (endTime == startTime) {
+            return padding.left;
+        }
+        return padding.left + (new Date(time).getTime() - startTime) / (endTime - startTime) * (width - padding.left - padding.right);
+    };
+    var y = function(price) {
+        return padding.top + (max - parseFloat(price)) / (max - min) * (height - padding.top - padding.bottom);
+    };
+
+    // Price axis
+    ctx.fillStyle = "#6c757d";
+    ctx.font = "11px sans-serif";
+    for (var i = 0; i <= 4; i++) {
+        var price = min + (max - min) * i / 4;
+        ctx.fillText(price.toPrecision(6), width - padding.right + 5, y(price) + 4);
+    }
+    ctx.fillText(points[0].time.substring(0, 16).replace("T", " "), padding.left, height - 5);
+    var end = points[points.length - 1].time.substring(0, 16).replace("T", " ");
+    ctx.fillText(end, width - padding.right - ctx.measureText(end).width, height - 5);
+
+    // Lines, broken where the price isn't set
+    series.forEach(function(s) {
+        ctx.strokeStyle = s.color;
+        ctx.lineWidth = s.key == "close" ? 1.5 : 1;
+        ctx.setLineDash(s.key == "close" ? [] : [4, 3]);
+        ctx.beginPath();
+        var drawing = false;
+        points.forEach(function(p) {
+            if (p[s.key] === undefined || p[s.key] === "") {
+                drawing = false;
+                return;
+            }
+            if (drawing) {
+                ctx.lineTo(x(p.time), y(p[s.key]));
+            } else {
+                ctx.moveTo(x(p.time), y(p[s.key]));
+                drawing = true;
+            }
+        });
+        ctx.stroke();
+    });
+    ctx.setLineDash([]);
+
+    // Fills
+    var colors = { entry: "#0d6efd", stop_loss: "#dc3545", take_profit: "#198754", end: "#6c757d" };
+    (fills || []).forEach(function(f) {
+        ctx.fillStyle = colors[f.reason] || "#212529";
+        ctx.beginPath();
+        ctx.arc(x(f.time), y(f.price), 4, 0, 2 * Math.PI);
+        ctx.fill();
+    });
+
+    return {
+        x: x,
+        y: y,
+        time: function(px) {
+            return startTime + (px - padding.left) / (width - padding.left - padding.right) * (endTime - startTime);
+        },
+        price: function(py) {
+            return max - (py - padding.top) / (height - padding.top - padding.bottom) * (max - min);
+        },
+    };
+}
@@ -0,0 +1,32 @@
+// Sends the csrf_token cookie back with every state-changing request, see controller/csrf.go
+(function() {
+    function getCSRFToken() {
+        var match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : "";
+    }
+
+    // ajax
+    $.ajaxSetup({
+        beforeSend: function(xhr, settings) {
+            if (!/^(GET|HEAD|OPTIONS)$/i.test(settings.type)) {
+                xhr.setRequestHeader("X-CSRF-Token", getCSRFToken());
+            }
+        }
+    });
+
+    // html forms, NOTE form.submit() doesn't trigger the submit event, so the field is added in the beginning
+    function addCSRFField(form) {
+        if ($(form).attr("method") && $(form).attr("method").toUpperCase() === "POST") {
+            $(form).find("input[name='csrf_token']").remove();
+            $("<input>").attr({type: "hidden", name: "csrf_token", value: getCSRFToken()}).appendTo(form);
+        }
+    }
+    $(document).ready(function() {
+        $("form").each(function() {
+            addCSRFField(this);
+        });
+    });
+    $(document).on("submit", "form", function() {
+        addCSRFField(this);
+    });
+})();
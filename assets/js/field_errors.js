@@ -0,0 +1,11 @@
+// showFieldErrors highlights the fields of the strategy form by `fields` of the error response, e.g.
+// {"entry[price]": "..."}, and clears the ones of the last submit
+function showFieldErrors(strategyForm, fields) {
+    $(strategyForm).find('.is-invalid').removeClass('is-invalid');
+    $(strategyForm).find('.field-error').remove();
+    $.each(fields || {}, function(name, msg) {
+        var field = $(strategyForm).find('[name="' + name + '"]');
+        field.addClass('is-invalid');
+        field.after($('<div class="invalid-feedback field-error"></div>').text(msg));
+    });
+}
@@ -0,0 +1,93 @@
+// Price feeds of the exchanges, names are the same as contract_strategies.exchange
+// onPrice(exchange, symbol, price) is called on every trade
+const priceFeeds = {
+    "FTX": {
+        url: function (symbols) {
+            return "wss://ftx.com/ws/";
+        },
+        subscribe: function (ws, symbols) {
+            for (s of symbols) {
+                ws.send(`{"op": "subscribe", "channel": "trades", "market": "${s}"}`);
+            }
+        },
+        ping: '{"op":"ping"}',
+        parse: function (data) {
+            if (data.channel == "trades" && data.type == "update") {
+                return [data.market, data.data[0].price];
+            }
+        }
+    },
+    // USDⓈ-M futures
+    "BINANCE": {
+        url: function (symbols) {
+            return "wss://fstream.binance.com/stream?streams=" + symbols.map(s => s.toLowerCase() + "@aggTrade").join("/");
+        },
+        subscribe: function (ws, symbols) {},
+        ping: null, // answered by the browser
+        parse: function (data) {
+            if (data.data && data.data.e == "aggTrade") {
+                return [data.data.s, parseFloat(data.data.p)];
+            }
+        }
+    },
+    // USDT perpetual
+    "BYBIT": {
+        url: function (symbols) {
+            return "wss://stream.bybit.com/v5/public/linear";
+        },
+        subscribe: function (ws, symbols) {
+            ws.send(JSON.stringify({"op": "subscribe", "args": symbols.map(s => "publicTrade." + s)}));
+        },
+        ping: '{"op":"ping"}',
+        parse: function (data) {
+            if (data.topic && data.topic.startsWith("publicTrade.") && data.data.length > 0) {
+                return [data.data[0].s, parseFloat(data.data[0].p)];
+            }
+        }
+    }
+};
+
+// Paper accounts (e.g. PAPER_FTX) use the prices of the underlying exchange
+function initPriceFeed(exchange, symbols, onPrice) {
+    const feed = priceFeeds[exchange.replace(/^PAPER_/, "")];
+    if (!feed || symbols.length == 0) {
+        console.log("price feed not supported:", exchange);
+        return;
+    }
+
+    const url = feed.url(symbols);
+    const ws = new WebSocket(url);
+    console.log("connecting to ", url);
+    var pingTimer;
+
+    ws.onopen = function () {
+        feed.subscribe(ws, symbols);
+        if (feed.ping) {
+            pingTimer = setInterval(function () {
+                ws.send(feed.ping);
+            }, 10000);
+        }
+    };
+
+    ws.onclose = function () {
+        clearInterval(pingTimer);
+        setTimeout(function () {
+            initPriceFeed(exchange, symbols, onPrice);
+        }, 5000);
+    };
+
+    ws.onerror = function (error) {
+        console.log('WS error: ' + error);
+    };
+
+    // Stop updating if the price doesn't change
+    var lastPrice = {};
+    ws.onmessage = function (e) {
+        const trade = feed.parse(JSON.parse(e.data));
+        if (!trade || lastPrice[trade[0]] === trade[1]) {
+            return;
+        }
+        lastPrice[trade[0]] = trade[1];
+        onPrice(exchange, trade[0], trade[1]);
+    };
+}
@@ -0,0 +1,46 @@
+// initRiskSizing fills margin of the strategy form by the risk at the stop-loss, extra is posted along with the form,
+// e.g. {strategy_uuid: "..."} of the edit pages
+function initRiskSizing(strategyForm, extra) {
+    var timer = null;
+    var field = function(name) {
+        return $(strategyForm).find('[name="' + name + '"]');
+    };
+
+    var show = function(result, warning) {
+        $('#risk-sizing-result').text(result).toggleClass("d-none", !result);
+        $('#risk-sizing-warning').text(warning).toggleClass("d-none", !warning);
+    };
+
+    var refresh = function() {
+        if (field("margin_mode").val() == "fixed" || field("risk").val() == "") {
+            show("", "");
+            return;
+        }
+        var data = $(strategyForm).serializeArray();
+        $.each(extra || {}, function(name, value) {
+            data.push({ name: name, value: value });
+        });
+        $.post("/strategy/margin", $.param(data), function(resp) {
+            var sizing = resp.data;
+            field("margin").val(sizing.margin);
+            show("開倉價 " + sizing.entry_price + ", 停損價 " + sizing.stop_loss_price + ", 停損時虧損 " + sizing.risk_amount, sizing.warning || "");
+        }).fail(function(resp) {
+            show("", resp.responseJSON ? resp.responseJSON.error : "無法計算保證金");
+        });
+    };
+
+    // The margin is computed when the risk, entry or stop-loss changes
+    field("margin_mode").change(function() {
+        var fixed = $(this).val() == "fixed";
+        field("risk").prop("disabled", fixed);
+        field("margin").prop("readonly", !fixed);
+        refresh();
+    });
+    $(strategyForm).on("change", "input, select", function() {
+        if ($(this).attr("name") == "margin" || $(this).attr("name") == "margin_mode") {
+            return;
+        }
+        clearTimeout(timer);
+        timer = setTimeout(refresh, 500);
+    });
+}
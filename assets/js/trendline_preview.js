@@ -0,0 +1,115 @@
+// initTrendlinePreview draws the trendline of the strategy form, the two points can be dragged to update the form,
+// extra is posted along with the form, e.g. {strategy_uuid: "..."} of the edit page
+function initTrendlinePreview(strategyForm, extra) {
+    var canvas = $('#trendline-chart')[0];
+    var points = [], scale = null, dragging = 0, timer = null;
+
+    // Times of the form are in UTC, e.g. "2021-10-01 00:00"
+    var parseTime = function(value) {
+        return Date.parse(value.replace(" ", "T") + ":00Z");
+    };
+    var formatTime = function(ms) {
+        return new Date(ms).toISOString().substring(0, 16).replace("T", " ");
+    };
+    var field = function(name) {
+        return $(strategyForm).find('[name="' + name + '"]');
+    };
+    var anchors = function() {
+        return [1, 2].map(function(i) {
+            return {
+                i: i,
+                time: parseTime(field("entry[time_" + i + "]").val()),
+                price: parseFloat(field("entry[price_" + i + "]").val()),
+            };
+        });
+    };
+
+    var draw = function() {
+        scale = drawPriceChart(canvas, points, []);
+        if (!scale) {
+            return;
+        }
+        var ctx = canvas.getContext("2d");
+        var as = anchors().filter(function(a) {
+            return !isNaN(a.time) && !isNaN(a.price);
+        });
+        ctx.strokeStyle = "#6f42c1";
+        ctx.fillStyle = "#ffffff";
+        ctx.lineWidth = 2;
+        if (as.length == 2) {
+            ctx.beginPath();
+            ctx.moveTo(scale.x(as[0].time), scale.y(as[0].price));
+            ctx.lineTo(scale.x(as[1].time), scale.y(as[1].price));
+            ctx.stroke();
+        }
+        as.forEach(function(a) {
+            ctx.beginPath();
+            ctx.arc(scale.x(a.time), scale.y(a.price), 6, 0, 2 * Math.PI);
+            ctx.fill();
+            ctx.stroke();
+        });
+    };
+
+    var refresh = function() {
+        var data = $(strategyForm).serializeArray();
+        $.each(extra || {}, function(name, value) {
+            data.push({ name: name, value: value });
+        });
+        data.push({ name: "resolution", value: $('#trendline-resolution').val() });
+        $.post("/strategy/trendline_preview", $.param(data), function(resp) {
+            points = resp.data.points;
+            $('#trendline-preview-error').text("");
+            $('#trendline-preview-candles').text(resp.data.candles == 0 ? "尚無此合約的 K 線, 可於回測匯入" : "");
+            draw();
+        }).fail(function(data) {
+            $('#trendline-preview-error').text(data.responseJSON.error);
+        });
+    };
+    var schedule = function() {
+        clearTimeout(timer);
+        timer = setTimeout(refresh, 500);
+    };
+    $(strategyForm).on("change", schedule);
+    $('#trendline-resolution').on("change", schedule);
+    $(window).on("resize", draw);
+
+    // Drag the points
+    var position = function(e) {
+        var rect = canvas.getBoundingClientRect();
+        return { x: e.clientX - rect.left, y: e.clientY - rect.top };
+    };
+    $(canvas).on("mousedown", function(e) {
+        if (!scale) {
+            return;
+        }
+        var pos = position(e);
+        anchors().forEach(function(a) {
+            if (Math.abs(scale.x(a.time) - pos.x) <= 8 && Math.abs(scale.y(a.price) - pos.y) <= 8) {
+                dragging = a.i;
+            }
+        });
+    });
+    $(canvas).on("mousemove", function(e) {
+        if (!dragging) {
+            return;
+        }
+        var pos = position(e);
+        var time = formatTime(Math.round(scale.time(pos.x) / 60000) * 60000);
+        var timeField = field("entry[time_" + dragging + "]");
+        if (timeField[0]._flatpickr) {
+            timeField[0]._flatpickr.setDate(time, false);
+        } else {
+            timeField.val(time);
+        }
+        field("entry[price_" + dragging + "]").val(parseFloat(scale.price(pos.y).toPrecision(6)));
+        draw();
+    });
+    $(document).on("mouseup", function() {
+        if (dragging) {
+            dragging = 0;
+            refresh();
+        }
+    });
+
+    refresh();
+}
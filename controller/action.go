@@ -29,12 +29,24 @@ func (ctl *Controller) EnableStrategy(c *gin.Context) {
 	uuid := c.Param("uuid")
 
 	// Check permission
-	_, err := ctl.db.GetContractStrategyByUuidByUser(uuid, userCookie.Uuid)
+	strategy, err := ctl.db.GetContractStrategyByUuidByUser(uuid, userCookie.Uuid)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
 		return
 	}
 
+	// Market strategies open the position at once
+	if strategyEntryType(strategy.Params) == ENTRY_MARKET && contract.Status(strategy.PositionStatus) == contract.CLOSED {
+		if err = ctl.checkMarketEntry(c, strategy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Wait for the orders being changed by the site, see withStrategyDisabled
+	unlock := lockStrategy(uuid)
+	defer unlock()
+
 	// Send request to engine
 	path := fmt.Sprintf("/event?action=enable&uuid=%s", uuid)
 	_, err = ctl.makeRequestToEngine(path)
@@ -70,6 +82,10 @@ func (ctl *Controller) DisableStrategy(c *gin.Context) {
 		return
 	}
 
+	// Wait for the orders being changed by the site, see withStrategyDisabled
+	unlock := lockStrategy(uuid)
+	defer unlock()
+
 	// Send request to engine
 	path := fmt.Sprintf("/event?action=disable&uuid=%s", uuid)
 	_, err = ctl.makeRequestToEngine(path)
@@ -250,7 +266,7 @@ func (ctl *Controller) notBeingTrackedByEngine(c *gin.Context, uuid string) erro
 }
 
 func (ctl *Controller) closePosition(c *gin.Context, cs *db.ContractStrategy) error {
-	ex, err := ctl.newExchange(c)
+	ex, err := ctl.newExchange(c, cs.Uuid)
 	if err != nil {
 		return err
 	}
@@ -291,6 +307,34 @@ func (ctl *Controller) closePosition(c *gin.Context, cs *db.ContractStrategy) er
 	return nil
 }
 
+// checkMarketEntry makes sure the stop-loss and take-profit won't be triggered by the market price right after the
+// position is opened
+func (ctl *Controller) checkMarketEntry(c *gin.Context, cs *db.ContractStrategy) error {
+	ct, err := contract.NewContract(order.Side(cs.Side), cs.Params)
+	if err != nil {
+		ctl.log.Println("[ERROR] checkMarketEntry err:", err)
+		return errors.New("Internal error")
+	}
+
+	ex, err := ctl.newExchange(c, cs.Uuid)
+	if err != nil {
+		return err
+	}
+	price, err := ex.GetMarketPrice(cs.Symbol)
+	if err != nil {
+		ctl.log.Println("[ERROR] failed to get market price, err:", err)
+		return fmt.Errorf("%s server error: '%s'", cs.Exchange, err.Error())
+	}
+
+	if ct.StopLossOrder != nil && ct.StopLossOrder.GetTrigger() != nil && ct.StopLossOrder.GetTrigger().IsPriceTriggered(price) {
+		return fmt.Errorf("市價 %s 已達停損價, 請修改策略", price.String())
+	}
+	if ct.TakeProfitOrder != nil && ct.TakeProfitOrder.GetTrigger() != nil && ct.TakeProfitOrder.GetTrigger().IsPriceTriggered(price) {
+		return fmt.Errorf("市價 %s 已達停利價, 請修改策略", price.String())
+	}
+	return nil
+}
+
 func (ctl *Controller) unsetStopLossParamsAfterClosingPosition(cs *db.ContractStrategy) (params datatypes.JSONMap, err error) {
 	contract, err := contract.NewContract(order.Side(cs.Side), cs.Params)
 	if err != nil {
@@ -302,6 +346,9 @@ func (ctl *Controller) unsetStopLossParamsAfterClosingPosition(cs *db.ContractSt
 		"entry_type":  contract.EntryType,
 		"entry_order": contract.EntryOrder,
 	}
+	if strategyEntryType(cs.Params) == ENTRY_MARKET {
+		params[MARKET_ENTRY_PARAM] = true
+	}
 	if contract.StopLossOrder != nil {
 		// Unset stop-loss trigger as it will ben generated after entry triggered
 		if contract.EntryType == order.ENTRY_TRENDLINE {
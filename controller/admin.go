@@ -0,0 +1,406 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	engineDb "crypto-trading-bot-engine/db"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	USER_STATUS_ACTIVE   = "active"
+	USER_STATUS_DISABLED = "disabled"
+
+	// Invite links expire after this period by default
+	USER_INVITE_DEFAULT_EXPIRY_DAY = 7
+	USER_INVITE_MAX_EXPIRY_DAY     = 30
+)
+
+var usernameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+// for template
+type AdminUserTmpl struct {
+	Uuid           string
+	Username       string
+	Role           int64
+	TelegramChatId int64
+	Disabled       bool
+	Self           bool
+	LastLoginAt    string
+	CreatedAt      string
+}
+
+// for template
+type AdminInviteTmpl struct {
+	Uuid           string
+	Role           int64
+	TelegramChatId int64
+	Available      bool
+	Status         string
+	ExpiresAt      string
+	CreatedAt      string
+}
+
+// NOTE permission is checked by the middleware in router
+func (ctl *Controller) AdminListUsers(c *gin.Context) {
+	userData := ctl.getUserData(c)
+
+	var errMsg string
+	users, _, err := ctl.model.GetUsers()
+	if err != nil {
+		ctl.log.Println("AdminListUsers err:", err)
+		errMsg = "Internal error"
+	}
+	disabled, err := ctl.model.GetDisabledUserUuids()
+	if err != nil {
+		ctl.log.Println("AdminListUsers err:", err)
+		errMsg = "Internal error"
+	}
+	invites, _, err := ctl.model.GetUserInvites()
+	if err != nil {
+		ctl.log.Println("AdminListUsers err:", err)
+		errMsg = "Internal error"
+	}
+
+	loc := ctl.getUserLocation(userData.Uuid)
+	var userTmpls []AdminUserTmpl
+	for _, u := range users {
+		// Unlisted roles are treated as trader
+		role := u.Role
+		if !isValidRole(role) {
+			role = ROLE_TRADER
+		}
+		tmpl := AdminUserTmpl{
+			Uuid:           u.Uuid,
+			Username:       u.Username,
+			Role:           role,
+			TelegramChatId: u.TelegramChatId,
+			Disabled:       disabled[u.Uuid],
+			Self:           u.Uuid == userData.Uuid,
+			LastLoginAt:    "(未登入)",
+			CreatedAt:      u.CreatedAt.In(loc).Format("2006-01-02 15:04:05"),
+		}
+		if !u.LastLoginAt.IsZero() {
+			tmpl.LastLoginAt = u.LastLoginAt.In(loc).Format("2006-01-02 15:04:05")
+		}
+		userTmpls = append(userTmpls, tmpl)
+	}
+
+	var inviteTmpls []AdminInviteTmpl
+	for _, i := range invites {
+		tmpl := AdminInviteTmpl{
+			Uuid:           i.Uuid,
+			Role:           i.Role,
+			TelegramChatId: i.TelegramChatId,
+			Available:      i.IsAvailable(),
+			Status:         "未使用",
+			ExpiresAt:      i.ExpiresAt.In(loc).Format("2006-01-02 15:04:05"),
+			CreatedAt:      i.CreatedAt.In(loc).Format("2006-01-02 15:04:05"),
+		}
+		switch {
+		case i.UsedAt != nil:
+			tmpl.Status = "已使用"
+		case i.RevokedAt != nil:
+			tmpl.Status = "已撤銷"
+		case !i.IsAvailable():
+			tmpl.Status = "已過期"
+		}
+		inviteTmpls = append(inviteTmpls, tmpl)
+	}
+
+	c.HTML(http.StatusOK, "admin_users.html", gin.H{
+		"loggedIn": true,
+		"role":     userData.Role,
+		"error":    errMsg,
+		"users":    userTmpls,
+		"invites":  inviteTmpls,
+		"roles":    roleNames,
+	})
+}
+
+func (ctl *Controller) AdminCreateUser(c *gin.Context) {
+	user, err := ctl.newUser(c.PostForm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, _, err = ctl.model.CreateUser(user); err != nil {
+		ctl.failJSONWithVagueError(c, "AdminCreateUser", err)
+		return
+	}
+	ctl.log.Printf("[INFO] user '%s' is created by '%s'", user.Uuid, ctl.getUserData(c).Uuid)
+
+	c.JSON(http.StatusOK, gin.H{"uuid": user.Uuid})
+}
+
+func (ctl *Controller) AdminUpdateUser(c *gin.Context) {
+	user, err := ctl.db.GetUserByUuid(c.Param("uuid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用戶不存在"})
+		return
+	}
+	data, status, err := ctl.processUserUpdate(c, user, c.PostForm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err = ctl.updateUser(c, user, data, status); err != nil {
+		ctl.failJSONWithVagueError(c, "AdminUpdateUser", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// AdminCreateInvite returns the one-time link, which can't be retrieved again
+func (ctl *Controller) AdminCreateInvite(c *gin.Context) {
+	invite, err := newUserInvite(ctl.getUserData(c).Uuid, c.PostForm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, err := generateInviteToken()
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "AdminCreateInvite", err)
+		return
+	}
+	invite.TokenHash = hashInviteToken(token)
+	if _, _, err = ctl.model.CreateUserInvite(invite); err != nil {
+		ctl.failJSONWithVagueError(c, "AdminCreateInvite", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"path": "/invite/" + token})
+}
+
+func (ctl *Controller) AdminRevokeInvite(c *gin.Context) {
+	data := map[string]interface{}{
+		"revoked_at": time.Now(),
+	}
+	count, err := ctl.model.UpdateUserInvite(c.Param("uuid"), data)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "AdminRevokeInvite", err)
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "邀請不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// newUser validates the form of creating user, the user logs in with the one-time password sent to telegram
+func (ctl *Controller) newUser(form formGetter) (user engineDb.User, err error) {
+	username := strings.TrimSpace(form("username"))
+	if err = ctl.validateNewUsername(username); err != nil {
+		return
+	}
+	telegramChatId, err := strconv.ParseInt(form("telegram_chat_id"), 10, 64)
+	if err != nil || telegramChatId == 0 {
+		err = errors.New("telegram_chat_id is invalid")
+		return
+	}
+	role, err := strconv.ParseInt(form("role"), 10, 64)
+	if err != nil || !isValidRole(role) {
+		err = errors.New("role is invalid")
+		return
+	}
+
+	user = engineDb.User{
+		Uuid:              uuid.New().String(),
+		Username:          username,
+		Role:              role,
+		TelegramChatId:    telegramChatId,
+		PasswordExpiredAt: time.Now(),
+	}
+	return
+}
+
+func (ctl *Controller) validateNewUsername(username string) error {
+	if !usernameRegexp.MatchString(username) {
+		return errors.New("username must be 3-32 characters of letters, numbers, '_', '.' or '-'")
+	}
+	_, err := ctl.db.GetUserByUsername(username)
+	if err == nil {
+		return errors.New("username has been taken")
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		ctl.log.Println("[ERROR] validateNewUsername err:", err)
+		return errors.New("Internal error")
+	}
+	return nil
+}
+
+// processUserUpdate validates the optional fields `telegram_chat_id`, `role` and `status`
+func (ctl *Controller) processUserUpdate(c *gin.Context, user *engineDb.User, form formGetter) (data map[string]interface{}, status string, err error) {
+	data = make(map[string]interface{})
+	if v := form("telegram_chat_id"); v != "" {
+		telegramChatId, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || telegramChatId == 0 {
+			return data, status, errors.New("telegram_chat_id is invalid")
+		}
+		data["telegram_chat_id"] = telegramChatId
+	}
+
+	// Admins can't lock themselves out
+	isSelf := user.Uuid == ctl.getUserData(c).Uuid
+	if v := form("role"); v != "" {
+		role, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || !isValidRole(role) {
+			return data, status, errors.New("role is invalid")
+		}
+		if isSelf && role != user.Role {
+			return data, status, errors.New("無法變更自己的角色")
+		}
+		data["role"] = role
+	}
+	status = form("status")
+	switch status {
+	case "", USER_STATUS_ACTIVE:
+	case USER_STATUS_DISABLED:
+		if isSelf {
+			return data, status, errors.New("無法停用自己的帳號")
+		}
+	default:
+		return data, status, errors.New("status is invalid")
+	}
+	return
+}
+
+func (ctl *Controller) updateUser(c *gin.Context, user *engineDb.User, data map[string]interface{}, status string) error {
+	adminUuid := ctl.getUserData(c).Uuid
+	if len(data) > 0 {
+		if _, err := ctl.db.UpdateUser(user.Uuid, data); err != nil {
+			return err
+		}
+		ctl.log.Printf("[INFO] user '%s' is updated by '%s', data: %v", user.Uuid, adminUuid, data)
+	}
+	if v, ok := data["telegram_chat_id"]; ok && v != user.TelegramChatId {
+		ctl.recordAudit(c, user.Uuid, model.AUDIT_ACTION_TELEGRAM_CHAT_ID_UPDATE, map[string]interface{}{
+			"from": user.TelegramChatId,
+			"to":   v,
+		})
+	}
+	if v, ok := data["role"]; ok && v != user.Role {
+		ctl.recordAudit(c, user.Uuid, model.AUDIT_ACTION_ROLE_UPDATE, map[string]interface{}{
+			"from": user.Role,
+			"to":   v,
+		})
+	}
+
+	switch status {
+	case USER_STATUS_ACTIVE:
+		if err := ctl.model.SaveUserAuthSetting(user.Uuid, map[string]interface{}{"disabled_at": nil}); err != nil {
+			return err
+		}
+		ctl.log.Printf("[INFO] user '%s' is enabled by '%s'", user.Uuid, adminUuid)
+		ctl.recordAudit(c, user.Uuid, model.AUDIT_ACTION_STATUS_UPDATE, map[string]interface{}{"to": status})
+	case USER_STATUS_DISABLED:
+		if err := ctl.disableUser(user.Uuid); err != nil {
+			return err
+		}
+		ctl.log.Printf("[INFO] user '%s' is disabled by '%s'", user.Uuid, adminUuid)
+		ctl.recordAudit(c, user.Uuid, model.AUDIT_ACTION_STATUS_UPDATE, map[string]interface{}{"to": status})
+	}
+	return nil
+}
+
+// disableUser logs out the user and stops all the strategies of the user
+func (ctl *Controller) disableUser(userUuid string) error {
+	if err := ctl.model.SaveUserAuthSetting(userUuid, map[string]interface{}{"disabled_at": time.Now()}); err != nil {
+		return err
+	}
+	if _, err := ctl.model.RevokeUserSessionsByUser(userUuid, ""); err != nil {
+		return err
+	}
+	if err := ctl.model.IncrUserSessionVersion(userUuid); err != nil {
+		return err
+	}
+
+	strategies, _, err := ctl.db.GetContractStrategiesByUser(userUuid)
+	if err != nil {
+		return err
+	}
+	for _, s := range strategies {
+		if s.Enabled != 1 {
+			continue
+		}
+
+		// NOTE same as DisableStrategy, allow strategy to be disabled while engine server is down
+		unlock := lockStrategy(s.Uuid)
+		path := fmt.Sprintf("/event?action=disable&uuid=%s", s.Uuid)
+		if _, err := ctl.makeRequestToEngine(path); err != nil {
+			ctl.log.Println("failed to call engine, err:", err)
+		}
+		data := map[string]interface{}{
+			"enabled": 0,
+		}
+		_, err := ctl.db.UpdateContractStrategy(s.Uuid, data)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newUserInvite validates the form of creating invite, `telegram_chat_id` is optional
+func newUserInvite(createdBy string, form formGetter) (invite model.UserInvite, err error) {
+	role, err := strconv.ParseInt(form("role"), 10, 64)
+	if err != nil || !isValidRole(role) {
+		err = errors.New("role is invalid")
+		return
+	}
+	var telegramChatId int64
+	if v := form("telegram_chat_id"); v != "" {
+		telegramChatId, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			err = errors.New("telegram_chat_id is invalid")
+			return
+		}
+	}
+	expiryDay := int64(USER_INVITE_DEFAULT_EXPIRY_DAY)
+	if v := form("expiry_day"); v != "" {
+		expiryDay, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || expiryDay < 1 || expiryDay > USER_INVITE_MAX_EXPIRY_DAY {
+			err = fmt.Errorf("expiry_day must be between 1 and %d", USER_INVITE_MAX_EXPIRY_DAY)
+			return
+		}
+	}
+
+	invite = model.UserInvite{
+		Uuid:           uuid.New().String(),
+		Role:           role,
+		TelegramChatId: telegramChatId,
+		CreatedBy:      createdBy,
+		ExpiresAt:      time.Now().Add(time.Second * 86400 * time.Duration(expiryDay)),
+	}
+	return
+}
+
+func generateInviteToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NOTE tokens are random enough, same as hashApiToken
+func hashInviteToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
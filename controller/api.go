@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Page size of list endpoints
+	API_DEFAULT_PER_PAGE = 20
+	API_MAX_PER_PAGE     = 100
+)
+
+// Error codes returned along with the error message, e.g. {"code": "not_found", "error": "strategy not found"}
+const (
+	API_ERR_UNAUTHORIZED   = "unauthorized"
+	API_ERR_FORBIDDEN      = "forbidden"
+	API_ERR_NOT_FOUND      = "not_found"
+	API_ERR_INVALID_PARAMS = "invalid_params"
+	API_ERR_INVALID_STATE  = "invalid_state"
+	API_ERR_EXCHANGE       = "exchange_error"
+	API_ERR_INTERNAL       = "internal_error"
+)
+
+type APIPagination struct {
+	Page    int   `json:"page"`
+	PerPage int   `json:"per_page"`
+	Total   int64 `json:"total"`
+}
+
+func (ctl *Controller) failAPI(c *gin.Context, status int, code string, msg string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"code":  code,
+		"error": msg,
+	})
+}
+
+// NOTE intentionally provide vague error for security purpose, same as failJSONWithVagueError
+func (ctl *Controller) failAPIWithInternalError(c *gin.Context, caller string, err error) {
+	ctl.log.Printf("[ERROR] %s err: %s", caller, err.Error())
+	ctl.failAPI(c, http.StatusInternalServerError, API_ERR_INTERNAL, "Internal error")
+}
+
+// getPagination parses `page` and `per_page` from query string
+func getPagination(c *gin.Context) (p APIPagination, err error) {
+	p.Page = 1
+	p.PerPage = API_DEFAULT_PER_PAGE
+
+	if page := c.Query("page"); page != "" {
+		p.Page, err = strconv.Atoi(page)
+		if err != nil || p.Page < 1 {
+			err = errors.New("page is invalid")
+			return
+		}
+	}
+	if perPage := c.Query("per_page"); perPage != "" {
+		p.PerPage, err = strconv.Atoi(perPage)
+		if err != nil || p.PerPage < 1 || p.PerPage > API_MAX_PER_PAGE {
+			err = errors.New("per_page is invalid")
+			return
+		}
+	}
+	return
+}
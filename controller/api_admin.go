@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// for API response
+type APIUser struct {
+	Uuid           string     `json:"uuid"`
+	Username       string     `json:"username"`
+	Role           int64      `json:"role"`
+	TelegramChatId int64      `json:"telegram_chat_id"`
+	Status         string     `json:"status"`
+	LastLoginAt    *time.Time `json:"last_login_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Post and patch params, all fields are optional when patching
+type APIUserRequest struct {
+	Username       string      `json:"username"`
+	TelegramChatId json.Number `json:"telegram_chat_id"`
+	Role           json.Number `json:"role"`
+	Status         string      `json:"status"`
+}
+
+// Post params
+type APIInviteRequest struct {
+	TelegramChatId json.Number `json:"telegram_chat_id"`
+	Role           json.Number `json:"role"`
+	ExpiryDay      json.Number `json:"expiry_day"`
+}
+
+func (ctl *Controller) APIAdminListUsers(c *gin.Context) {
+	users, _, err := ctl.model.GetUsers()
+	if err != nil {
+		ctl.failAPIWithInternalError(c, "APIAdminListUsers", err)
+		return
+	}
+	disabled, err := ctl.model.GetDisabledUserUuids()
+	if err != nil {
+		ctl.failAPIWithInternalError(c, "APIAdminListUsers", err)
+		return
+	}
+
+	data := []APIUser{}
+	for _, u := range users {
+		user := APIUser{
+			Uuid:           u.Uuid,
+			Username:       u.Username,
+			Role:           u.Role,
+			TelegramChatId: u.TelegramChatId,
+			Status:         USER_STATUS_ACTIVE,
+			CreatedAt:      u.CreatedAt,
+		}
+		if !isValidRole(user.Role) {
+			user.Role = ROLE_TRADER
+		}
+		if disabled[u.Uuid] {
+			user.Status = USER_STATUS_DISABLED
+		}
+		if !u.LastLoginAt.IsZero() {
+			lastLoginAt := u.LastLoginAt
+			user.LastLoginAt = &lastLoginAt
+		}
+		data = append(data, user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (ctl *Controller) APIAdminCreateUser(c *gin.Context) {
+	var req APIUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, "request body is invalid")
+		return
+	}
+
+	// Same validation as the html form
+	user, err := ctl.newUser(req.toForm().Get)
+	if err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+	if _, _, err = ctl.model.CreateUser(user); err != nil {
+		ctl.failAPIWithInternalError(c, "APIAdminCreateUser", err)
+		return
+	}
+	ctl.log.Printf("[INFO] user '%s' is created by '%s'", user.Uuid, ctl.getUserData(c).Uuid)
+
+	c.JSON(http.StatusCreated, gin.H{"data": APIUser{
+		Uuid:           user.Uuid,
+		Username:       user.Username,
+		Role:           user.Role,
+		TelegramChatId: user.TelegramChatId,
+		Status:         USER_STATUS_ACTIVE,
+		CreatedAt:      time.Now(),
+	}})
+}
+
+func (ctl *Controller) APIAdminUpdateUser(c *gin.Context) {
+	user, err := ctl.db.GetUserByUuid(c.Param("uuid"))
+	if err != nil {
+		ctl.failAPI(c, http.StatusNotFound, API_ERR_NOT_FOUND, "user not found")
+		return
+	}
+
+	var req APIUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, "request body is invalid")
+		return
+	}
+	data, status, err := ctl.processUserUpdate(c, user, req.toForm().Get)
+	if err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+	if err = ctl.updateUser(c, user, data, status); err != nil {
+		ctl.failAPIWithInternalError(c, "APIAdminUpdateUser", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// APIAdminCreateInvite returns the one-time link, which can't be retrieved again
+func (ctl *Controller) APIAdminCreateInvite(c *gin.Context) {
+	var req APIInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, "request body is invalid")
+		return
+	}
+	invite, err := newUserInvite(ctl.getUserData(c).Uuid, req.toForm().Get)
+	if err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+	token, err := generateInviteToken()
+	if err != nil {
+		ctl.failAPIWithInternalError(c, "APIAdminCreateInvite", err)
+		return
+	}
+	invite.TokenHash = hashInviteToken(token)
+	if _, _, err = ctl.model.CreateUserInvite(invite); err != nil {
+		ctl.failAPIWithInternalError(c, "APIAdminCreateInvite", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"uuid":       invite.Uuid,
+		"path":       "/invite/" + token,
+		"expires_at": invite.ExpiresAt,
+	}})
+}
+
+// toForm converts the request into the same keys as the html forms
+func (req *APIUserRequest) toForm() url.Values {
+	values := url.Values{}
+	values.Set("username", req.Username)
+	values.Set("telegram_chat_id", req.TelegramChatId.String())
+	values.Set("role", req.Role.String())
+	values.Set("status", req.Status)
+	return values
+}
+
+func (req *APIInviteRequest) toForm() url.Values {
+	values := url.Values{}
+	values.Set("telegram_chat_id", req.TelegramChatId.String())
+	values.Set("role", req.Role.String())
+	values.Set("expiry_day", req.ExpiryDay.String())
+	return values
+}
@@ -0,0 +1,556 @@
+package controller
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/strategy/trigger"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// for API response
+type APIStrategy struct {
+	Uuid                  string                 `json:"uuid"`
+	Exchange              string                 `json:"exchange"`
+	Symbol                string                 `json:"symbol"`
+	Side                  int64                  `json:"side"`
+	Margin                string                 `json:"margin"`
+	Enabled               bool                   `json:"enabled"`
+	PositionStatus        int64                  `json:"position_status"`
+	EntryType             string                 `json:"entry_type"`
+	Entry                 *APIOrder              `json:"entry"`
+	StopLoss              *APIOrder              `json:"stop_loss"`
+	TakeProfit            *APIOrder              `json:"take_profit"`
+	ExchangeOrdersDetails map[string]interface{} `json:"exchange_orders_details"`
+	Comment               string                 `json:"comment"`
+	LastPositionAt        *time.Time             `json:"last_position_at"`
+	CreatedAt             time.Time              `json:"created_at"`
+	UpdatedAt             time.Time              `json:"updated_at"`
+}
+
+// Percentages are in the same unit as the html forms, e.g. "1" means 1%
+type APIOrder struct {
+	TriggerType string `json:"trigger_type,omitempty"`
+	Operator    string `json:"operator,omitempty"`
+	Price       string `json:"price,omitempty"` // the trigger price at the moment
+
+	// entry
+	FlipOperatorEnabled    *bool      `json:"flip_operator_enabled,omitempty"`
+	Time1                  *time.Time `json:"time_1,omitempty"`
+	Price1                 string     `json:"price_1,omitempty"`
+	Time2                  *time.Time `json:"time_2,omitempty"`
+	Price2                 string     `json:"price_2,omitempty"`
+	TrendlineOffsetPercent string     `json:"trendline_offset_percent,omitempty"`
+
+	// trendline stop-loss
+	LossTolerancePercent         string `json:"loss_tolerance_percent,omitempty"`
+	TrendlineReadjustmentEnabled *bool  `json:"trendline_readjustment_enabled,omitempty"`
+
+	// trailing stop-loss, trailing_value is percent (e.g. "1" means 1%) or price difference by trailing_mode
+	TrailingEnabled         *bool  `json:"trailing_enabled,omitempty"`
+	TrailingMode            string `json:"trailing_mode,omitempty"`
+	TrailingValue           string `json:"trailing_value,omitempty"`
+	TrailingActivationPrice string `json:"trailing_activation_price,omitempty"`
+
+	// break-even rule, percents as the html forms
+	BreakEvenEnabled       *bool  `json:"break_even_enabled,omitempty"`
+	BreakEvenMode          string `json:"break_even_mode,omitempty"`
+	BreakEvenPercent       string `json:"break_even_percent,omitempty"`
+	BreakEvenOffsetPercent string `json:"break_even_offset_percent,omitempty"`
+
+	// take-profit levels, the request keys are level_price_N and level_percent_N as the html forms
+	Levels []APITakeProfitLevel `json:"levels,omitempty"`
+}
+
+// Partial take-profit level, percent of the opened size, e.g. "50" means 50%
+type APITakeProfitLevel struct {
+	Price   string `json:"price"`
+	Percent string `json:"percent"`
+}
+
+// Post params
+// Keys of entry, stop_loss and take_profit are the same as the html forms, e.g. `entry[price]` -> {"entry": {"price": "57000"}}
+type APIStrategyRequest struct {
+	Symbol     string                 `json:"symbol"`
+	Side       json.Number            `json:"side"`
+	Margin     json.Number            `json:"margin"`
+	MarginMode string                 `json:"margin_mode"` // fixed (default), risk_percent or risk_amount
+	Risk       json.Number            `json:"risk"`        // the margin is computed by it unless margin_mode is fixed
+	EntryType  string                 `json:"entry_type"`
+	Entry      map[string]interface{} `json:"entry"`
+	StopLoss   map[string]interface{} `json:"stop_loss"`
+	TakeProfit map[string]interface{} `json:"take_profit"`
+	Comment    *string                `json:"comment"`
+
+	// Only used by creating, empty means the default credential
+	CredentialUuid string `json:"credential_uuid"`
+}
+
+// Patch params
+type APITpSlRequest struct {
+	StopLoss   map[string]interface{} `json:"stop_loss"`
+	TakeProfit map[string]interface{} `json:"take_profit"`
+	Comment    *string                `json:"comment"`
+}
+
+func (ctl *Controller) APIListStrategies(c *gin.Context) {
+	if !ctl.apiAuthCheck(c) {
+		return
+	}
+
+	pagination, err := getPagination(c)
+	if err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+
+	userCookie := ctl.getUserData(c)
+	result := ctl.db.GormDB.Model(&db.ContractStrategy{}).Where("user_uuid = ?", userCookie.Uuid).Count(&pagination.Total)
+	if result.Error != nil {
+		ctl.failAPIWithInternalError(c, "APIListStrategies", result.Error)
+		return
+	}
+
+	var css []db.ContractStrategy
+	result = ctl.db.GormDB.Where("user_uuid = ?", userCookie.Uuid).
+		Order("created_at DESC").
+		Limit(pagination.PerPage).
+		Offset((pagination.Page - 1) * pagination.PerPage).
+		Find(&css)
+	if result.Error != nil {
+		ctl.failAPIWithInternalError(c, "APIListStrategies", result.Error)
+		return
+	}
+
+	strategies := []APIStrategy{}
+	for i := range css {
+		s, err := newAPIStrategy(&css[i])
+		if err != nil {
+			ctl.failAPIWithInternalError(c, "APIListStrategies", err)
+			return
+		}
+		strategies = append(strategies, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       strategies,
+		"pagination": pagination,
+	})
+}
+
+func (ctl *Controller) APIGetStrategy(c *gin.Context) {
+	if !ctl.apiAuthCheck(c) {
+		return
+	}
+	userCookie := ctl.getUserData(c)
+
+	// Check permission
+	strategy, err := ctl.db.GetContractStrategyByUuidByUser(c.Param("uuid"), userCookie.Uuid)
+	if err != nil {
+		ctl.failAPI(c, http.StatusNotFound, API_ERR_NOT_FOUND, "strategy not found")
+		return
+	}
+
+	s, err := newAPIStrategy(strategy)
+	if err != nil {
+		ctl.failAPIWithInternalError(c, "APIGetStrategy", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": s})
+}
+
+func (ctl *Controller) APICreateStrategy(c *gin.Context) {
+	if !ctl.apiAuthCheck(c) {
+		return
+	}
+	userCookie := ctl.getUserData(c)
+
+	var req APIStrategyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, "request body is invalid")
+		return
+	}
+
+	// Same validation as the html form, the exchange is of the credential
+	credential, err := ctl.validateStrategyCredential(userCookie.Uuid, req.CredentialUuid)
+	if err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+	strategy, err := ctl.newContractStrategy(userCookie.Uuid, strategyExchange(credential), req.toForm().Get)
+	if err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+	if err = ctl.validateNewStrategy(userCookie.Uuid, req.toForm().Get, &strategy); err != nil {
+		ctl.failAPIWithFields(c, err)
+		return
+	}
+
+	insertId, count, err := ctl.db.CreateContractStrategy(strategy)
+	if err != nil {
+		// Capture `Error 1406: Data too long for column 'comment' at row 1`
+		if strings.Contains(err.Error(), "comment") {
+			ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, "comment is too long")
+			return
+		}
+		ctl.failAPIWithInternalError(c, "APICreateStrategy", err)
+		return
+	}
+	if insertId == 0 && count == 0 {
+		ctl.failAPIWithInternalError(c, "APICreateStrategy", fmt.Errorf("insert id or count is 0"))
+		return
+	}
+	if credential != nil {
+		if err = ctl.model.CreateContractStrategyCredential(strategy.Uuid, credential.Uuid); err != nil {
+			ctl.failAPIWithInternalError(c, "APICreateStrategy", err)
+			return
+		}
+	}
+
+	created, err := ctl.db.GetContractStrategyByUuidByUser(strategy.Uuid, userCookie.Uuid)
+	if err != nil {
+		ctl.failAPIWithInternalError(c, "APICreateStrategy", err)
+		return
+	}
+	s, err := newAPIStrategy(created)
+	if err != nil {
+		ctl.failAPIWithInternalError(c, "APICreateStrategy", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": s})
+}
+
+func (ctl *Controller) APIUpdateStrategy(c *gin.Context) {
+	if !ctl.apiAuthCheck(c) {
+		return
+	}
+	userCookie := ctl.getUserData(c)
+	uuid := c.Param("uuid")
+
+	// Check permission
+	strategy, err := ctl.db.GetContractStrategyByUuidByUser(uuid, userCookie.Uuid)
+	if err != nil {
+		ctl.failAPI(c, http.StatusNotFound, API_ERR_NOT_FOUND, "strategy not found")
+		return
+	}
+
+	var req APIStrategyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, "request body is invalid")
+		return
+	}
+
+	// Make sure the status has been disabed and position status is closed
+	if strategy.Enabled != 0 || contract.Status(strategy.PositionStatus) != contract.CLOSED {
+		ctl.failAPI(c, http.StatusConflict, API_ERR_INVALID_STATE, "strategy must be disabled and position must be closed")
+		return
+	}
+
+	// Make sure it's not tracked by engine
+	if err = ctl.notBeingTrackedByEngine(c, uuid); err != nil {
+		ctl.failAPI(c, http.StatusConflict, API_ERR_INVALID_STATE, err.Error())
+		return
+	}
+
+	// Keep the entry type and comment if they're not given
+	if req.EntryType == "" {
+		req.EntryType = strategyEntryType(strategy.Params)
+	}
+	if req.Comment == nil {
+		req.Comment = &strategy.Comment
+	}
+
+	// Same validation as the html form
+	data, err := ctl.processStrategyUpdate(strategy, req.EntryType, req.toForm().Get)
+	if err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+	if err = ctl.validateStrategyUpdate(strategy, data); err != nil {
+		ctl.failAPIWithFields(c, err)
+		return
+	}
+	if _, err := ctl.db.UpdateContractStrategy(uuid, data); err != nil {
+		ctl.failAPIWithInternalError(c, "APIUpdateStrategy", err)
+		return
+	}
+
+	ctl.respondAPIStrategy(c, uuid, userCookie.Uuid)
+}
+
+func (ctl *Controller) APIDeleteStrategy(c *gin.Context) {
+	if !ctl.apiAuthCheck(c) {
+		return
+	}
+	userCookie := ctl.getUserData(c)
+	uuid := c.Param("uuid")
+
+	// Check permission
+	strategy, err := ctl.db.GetContractStrategyByUuidByUser(uuid, userCookie.Uuid)
+	if err != nil {
+		ctl.failAPI(c, http.StatusNotFound, API_ERR_NOT_FOUND, "strategy not found")
+		return
+	}
+
+	// Make sure the status has been disabed and position status is closed
+	if strategy.Enabled != 0 || contract.Status(strategy.PositionStatus) != contract.CLOSED {
+		ctl.failAPI(c, http.StatusConflict, API_ERR_INVALID_STATE, "strategy must be disabled and position must be closed")
+		return
+	}
+
+	// Make sure it's not tracked by engine
+	if err = ctl.notBeingTrackedByEngine(c, uuid); err != nil {
+		ctl.failAPI(c, http.StatusConflict, API_ERR_INVALID_STATE, err.Error())
+		return
+	}
+
+	if err = ctl.deleteContractStrategy(strategy); err != nil {
+		ctl.failAPIWithInternalError(c, "APIDeleteStrategy", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (ctl *Controller) APIUpdateTpSl(c *gin.Context) {
+	if !ctl.apiAuthCheck(c) {
+		return
+	}
+	userCookie := ctl.getUserData(c)
+	uuid := c.Param("uuid")
+
+	// Check permission
+	strategy, err := ctl.db.GetContractStrategyByUuidByUser(uuid, userCookie.Uuid)
+	if err != nil {
+		ctl.failAPI(c, http.StatusNotFound, API_ERR_NOT_FOUND, "strategy not found")
+		return
+	}
+
+	var req APITpSlRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, "request body is invalid")
+		return
+	}
+
+	// Make sure the status has been disabed and position status is known
+	if strategy.Enabled != 0 || contract.Status(strategy.PositionStatus) == contract.UNKNOWN {
+		ctl.failAPI(c, http.StatusConflict, API_ERR_INVALID_STATE, "strategy must be disabled and position status must be known")
+		return
+	}
+
+	// Make sure it's not tracked by engine
+	if err = ctl.notBeingTrackedByEngine(c, uuid); err != nil {
+		ctl.failAPI(c, http.StatusConflict, API_ERR_INVALID_STATE, err.Error())
+		return
+	}
+
+	ex, err := ctl.newExchange(c, uuid)
+	if err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_EXCHANGE, err.Error())
+		return
+	}
+
+	values := url.Values{}
+	setOrderFormValues(values, "stop_loss", req.StopLoss)
+	setOrderFormValues(values, "take_profit", req.TakeProfit)
+	if err := ctl.validateTpSl(ex, strategy, values.Get); err != nil {
+		ctl.failAPIWithFields(c, err)
+		return
+	}
+	if err := ctl.applyTpSl(ex, strategy, values.Get); err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+
+	comment := strategy.Comment
+	if req.Comment != nil {
+		comment = *req.Comment
+	}
+	data := map[string]interface{}{
+		"params":                  strategy.Params,
+		"exchange_orders_details": strategy.ExchangeOrdersDetails,
+		"comment":                 comment,
+	}
+	if _, err := ctl.db.UpdateContractStrategy(uuid, data); err != nil {
+		ctl.failAPIWithInternalError(c, "APIUpdateTpSl", err)
+		return
+	}
+
+	ctl.respondAPIStrategy(c, uuid, userCookie.Uuid)
+}
+
+func (ctl *Controller) respondAPIStrategy(c *gin.Context, uuid string, userUuid string) {
+	strategy, err := ctl.db.GetContractStrategyByUuidByUser(uuid, userUuid)
+	if err != nil {
+		ctl.failAPIWithInternalError(c, "respondAPIStrategy", err)
+		return
+	}
+	s, err := newAPIStrategy(strategy)
+	if err != nil {
+		ctl.failAPIWithInternalError(c, "respondAPIStrategy", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": s})
+}
+
+// toForm converts the request into the same keys as the html forms
+func (req *APIStrategyRequest) toForm() url.Values {
+	values := url.Values{}
+	values.Set("symbol", req.Symbol)
+	values.Set("side", req.Side.String())
+	values.Set("margin", req.Margin.String())
+	values.Set("margin_mode", req.MarginMode)
+	values.Set("risk", req.Risk.String())
+	values.Set("entry_type", req.EntryType)
+	if req.Comment != nil {
+		values.Set("comment", *req.Comment)
+	}
+	for key, value := range req.Entry {
+		values.Set(fmt.Sprintf("entry[%s]", key), formValue(value))
+	}
+	setOrderFormValues(values, "stop_loss", req.StopLoss)
+	setOrderFormValues(values, "take_profit", req.TakeProfit)
+	return values
+}
+
+// setOrderFormValues sets `<name>[enabled]` to 1 if the order is given, unless it's specified explicitly
+func setOrderFormValues(values url.Values, name string, params map[string]interface{}) {
+	if params == nil {
+		values.Set(fmt.Sprintf("%s[enabled]", name), "0")
+		return
+	}
+	values.Set(fmt.Sprintf("%s[enabled]", name), "1")
+	for key, value := range params {
+		values.Set(fmt.Sprintf("%s[%s]", name, key), formValue(value))
+	}
+}
+
+// formValue converts json value into form value, e.g. true -> "1"
+func formValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case bool:
+		if value {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case json.Number:
+		return value.String()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func newAPIStrategy(cs *db.ContractStrategy) (s APIStrategy, err error) {
+	s = APIStrategy{
+		Uuid:                  cs.Uuid,
+		Exchange:              cs.Exchange,
+		Symbol:                cs.Symbol,
+		Side:                  cs.Side,
+		Margin:                cs.Margin.String(),
+		Enabled:               cs.Enabled == 1,
+		PositionStatus:        cs.PositionStatus,
+		ExchangeOrdersDetails: cs.ExchangeOrdersDetails,
+		Comment:               cs.Comment,
+		CreatedAt:             cs.CreatedAt,
+		UpdatedAt:             cs.UpdatedAt,
+	}
+	if cs.LastPositionAt.Unix() > 0 {
+		lastPositionAt := cs.LastPositionAt
+		s.LastPositionAt = &lastPositionAt
+	}
+	if len(cs.Params) == 0 {
+		return
+	}
+
+	contract, err := contract.NewContract(order.Side(cs.Side), cs.Params)
+	if err != nil {
+		return
+	}
+	s.EntryType = strategyEntryType(cs.Params)
+
+	// entry, market strategies don't have the entry trigger
+	entry := contract.EntryOrder.(*order.Entry)
+	flipOperatorEnabled := entry.FlipOperatorEnabled
+	if s.EntryType != ENTRY_MARKET {
+		s.Entry = newAPIOrder(contract.EntryOrder.GetTrigger())
+		s.Entry.FlipOperatorEnabled = &flipOperatorEnabled
+	}
+	if contract.EntryType == order.ENTRY_TRENDLINE {
+		line := entry.TrendlineTrigger.(*trigger.Line)
+		s.Entry.Operator = line.GetOperator()
+		s.Entry.Time1 = &line.Time1
+		s.Entry.Price1 = fmt.Sprint(line.Price1)
+		s.Entry.Time2 = &line.Time2
+		s.Entry.Price2 = fmt.Sprint(line.Price2)
+		s.Entry.TrendlineOffsetPercent = decimal.NewFromFloat(entry.TrendlineOffsetPercent).Mul(decimal.NewFromInt(100)).String()
+	}
+
+	// stop-loss
+	if contract.StopLossOrder != nil {
+		// If entry_type is trendline, stop-loss trigger will be filled after entry triggered
+		s.StopLoss = newAPIOrder(contract.StopLossOrder.GetTrigger())
+		if contract.EntryType == order.ENTRY_TRENDLINE {
+			stopLoss := contract.StopLossOrder.(*order.StopLoss)
+			readjustmentEnabled := stopLoss.TrendlineReadjustmentEnabled
+			s.StopLoss.LossTolerancePercent = decimal.NewFromFloat(stopLoss.LossTolerancePercent).Mul(decimal.NewFromInt(100)).String()
+			s.StopLoss.TrendlineReadjustmentEnabled = &readjustmentEnabled
+		}
+		if t := getTrailingStop(cs.Params); t != nil {
+			trailingEnabled := true
+			s.StopLoss.TrailingEnabled = &trailingEnabled
+			s.StopLoss.TrailingMode = t.Mode
+			s.StopLoss.TrailingValue = t.formValue()
+			if t.ActivationPrice.IsPositive() {
+				s.StopLoss.TrailingActivationPrice = t.ActivationPrice.String()
+			}
+		}
+		if b := getBreakEven(cs.Params); b != nil {
+			breakEvenEnabled := true
+			s.StopLoss.BreakEvenEnabled = &breakEvenEnabled
+			s.StopLoss.BreakEvenMode = b.Mode
+			if b.Mode == BREAK_EVEN_PERCENT {
+				s.StopLoss.BreakEvenPercent = b.Percent.Mul(decimal.NewFromInt(100)).String()
+			}
+			if b.OffsetPercent.IsPositive() {
+				s.StopLoss.BreakEvenOffsetPercent = b.OffsetPercent.Mul(decimal.NewFromInt(100)).String()
+			}
+		}
+	}
+
+	// take-profit
+	if contract.TakeProfitOrder != nil {
+		s.TakeProfit = newAPIOrder(contract.TakeProfitOrder.GetTrigger())
+		for _, level := range getTakeProfitLevels(cs.Params) {
+			s.TakeProfit.Levels = append(s.TakeProfit.Levels, APITakeProfitLevel{Price: level.Price.String(), Percent: level.formPercent()})
+		}
+	}
+	return
+}
+
+func newAPIOrder(t trigger.Trigger) *APIOrder {
+	o := &APIOrder{}
+	if t != nil {
+		o.TriggerType = t.GetTriggerType()
+		o.Operator = t.GetOperator()
+		o.Price = t.GetPrice(time.Now()).String()
+	}
+	return o
+}
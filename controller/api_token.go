@@ -0,0 +1,261 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// Prefix of personal API tokens, makes them easier to be recognised in logs or leaked secrets scanning
+	API_TOKEN_PREFIX = "fmb_"
+)
+
+// for template
+type ApiTokenTmpl struct {
+	Uuid       string
+	Name       string
+	Scopes     string
+	Active     bool
+	ExpiresAt  string
+	LastUsedAt string
+	CreatedAt  string
+}
+
+func (ctl *Controller) ListApiTokens(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+
+	// API tokens can't be managed by API token
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var errMsg string
+	tokens, _, err := ctl.model.GetApiTokensByUser(userData.Uuid)
+	if err != nil {
+		ctl.log.Println("ListApiTokens err:", err)
+		errMsg = "Internal error"
+	}
+
+	loc := ctl.getUserLocation(userData.Uuid)
+	var tokenTmpls []ApiTokenTmpl
+	for _, t := range tokens {
+		tmpl := ApiTokenTmpl{
+			Uuid:       t.Uuid,
+			Name:       t.Name,
+			Scopes:     t.Scopes,
+			Active:     t.IsActive(),
+			ExpiresAt:  "(永久)",
+			LastUsedAt: "(未使用)",
+			CreatedAt:  t.CreatedAt.In(loc).Format("2006-01-02 15:04:05"),
+		}
+		if t.ExpiresAt != nil {
+			tmpl.ExpiresAt = t.ExpiresAt.In(loc).Format("2006-01-02 15:04:05")
+		}
+		if t.LastUsedAt != nil {
+			tmpl.LastUsedAt = t.LastUsedAt.In(loc).Format("2006-01-02 15:04:05")
+		}
+		tokenTmpls = append(tokenTmpls, tmpl)
+	}
+
+	c.HTML(http.StatusOK, "api_tokens.html", gin.H{
+		"loggedIn": true,
+		"role":     userData.Role,
+		"error":    errMsg,
+		"tokens":   tokenTmpls,
+	})
+}
+
+func (ctl *Controller) CreateApiToken(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+
+	// API tokens can't be managed by API token
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// Validate name
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" || len(name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is invalid"})
+		return
+	}
+
+	// Validate scope
+	var scopes string
+	switch c.PostForm("scope") {
+	case model.API_TOKEN_SCOPE_READ:
+		scopes = model.API_TOKEN_SCOPE_READ
+	case model.API_TOKEN_SCOPE_TRADE:
+		scopes = strings.Join([]string{model.API_TOKEN_SCOPE_READ, model.API_TOKEN_SCOPE_TRADE}, ",")
+	case model.API_TOKEN_SCOPE_ADMIN:
+		user, err := ctl.db.GetUserByUuid(userData.Uuid)
+		if err != nil {
+			ctl.failJSONWithVagueError(c, "CreateApiToken", err)
+			return
+		}
+		if !HasPermission(user.Role, PERM_USER_MANAGE) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			return
+		}
+		scopes = strings.Join([]string{model.API_TOKEN_SCOPE_READ, model.API_TOKEN_SCOPE_TRADE, model.API_TOKEN_SCOPE_ADMIN}, ",")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope is invalid"})
+		return
+	}
+
+	// Validate expiry, 0 means never expires
+	expiryDay, err := strconv.ParseInt(c.PostForm("expiry_day"), 10, 64)
+	if err != nil || expiryDay < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiry_day is invalid"})
+		return
+	}
+	var expiresAt *time.Time
+	if expiryDay > 0 {
+		t := time.Now().Add(time.Second * 86400 * time.Duration(expiryDay))
+		expiresAt = &t
+	}
+
+	// Generate token, only the hash is stored
+	token, err := generateApiToken()
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "CreateApiToken", err)
+		return
+	}
+	apiToken := model.ApiToken{
+		Uuid:      uuid.New().String(),
+		UserUuid:  userData.Uuid,
+		Name:      name,
+		TokenHash: hashApiToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if _, _, err = ctl.model.CreateApiToken(apiToken); err != nil {
+		ctl.failJSONWithVagueError(c, "CreateApiToken", err)
+		return
+	}
+
+	// NOTE the token can't be retrieved again
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+func (ctl *Controller) RevokeApiToken(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+
+	// API tokens can't be managed by API token
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// Check permission
+	apiToken, err := ctl.model.GetApiTokenByUuidByUser(c.Param("uuid"), userData.Uuid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	data := map[string]interface{}{
+		"revoked_at": time.Now(),
+	}
+	if _, err := ctl.model.UpdateApiToken(apiToken.Uuid, data); err != nil {
+		ctl.failJSONWithVagueError(c, "RevokeApiToken", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// checkApiToken returns the error type if the bearer token is invalid, otherwise empty string
+func (ctl *Controller) checkApiToken(c *gin.Context) string {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	apiToken, err := ctl.model.GetApiTokenByHash(hashApiToken(token))
+	if err != nil {
+		return "invalid_token"
+	}
+	if !apiToken.IsActive() {
+		return "token_expired"
+	}
+	if !apiToken.HasScope(requiredScope(c)) {
+		return "insufficient_scope"
+	}
+
+	user, err := ctl.db.GetUserByUuid(apiToken.UserUuid)
+	if err != nil {
+		ctl.log.Printf("[ERROR] failed to get user by '%s', err: %v", apiToken.UserUuid, err)
+		return "invalid_token"
+	}
+	setting, err := ctl.getUserAuthSetting(user.Uuid)
+	if err != nil {
+		ctl.log.Println("checkApiToken err:", err)
+		return "invalid_token"
+	}
+	if setting.IsDisabled() {
+		return "account_disabled"
+	}
+
+	// Record last-used timestamp, it's fine to carry on if it fails
+	data := map[string]interface{}{
+		"last_used_at": time.Now(),
+	}
+	if _, err := ctl.model.UpdateApiToken(apiToken.Uuid, data); err != nil {
+		ctl.log.Println("checkApiToken err:", err)
+	}
+
+	c.Set(USER_DATA_CONTEXT_KEY, &UserData{
+		Uuid:     user.Uuid,
+		Role:     user.Role,
+		ApiToken: apiToken,
+	})
+	return ""
+}
+
+// requiredScope returns the scope required by the request, only GET requests are read-only. The admin routes require
+// the admin scope even for GET requests, e.g. the user list
+func requiredScope(c *gin.Context) string {
+	path := c.FullPath()
+	if strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/api/v1/admin/") {
+		return model.API_TOKEN_SCOPE_ADMIN
+	}
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return model.API_TOKEN_SCOPE_READ
+	}
+	return model.API_TOKEN_SCOPE_TRADE
+}
+
+func hasBearerToken(c *gin.Context) bool {
+	return strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+func generateApiToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return API_TOKEN_PREFIX + hex.EncodeToString(b), nil
+}
+
+// NOTE tokens are random enough, so plain sha256 is sufficient and allows looking up by hash
+func hashApiToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,346 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	"crypto-trading-bot-api/util/backtest"
+	"crypto-trading-bot-api/util/exchangeinfo"
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	BACKTEST_TIME_LAYOUT        = "2006-01-02 15:04"
+	BACKTEST_DEFAULT_RESOLUTION = 3600
+)
+
+// Returned by runBacktest when the error has been logged and shouldn't be shown to users
+var errBacktestInternal = errors.New("Internal error")
+
+// for API response, prices are strings as APIStrategy
+type APIBacktestResult struct {
+	Exchange           string             `json:"exchange"`
+	Symbol             string             `json:"symbol"`
+	Side               int64              `json:"side"`
+	Margin             string             `json:"margin"`
+	Candles            int                `json:"candles"`
+	Fills              []APIBacktestFill  `json:"fills"`
+	Pnl                string             `json:"pnl"`
+	PnlPercent         string             `json:"pnl_percent"`
+	Fees               string             `json:"fees"`
+	MaxDrawdown        string             `json:"max_drawdown"`
+	MaxDrawdownPercent string             `json:"max_drawdown_percent"`
+	Points             []APIBacktestPoint `json:"points"`
+}
+
+type APIBacktestFill struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Price  string    `json:"price"`
+	Size   string    `json:"size"`
+	Fee    string    `json:"fee"`
+}
+
+// Prices are empty if they're not set at the time, or the candle is missing for the trendline preview
+type APIBacktestPoint struct {
+	Time       time.Time `json:"time"`
+	Open       string    `json:"open,omitempty"`
+	High       string    `json:"high,omitempty"`
+	Low        string    `json:"low,omitempty"`
+	Close      string    `json:"close,omitempty"`
+	Trendline  string    `json:"trendline,omitempty"`
+	Entry      string    `json:"entry,omitempty"`
+	StopLoss   string    `json:"stop_loss,omitempty"`
+	TakeProfit string    `json:"take_profit,omitempty"`
+	Equity     string    `json:"equity"`
+}
+
+// Post params
+// Either strategy_uuid of a saved strategy or the same params as creating a strategy, candles are read from
+// candles_csv if it's given, otherwise from the stored candles between from and to
+type APIBacktestRequest struct {
+	APIStrategyRequest
+	StrategyUuid string `json:"strategy_uuid"`
+	Resolution   int64  `json:"resolution"` // seconds
+	From         string `json:"from"`       // e.g. "2021-10-01 00:00"
+	To           string `json:"to"`
+	CandlesCsv   string `json:"candles_csv"`
+	SaveCandles  bool   `json:"save_candles"`
+}
+
+func (req *APIBacktestRequest) toForm() formGetter {
+	values := req.APIStrategyRequest.toForm()
+	values.Set("credential_uuid", req.CredentialUuid)
+	values.Set("strategy_uuid", req.StrategyUuid)
+	if req.Resolution != 0 {
+		values.Set("resolution", strconv.FormatInt(req.Resolution, 10))
+	}
+	values.Set("from", req.From)
+	values.Set("to", req.To)
+	if req.SaveCandles {
+		values.Set("save_candles", "1")
+	}
+	return values.Get
+}
+
+func (ctl *Controller) BacktestPage(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+
+	userCookie := ctl.getUserData(c)
+	uuid := c.Param("uuid")
+
+	// Check permission
+	strategy, err := ctl.db.GetContractStrategyByUuidByUser(uuid, userCookie.Uuid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	now := time.Now().UTC()
+	c.HTML(http.StatusOK, "backtest.html", gin.H{
+		"loggedIn":     true,
+		"role":         userCookie.Role,
+		"strategy":     strategy,
+		"backtestFrom": now.AddDate(0, -1, 0).Format(BACKTEST_TIME_LAYOUT),
+		"backtestTo":   now.Format(BACKTEST_TIME_LAYOUT),
+	})
+}
+
+// RunBacktest is posted by the backtest panel as multipart form, with the candles csv file optionally
+func (ctl *Controller) RunBacktest(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+
+	var csv io.Reader
+	if file, err := c.FormFile("candles_csv"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			ctl.failJSONWithVagueError(c, "RunBacktest", err)
+			return
+		}
+		defer f.Close()
+		csv = f
+	}
+
+	result, err := ctl.runBacktest(ctl.getUserData(c).Uuid, c.PostForm, csv)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+func (ctl *Controller) APIRunBacktest(c *gin.Context) {
+	if !ctl.apiAuthCheck(c) {
+		return
+	}
+
+	var req APIBacktestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, "request body is invalid")
+		return
+	}
+	var csv io.Reader
+	if req.CandlesCsv != "" {
+		csv = strings.NewReader(req.CandlesCsv)
+	}
+
+	result, err := ctl.runBacktest(ctl.getUserData(c).Uuid, req.toForm(), csv)
+	if err == errBacktestInternal {
+		ctl.failAPI(c, http.StatusInternalServerError, API_ERR_INTERNAL, err.Error())
+		return
+	}
+	if err != nil {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// runBacktest replays a saved strategy (strategy_uuid) or an unsaved form against the candles, nothing of the
+// strategy is changed
+func (ctl *Controller) runBacktest(userUuid string, form formGetter, csv io.Reader) (*APIBacktestResult, error) {
+	var strategy *db.ContractStrategy
+	var err error
+	if uuid := form("strategy_uuid"); uuid != "" {
+		strategy, err = ctl.db.GetContractStrategyByUuidByUser(uuid, userUuid)
+		if err != nil {
+			return nil, errors.New("Permission denied")
+		}
+	} else {
+		credential, err := ctl.validateStrategyCredential(userUuid, form("credential_uuid"))
+		if err != nil {
+			return nil, err
+		}
+		cs, err := ctl.newContractStrategy(userUuid, strategyExchange(credential), form)
+		if err != nil {
+			return nil, err
+		}
+		strategy = &cs
+	}
+
+	c, err := contract.NewContract(order.Side(strategy.Side), strategy.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Paper accounts share the candles of the underlying exchange
+	exchangeName := exchangeinfo.Underlying(strategy.Exchange)
+	candles, err := ctl.loadBacktestCandles(exchangeName, strategy.Symbol, form, csv)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := backtest.Run(backtest.Params{
+		Side:     order.Side(strategy.Side),
+		Contract: c,
+		Margin:   strategy.Margin,
+		FeeRate:  ctl.paperConfig.FeeRate,
+	}, candles)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAPIBacktestResult(strategy, len(candles), result), nil
+}
+
+// loadBacktestCandles parses the csv and saves it if save_candles is set, or reads the stored candles
+func (ctl *Controller) loadBacktestCandles(exchangeName string, symbol string, form formGetter, csv io.Reader) ([]backtest.Candle, error) {
+	resolution := int64(BACKTEST_DEFAULT_RESOLUTION)
+	if form("resolution") != "" {
+		var err error
+		resolution, err = strconv.ParseInt(form("resolution"), 10, 64)
+		if err != nil || resolution <= 0 {
+			return nil, errors.New("resolution is invalid")
+		}
+	}
+
+	if csv != nil {
+		candles, err := backtest.ParseCSV(csv)
+		if err != nil {
+			return nil, fmt.Errorf("K 線檔案格式錯誤: %s", err.Error())
+		}
+		if len(candles) == 0 {
+			return nil, errors.New("K 線檔案沒有資料")
+		}
+		if form("save_candles") == "1" {
+			rows := make([]model.Candle, len(candles))
+			for i, candle := range candles {
+				rows[i] = model.Candle{
+					Exchange:   exchangeName,
+					Symbol:     symbol,
+					Resolution: resolution,
+					OpenTime:   candle.Time,
+					Open:       candle.Open,
+					High:       candle.High,
+					Low:        candle.Low,
+					Close:      candle.Close,
+					Volume:     candle.Volume,
+				}
+			}
+			if _, err = ctl.model.SaveCandles(rows); err != nil {
+				ctl.log.Println("[ERROR] SaveCandles err:", err)
+				return nil, errBacktestInternal
+			}
+		}
+		return candles, nil
+	}
+
+	from, err := time.Parse(BACKTEST_TIME_LAYOUT, form("from"))
+	if err != nil {
+		return nil, errors.New("from is invalid")
+	}
+	to, err := time.Parse(BACKTEST_TIME_LAYOUT, form("to"))
+	if err != nil || !to.After(from) {
+		return nil, errors.New("to is invalid")
+	}
+	rows, _, err := ctl.model.GetCandles(exchangeName, symbol, resolution, from, to, backtest.MAX_CANDLES+1)
+	if err != nil {
+		ctl.log.Println("[ERROR] GetCandles err:", err)
+		return nil, errBacktestInternal
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("此區間沒有 K 線資料, 請先匯入")
+	}
+	if len(rows) > backtest.MAX_CANDLES {
+		return nil, fmt.Errorf("K 線數量超過 %d, 請縮短區間", backtest.MAX_CANDLES)
+	}
+	candles := make([]backtest.Candle, len(rows))
+	for i, row := range rows {
+		candles[i] = backtest.Candle{
+			Time:   row.OpenTime,
+			Open:   row.Open,
+			High:   row.High,
+			Low:    row.Low,
+			Close:  row.Close,
+			Volume: row.Volume,
+		}
+	}
+	return candles, nil
+}
+
+func newAPIBacktestResult(strategy *db.ContractStrategy, count int, r *backtest.Result) *APIBacktestResult {
+	result := &APIBacktestResult{
+		Exchange:           strategy.Exchange,
+		Symbol:             strategy.Symbol,
+		Side:               strategy.Side,
+		Margin:             strategy.Margin.String(),
+		Candles:            count,
+		Fills:              []APIBacktestFill{},
+		Pnl:                r.Pnl.StringFixed(2),
+		PnlPercent:         r.PnlPercent.StringFixed(2),
+		Fees:               r.Fees.StringFixed(2),
+		MaxDrawdown:        r.MaxDrawdown.StringFixed(2),
+		MaxDrawdownPercent: r.MaxDrawdownPercent.StringFixed(2),
+	}
+	for _, f := range r.Fills {
+		result.Fills = append(result.Fills, APIBacktestFill{
+			Time:   f.Time,
+			Reason: f.Reason,
+			Price:  f.Price.String(),
+			Size:   f.Size.StringFixed(4),
+			Fee:    f.Fee.StringFixed(4),
+		})
+	}
+	for _, p := range r.Points {
+		result.Points = append(result.Points, newAPIBacktestPoint(p))
+	}
+	return result
+}
+
+func newAPIBacktestPoint(p backtest.Point) APIBacktestPoint {
+	return APIBacktestPoint{
+		Time:       p.Time,
+		Open:       priceOrEmpty(p.Open),
+		High:       priceOrEmpty(p.High),
+		Low:        priceOrEmpty(p.Low),
+		Close:      priceOrEmpty(p.Close),
+		Trendline:  priceOrEmpty(p.Trendline),
+		Entry:      priceOrEmpty(p.Entry),
+		StopLoss:   priceOrEmpty(p.StopLoss),
+		TakeProfit: priceOrEmpty(p.TakeProfit),
+		Equity:     p.Equity.StringFixed(2),
+	}
+}
+
+func priceOrEmpty(d decimal.Decimal) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Round(8).String()
+}
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/order"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// When the stop-loss is moved to the entry price
+	BREAK_EVEN_PERCENT           = "percent"           // after the price moves the percent in favour
+	BREAK_EVEN_TAKE_PROFIT_LEVEL = "take_profit_level" // after the first take-profit level is closed
+)
+
+// `break_even` of stop_loss_order, percent and offset are fractions of the entry price, e.g. 0.01 means 1%
+type breakEven struct {
+	Mode          string
+	Percent       decimal.Decimal // zero for BREAK_EVEN_TAKE_PROFIT_LEVEL
+	OffsetPercent decimal.Decimal // the stop is moved beyond the entry price by it to cover the fees
+}
+
+// processBreakEvenParams returns `break_even` of stop_loss_order, nil if it's not enabled
+func processBreakEvenParams(form formGetter) (map[string]interface{}, error) {
+	if form("stop_loss[break_even_enabled]") != "1" {
+		return nil, nil
+	}
+
+	breakEven := map[string]interface{}{}
+	switch mode := form("stop_loss[break_even_mode]"); mode {
+	case BREAK_EVEN_PERCENT:
+		percent, err := decimal.NewFromString(form("stop_loss[break_even_percent]"))
+		if err != nil || !percent.IsPositive() {
+			return nil, errors.New("break_even_percent is invalid")
+		}
+		breakEven["mode"] = mode
+		breakEven["percent"], _ = percent.Div(decimal.NewFromInt(100)).Float64()
+	case BREAK_EVEN_TAKE_PROFIT_LEVEL:
+		hasLevel := false
+		for i := 1; i <= TAKE_PROFIT_MAX_LEVELS; i++ {
+			if form(fmt.Sprintf("take_profit[level_price_%d]", i)) != "" {
+				hasLevel = true
+			}
+		}
+		if form("take_profit[enabled]") != "1" || !hasLevel {
+			return nil, errors.New("請先設定分批停利")
+		}
+		breakEven["mode"] = mode
+	default:
+		return nil, errors.New("break_even_mode is invalid")
+	}
+
+	if offsetPercent := form("stop_loss[break_even_offset_percent]"); offsetPercent != "" {
+		offset, err := decimal.NewFromString(offsetPercent)
+		if err != nil || offset.IsNegative() {
+			return nil, errors.New("break_even_offset_percent is invalid")
+		}
+		breakEven["offset_percent"], _ = offset.Div(decimal.NewFromInt(100)).Float64()
+	}
+	return breakEven, nil
+}
+
+// getBreakEven returns nil if the break-even rule isn't set
+func getBreakEven(params map[string]interface{}) *breakEven {
+	stopLoss, ok := params["stop_loss_order"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	m, ok := stopLoss["break_even"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	b := &breakEven{}
+	b.Mode, _ = m["mode"].(string)
+	percent, _ := m["percent"].(float64)
+	b.Percent = decimal.NewFromFloat(percent)
+	offsetPercent, _ := m["offset_percent"].(float64)
+	b.OffsetPercent = decimal.NewFromFloat(offsetPercent)
+	if b.Mode != BREAK_EVEN_PERCENT && b.Mode != BREAK_EVEN_TAKE_PROFIT_LEVEL {
+		return nil
+	}
+	return b
+}
+
+// stopPrice is above the entry price of long, or below the entry price of short by the offset
+func (b *breakEven) stopPrice(side order.Side, entryPrice decimal.Decimal) decimal.Decimal {
+	offset := entryPrice.Mul(b.OffsetPercent)
+	if side == order.SHORT {
+		return entryPrice.Sub(offset)
+	}
+	return entryPrice.Add(offset)
+}
+
+// setBreakEvenTmplData sets the break-even rule of the params for the templates
+func setBreakEvenTmplData(data gin.H, params map[string]interface{}) {
+	b := getBreakEven(params)
+	data["slBreakEvenEnabled"] = b != nil
+	if b == nil {
+		return
+	}
+	data["slBreakEvenAfterLevel"] = b.Mode == BREAK_EVEN_TAKE_PROFIT_LEVEL
+	if b.Mode == BREAK_EVEN_PERCENT {
+		data["slBreakEvenPercent"] = b.Percent.Mul(decimal.NewFromInt(100)).String()
+	}
+	if b.OffsetPercent.IsPositive() {
+		data["slBreakEvenOffsetPercent"] = b.OffsetPercent.Mul(decimal.NewFromInt(100)).String()
+	}
+}
+
+// moveStopLossToBreakEven moves the stop-loss once per position, and records it as `break_even` of
+// exchange_orders_details. It's run by runPositionWorker
+func (ctl *Controller) moveStopLossToBreakEven(uuid string) error {
+	cs, err := ctl.db.GetContractStrategyByUuid(uuid)
+	if err != nil {
+		return err
+	}
+	b := getBreakEven(cs.Params)
+	if b == nil || !isTrackedPosition(cs) {
+		return nil
+	}
+	// The stop-loss order is placed by the engine after entry
+	if _, ok := cs.ExchangeOrdersDetails["stop_loss_order"].(map[string]interface{}); !ok {
+		return nil
+	}
+	positionAt := cs.LastPositionAt.Format(time.RFC3339)
+	if record, ok := cs.ExchangeOrdersDetails["break_even"].(map[string]interface{}); ok && record["position_at"] == positionAt {
+		return nil
+	}
+	entryDetails, ok := cs.ExchangeOrdersDetails["entry_order"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	entryPrice, err := decimal.NewFromString(fmt.Sprint(entryDetails["price"]))
+	if err != nil || !entryPrice.IsPositive() {
+		return fmt.Errorf("entry price '%v' is invalid", entryDetails["price"])
+	}
+
+	// The first take-profit level of the position is closed, see takeProfitLadder
+	side := order.Side(cs.Side)
+	if b.Mode == BREAK_EVEN_TAKE_PROFIT_LEVEL {
+		ladder, ok := cs.ExchangeOrdersDetails["take_profit_levels"].(map[string]interface{})
+		if !ok || ladder["position_at"] != positionAt {
+			return nil
+		}
+		if closed, _ := ladder["closed"].([]interface{}); len(closed) == 0 {
+			return nil
+		}
+	}
+
+	ex, err := ctl.newExchangeByUser(cs.UserUuid, cs.Uuid)
+	if err != nil {
+		return err
+	}
+	price, err := ex.GetMarketPrice(cs.Symbol)
+	if err != nil {
+		return err
+	}
+	if b.Mode == BREAK_EVEN_PERCENT {
+		target := entryPrice.Mul(decimal.NewFromInt(1).Add(b.Percent))
+		if side == order.SHORT {
+			target = entryPrice.Mul(decimal.NewFromInt(1).Sub(b.Percent))
+		}
+		if !isBetterPrice(side, price, target) && !price.Equal(target) {
+			return nil
+		}
+	}
+
+	// Round to the decimal places of the entry price
+	stop := b.stopPrice(side, entryPrice)
+	if entryPrice.Exponent() < 0 {
+		stop = stop.Round(-entryPrice.Exponent())
+	}
+
+	// Nothing to move if the stop-loss is better already, e.g. moved by the trailing stop-loss, or the price is over the
+	// stop
+	if !isStopLossMovable(cs, stop) || !isBetterPrice(side, price, stop) {
+		return nil
+	}
+
+	return ctl.withStrategyDisabled(uuid, func(cs *db.ContractStrategy) error {
+		// Checked again with the strategy read after it's disabled
+		if cs.LastPositionAt.Format(time.RFC3339) != positionAt {
+			return nil
+		}
+		if record, ok := cs.ExchangeOrdersDetails["break_even"].(map[string]interface{}); ok && record["position_at"] == positionAt {
+			return nil
+		}
+		if !isStopLossMovable(cs, stop) {
+			return nil
+		}
+		if err := ctl.moveStopLossOrder(ex, cs, stop); err != nil {
+			return err
+		}
+		cs.ExchangeOrdersDetails["break_even"] = map[string]interface{}{
+			"position_at": positionAt,
+			"price":       stop.String(),
+			"moved_at":    time.Now().UTC().Format(time.RFC3339),
+		}
+		_, err := ctl.db.UpdateContractStrategy(cs.Uuid, map[string]interface{}{"exchange_orders_details": cs.ExchangeOrdersDetails})
+		return err
+	})
+}
@@ -1,6 +1,11 @@
 package controller
 
 import (
+	"crypto-trading-bot-api/model"
+	"crypto-trading-bot-api/util/captcha"
+	"crypto-trading-bot-api/util/keyring"
+	"crypto-trading-bot-api/util/paper"
+	"crypto-trading-bot-api/util/ratelimit"
 	"crypto-trading-bot-engine/db"
 	"crypto-trading-bot-engine/message"
 	"encoding/hex"
@@ -12,16 +17,39 @@ import (
 	"github.com/spf13/viper"
 )
 
+const (
+	// Set by tokenAuthCheck
+	USER_DATA_CONTEXT_KEY = "userData"
+)
+
 type Controller struct {
 	db     *db.DB
+	model  *model.DB
 	sender message.Messenger
 	store  *sessions.CookieStore
 	log    *log.Logger
+
+	captcha captcha.Verifier
+	keyring *keyring.Keyring
+
+	// nil if paper trading is disabled
+	paperPrices paper.PriceSource
+	paperConfig paper.Config
+
+	loginRateLimit *rateLimit
+	otpRateLimit   *rateLimit
+	loginLockout   ratelimit.Lockout
 }
 
 type UserData struct {
 	Uuid string
 	Role int64
+
+	// Only set if the request is authenticated by login session
+	SessionId string
+
+	// Only set if the request is authenticated by API token
+	ApiToken *model.ApiToken
 }
 
 func InitController(l *log.Logger) *Controller {
@@ -52,12 +80,77 @@ func InitController(l *log.Logger) *Controller {
 	// Session store
 	store := sessions.NewCookieStore(authKey, encryptKey)
 
-	return &Controller{
+	// Signature of the session cookies, see getSessionSignature, otherwise every login fails
+	if signatureKey, err := hex.DecodeString(viper.GetString("SESSION_SIGNATURE_KEY")); err != nil || len(signatureKey) == 0 {
+		l.Fatal("SESSION_SIGNATURE_KEY must be set (hex)")
+	}
+
+	// Human verification of login page
+	minScore := captcha.DEFAULT_MIN_SCORE
+	if viper.IsSet("RECAPTCHA_MIN_SCORE") {
+		minScore = viper.GetFloat64("RECAPTCHA_MIN_SCORE")
+	}
+	captchaVerifier, err := captcha.NewVerifier(captcha.Config{
+		Provider: getCaptchaProvider(),
+		Secret:   getCaptchaConfig("SECRET"),
+		MinScore: minScore,
+	})
+	if err != nil {
+		l.Fatal(err)
+	}
+	if getCaptchaProvider() == captcha.PROVIDER_DISABLED {
+		if viper.GetString("ENV") == "prod" {
+			l.Fatal("captcha can't be disabled in prod")
+		}
+		l.Println("[WARN] captcha is disabled")
+	}
+
+	// Keys of the encrypted data, e.g. exchange API keys
+	keyring, err := newKeyring()
+	if err != nil {
+		l.Fatal(err)
+	}
+
+	// Simulated exchange of paper accounts
+	paperPrices, paperConfig, err := newPaperTrading()
+	if err != nil {
+		l.Fatal(err)
+	}
+
+	ctl := &Controller{
 		db:     db,
+		model:  model.NewDB(db.GormDB),
 		sender: sender,
 		store:  store,
 		log:    l,
+
+		captcha: captchaVerifier,
+		keyring: keyring,
+
+		paperPrices: paperPrices,
+		paperConfig: paperConfig,
+
+		loginRateLimit: newRateLimit(LOGIN_RATE_PER_IP, LOGIN_RATE_PER_USERNAME, LOGIN_RATE_PER_USERNAME),
+		otpRateLimit:   newRateLimit(OTP_RATE_PER_IP, OTP_RATE_PER_USERNAME, OTP_BURST_PER_USERNAME),
+		loginLockout:   newLoginLockout(),
+	}
+	if paperPrices == nil && viper.GetString("PAPER_PRICE_SOURCE") != "" {
+		l.Println("[WARN] paper trading is disabled, PAPER_ENGINE_ENABLED isn't set")
+	}
+	if paperPrices != nil {
+		l.Printf("[INFO] paper trading is enabled, price source: %s", viper.GetString("PAPER_PRICE_SOURCE"))
+	}
+	ctl.warnMissingSymbolRules()
+	return ctl
+}
+
+// RunWorkers starts the background loops which trade, e.g. the trailing stop-loss. It's only called by the server, not
+// by the one-off commands, so that they never run along with the loops of the server
+func (ctl *Controller) RunWorkers() {
+	if ctl.paperPrices != nil {
+		go ctl.runPaperTriggers()
 	}
+	go ctl.runPositionWorker()
 }
 
 // NOTE intentionally provide vague for security purpose
@@ -70,6 +163,10 @@ func (ctl *Controller) failJSONWithVagueError(c *gin.Context, caller string, err
 
 // must be called after 'tokenAuthCheck'
 func (ctl *Controller) getUserData(c *gin.Context) *UserData {
+	if userData, ok := c.Get(USER_DATA_CONTEXT_KEY); ok {
+		return userData.(*UserData)
+	}
+
 	session, err := ctl.store.Get(c.Request, "user-session")
 	if err != nil {
 		ctl.failJSONWithVagueError(c, "getUserData", err)
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/util/keyring"
+	"crypto-trading-bot-engine/util/aes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Key id of AES_PRIVATE_KEY when AES_KEYRING isn't set
+const DEFAULT_AES_KEY_ID = "0"
+
+// newKeyring reads AES_KEYRING (key id -> hex key) and AES_CURRENT_KEY_ID, falls back to AES_PRIVATE_KEY
+func newKeyring() (*keyring.Keyring, error) {
+	keys := viper.GetStringMapString("AES_KEYRING")
+	currentId := viper.GetString("AES_CURRENT_KEY_ID")
+	if len(keys) == 0 {
+		keys = map[string]string{DEFAULT_AES_KEY_ID: viper.GetString("AES_PRIVATE_KEY")}
+		currentId = DEFAULT_AES_KEY_ID
+	}
+	return keyring.New(currentId, keys)
+}
+
+// encryptWithAES returns AES-GCM ciphertext of the current key, see util/keyring for the format
+func (ctl *Controller) encryptWithAES(data []byte) (string, error) {
+	return ctl.keyring.Encrypt(data)
+}
+
+// decryptWithAES accepts both the versioned format and the legacy `iv;data` encrypted by AES_PRIVATE_KEY
+func (ctl *Controller) decryptWithAES(encryptedData string) ([]byte, error) {
+	if keyring.IsVersioned(encryptedData) {
+		return ctl.keyring.Decrypt(encryptedData)
+	}
+	return decryptLegacyAES(encryptedData)
+}
+
+// rewrapWithAES re-encrypts the data by the current key, returns false if it's already encrypted by the current key
+func (ctl *Controller) rewrapWithAES(encryptedData string) (string, bool, error) {
+	if !ctl.keyring.NeedsRewrap(encryptedData) {
+		return encryptedData, false, nil
+	}
+	data, err := ctl.decryptWithAES(encryptedData)
+	if err != nil {
+		return "", false, err
+	}
+	rewrapped, err := ctl.encryptWithAES(data)
+	return rewrapped, err == nil, err
+}
+
+// toEngineCiphertext converts the data for crypto-trading-bot-engine, e.g. exchange.NewExchange and users.exchange_api_key,
+// which only decrypts the legacy format by AES_PRIVATE_KEY unless AES_ENGINE_VERSIONED_FORMAT is enabled
+func (ctl *Controller) toEngineCiphertext(encryptedData string) (string, error) {
+	if viper.GetBool("AES_ENGINE_VERSIONED_FORMAT") || !keyring.IsVersioned(encryptedData) {
+		return encryptedData, nil
+	}
+	data, err := ctl.keyring.Decrypt(encryptedData)
+	if err != nil {
+		return "", err
+	}
+	return encryptLegacyAES(data)
+}
+
+// encryptLegacyAES returns `iv;data` encoded by base64, which is the format of `users.exchange_api_key` read by engine
+func encryptLegacyAES(data []byte) (string, error) {
+	key, err := hex.DecodeString(viper.GetString("AES_PRIVATE_KEY"))
+	if err != nil {
+		return "", err
+	}
+	iv64, data64, err := aes.Encrypt([]byte(key), data)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s;%s", iv64, data64), nil
+}
+
+func decryptLegacyAES(encryptedData string) ([]byte, error) {
+	parts := strings.Split(encryptedData, ";")
+	if len(parts) != 2 {
+		return []byte{}, errors.New("invalid encrypted data")
+	}
+	key, err := hex.DecodeString(viper.GetString("AES_PRIVATE_KEY"))
+	if err != nil {
+		return []byte{}, err
+	}
+	return aes.Decrypt([]byte(key), parts[0], parts[1])
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+const (
+	// Readable by javascript, see assets/js/csrf.js
+	CSRF_COOKIE_NAME = "csrf_token"
+	CSRF_HEADER_NAME = "X-CSRF-Token"
+	CSRF_FORM_KEY    = "csrf_token"
+
+	CSRF_COOKIE_MAX_AGE_SECOND = 86400 * LOGIN_EXPIRY_LENGTH_DAY
+)
+
+// CSRF is a signed double-submit cookie middleware, state-changing requests must send the cookie value back
+// in either `X-CSRF-Token` header or `csrf_token` form field
+func (ctl *Controller) CSRF(c *gin.Context) {
+	token, err := c.Cookie(CSRF_COOKIE_NAME)
+	if err != nil || !ctl.validCSRFToken(token) {
+		token, err = ctl.generateCSRFToken()
+		if err != nil {
+			ctl.log.Println("CSRF err:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			return
+		}
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(CSRF_COOKIE_NAME, token, CSRF_COOKIE_MAX_AGE_SECOND, "/", "", viper.GetString("ENV") == "prod", false)
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+
+	// Browsers don't attach API tokens automatically, so they aren't affected by CSRF
+	if hasBearerToken(c) {
+		c.Next()
+		return
+	}
+
+	submitted := c.GetHeader(CSRF_HEADER_NAME)
+	if submitted == "" {
+		submitted = c.PostForm(CSRF_FORM_KEY)
+	}
+	if !hmac.Equal([]byte(submitted), []byte(token)) {
+		ctl.log.Printf("[WARN] CSRF token mismatch, %s %s", c.Request.Method, c.Request.URL.Path)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "CSRF token 無效, 請重新整理頁面後再試"})
+		return
+	}
+	c.Next()
+}
+
+// MovedToPost responds to the former GET action paths during the transition period
+func (ctl *Controller) MovedToPost(c *gin.Context) {
+	c.Header("Allow", http.MethodPost)
+	c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "This action only accepts POST requests now, please reload the page or update your client"})
+}
+
+// generateCSRFToken returns `<random>.<signature>`, so that the cookie can't be forged by e.g. a sibling subdomain
+func (ctl *Controller) generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	random := base64.RawURLEncoding.EncodeToString(b)
+	signature, err := ctl.getSessionSignature([]byte("csrf-" + random))
+	if err != nil {
+		return "", err
+	}
+	return random + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (ctl *Controller) validCSRFToken(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	expected, err := ctl.getSessionSignature([]byte("csrf-" + parts[0]))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(signature, expected)
+}
@@ -11,11 +11,8 @@ func (ctl *Controller) Engine(c *gin.Context) {
 		return
 	}
 
+	// NOTE permission is checked by the middleware in router
 	userCookie := ctl.getUserData(c)
-	if userCookie.Role != 99 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
-		return
-	}
 
 	// ping
 	var ping, status, list string
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	engineDb "crypto-trading-bot-engine/db"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Post params
+type UserInviteAccept struct {
+	Username       string `form:"username"`
+	TelegramChatId string `form:"telegram_chat_id"`
+}
+
+// InvitePage shows the form of the one-time invite link, the invitee doesn't need to log in
+func (ctl *Controller) InvitePage(c *gin.Context) {
+	invite, err := ctl.getAvailableInvite(c.Param("token"))
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "invite.html", gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.HTML(http.StatusOK, "invite.html", gin.H{
+		"error":              "",
+		"token":              c.Param("token"),
+		"needTelegramChatId": invite.TelegramChatId == 0,
+	})
+}
+
+func (ctl *Controller) AcceptInvite(c *gin.Context) {
+	token := c.Param("token")
+	invite, err := ctl.getAvailableInvite(token)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "invite.html", gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	renderError := func(msg string) {
+		c.HTML(http.StatusBadRequest, "invite.html", gin.H{
+			"error":              msg,
+			"token":              token,
+			"needTelegramChatId": invite.TelegramChatId == 0,
+		})
+	}
+
+	var form UserInviteAccept
+	if err := c.ShouldBind(&form); err != nil {
+		renderError("參數錯誤")
+		return
+	}
+	username := strings.TrimSpace(form.Username)
+	if err := ctl.validateNewUsername(username); err != nil {
+		renderError(err.Error())
+		return
+	}
+	telegramChatId := invite.TelegramChatId
+	if telegramChatId == 0 {
+		telegramChatId, err = strconv.ParseInt(form.TelegramChatId, 10, 64)
+		if err != nil || telegramChatId == 0 {
+			renderError("telegram_chat_id is invalid")
+			return
+		}
+	}
+
+	// The user logs in with the one-time password sent to telegram
+	user := engineDb.User{
+		Uuid:              uuid.New().String(),
+		Username:          username,
+		Role:              invite.Role,
+		TelegramChatId:    telegramChatId,
+		PasswordExpiredAt: time.Now(),
+	}
+	if err := ctl.model.CreateUserByInvite(invite.Uuid, user); err != nil {
+		if errors.Is(err, model.ErrUserInviteUnavailable) {
+			renderError("邀請連結已失效")
+			return
+		}
+		ctl.log.Println("AcceptInvite err:", err)
+		renderError("Internal error")
+		return
+	}
+	ctl.log.Printf("[INFO] user '%s' is created by invite '%s'", user.Uuid, invite.Uuid)
+
+	c.Redirect(http.StatusFound, "/login?success=invite")
+}
+
+func (ctl *Controller) getAvailableInvite(token string) (*model.UserInvite, error) {
+	invite, err := ctl.model.GetUserInviteByHash(hashInviteToken(token))
+	if err != nil || !invite.IsAvailable() {
+		return nil, errors.New("邀請連結已失效")
+	}
+	return invite, nil
+}
@@ -1,15 +1,14 @@
 package controller
 
 import (
+	"crypto-trading-bot-api/util/captcha"
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"reflect"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,52 +18,52 @@ import (
 )
 
 const (
-	RECAPTCHA_VERIFY_URL = "https://www.google.com/recaptcha/api/siteverify"
-
-	// NOTE intentionally sleep a few seconds for security purpose
-	RESPONSE_DELAY_SECOND = 1
-
 	// The period for one-time password
 	OTP_EXPIRY_SECOND = 180
 
 	// The period for successfully login session
 	LOGIN_EXPIRY_LENGTH_DAY = 7
+
+	// Checked by reCAPTCHA v3, must be the same as login.html
+	CAPTCHA_ACTION_LOGIN = "login"
+	CAPTCHA_ACTION_OTP   = "get_otp"
 )
 
 // Post params
 type UserLogin struct {
-	Username          string `form:"username"`
-	Password          string `form:"password"`
-	RecaptchaResponse string `form:"g-recaptcha-response"`
+	Username        string `form:"username"`
+	Password        string `form:"password"`
+	TotpCode        string `form:"totp_code"`
+	CaptchaResponse string `form:"captcha_response"`
 }
 
 // Post params
 type UserOTP struct {
-	Username          string `form:"username"`
-	RecaptchaResponse string `form:"g-recaptcha-response"`
+	Username        string `form:"username"`
+	CaptchaResponse string `form:"captcha_response"`
 }
 
 func (ctl *Controller) LoginPage(c *gin.Context) {
 	errType := c.Query("err")
 	success := c.Query("success")
 	c.HTML(200, "login.html", gin.H{
-		"recaptchaSiteKey": viper.GetString("RECAPTCHA_SITE_KEY"),
-		"otpExpirySecond":  OTP_EXPIRY_SECOND,
-		"errType":          errType,
-		"success":          success,
+		"captchaProvider": getCaptchaProvider(),
+		"captchaSiteKey":  getCaptchaConfig("SITE_KEY"),
+		"otpExpirySecond": OTP_EXPIRY_SECOND,
+		"errType":         errType,
+		"success":         success,
 	})
 }
 
+// NOTE requests are rate limited by LoginRateLimit
 func (ctl *Controller) LoginAPI(c *gin.Context) {
-	time.Sleep(time.Second * time.Duration(RESPONSE_DELAY_SECOND))
-
 	var u UserLogin
 	if err := c.ShouldBind(&u); err != nil {
 		ctl.log.Println("LoginAPI err: ", err)
 		ctl.redirectToLoginPage(c, "/login?err=login_failed")
 		return
 	}
-	valid, err := ctl.checkRecaptcha(u.RecaptchaResponse)
+	valid, err := ctl.captcha.Verify(u.CaptchaResponse, c.ClientIP(), CAPTCHA_ACTION_LOGIN)
 	if err != nil {
 		ctl.log.Println("LoginAPI err: ", err)
 		ctl.redirectToLoginPage(c, "/login?err=login_failed")
@@ -76,26 +75,83 @@ func (ctl *Controller) LoginAPI(c *gin.Context) {
 		return
 	}
 
-	// Hash password with sha256
-	pwdHash, err := ctl.hashPassword(u.Password)
+	// Get user
+	user, err := ctl.db.GetUserByUsername(u.Username)
 	if err != nil {
 		ctl.log.Println("LoginAPI err: ", err)
 		ctl.redirectToLoginPage(c, "/login?err=login_failed")
 		return
 	}
-
-	// Get user by username namd password
-	user, err := ctl.db.GetUserByUsernameByPassword(u.Username, pwdHash)
+	if ctl.isLoginLocked(user.Username) {
+		ctl.log.Printf("LoginAPI err: user '%s' is locked", user.Username)
+		ctl.redirectToLoginPage(c, "/login?err=account_locked")
+		return
+	}
+	setting, err := ctl.getUserAuthSetting(user.Uuid)
 	if err != nil {
 		ctl.log.Println("LoginAPI err: ", err)
 		ctl.redirectToLoginPage(c, "/login?err=login_failed")
 		return
 	}
+	if setting.IsDisabled() {
+		ctl.log.Printf("LoginAPI err: user '%s' is disabled", user.Username)
+		ctl.redirectToLoginPage(c, "/login?err=account_disabled")
+		return
+	}
+
+	// One-time password sent via telegram
+	var rehash bool
+	if setting.RequiresTelegram() {
+		if time.Now().After(user.PasswordExpiredAt) {
+			ctl.log.Println("LoginAPI err: password expired")
+			ctl.recordLoginFailure(user.Username, user.TelegramChatId)
+			ctl.redirectToLoginPage(c, "/login?err=login_failed")
+			return
+		}
+
+		// Verify password against the stored hash
+		ok, needsRehash, err := ctl.verifyPassword(user.Password, u.Password)
+		if err != nil {
+			ctl.log.Println("LoginAPI err: ", err)
+			ctl.redirectToLoginPage(c, "/login?err=login_failed")
+			return
+		}
+		if !ok {
+			ctl.log.Println("LoginAPI err: invalid password")
+			ctl.recordLoginFailure(user.Username, user.TelegramChatId)
+			ctl.redirectToLoginPage(c, "/login?err=login_failed")
+			return
+		}
+		rehash = needsRehash
+	}
+
+	// Code from authenticator app or recovery code
+	if setting.RequiresTotp() {
+		if err = ctl.verifyTotp(setting, u.TotpCode); err != nil {
+			ctl.log.Println("LoginAPI err: ", err)
+			ctl.recordLoginFailure(user.Username, user.TelegramChatId)
+			ctl.redirectToLoginPage(c, "/login?err=login_failed")
+			return
+		}
+	}
+
+	ctl.resetLoginFailures(user.Username)
 
 	// Update user data
 	data := map[string]interface{}{
 		"last_login_at": time.Now(),
 	}
+
+	// Upgrade legacy sha256 hash transparently
+	if rehash {
+		pwdHash, err := ctl.hashPassword(u.Password)
+		if err != nil {
+			ctl.log.Println("LoginAPI err: ", err)
+			ctl.redirectToLoginPage(c, "/login?err=login_failed")
+			return
+		}
+		data["password"] = pwdHash
+	}
 	if _, err = ctl.db.UpdateUser(user.Uuid, data); err != nil {
 		ctl.log.Println("LoginAPI err: ", err)
 		ctl.redirectToLoginPage(c, "/login?err=login_failed")
@@ -111,10 +167,18 @@ func (ctl *Controller) LoginAPI(c *gin.Context) {
 		return
 	}
 
-	// Generate signature
+	// Server side session, so that it can be revoked
 	expiryTs := time.Now().Add(time.Second * 86400 * LOGIN_EXPIRY_LENGTH_DAY).Unix()
-	prefixKey := ctl.getSignaturePrefixKey(user.Uuid, user.Role, expiryTs)
-	hash, err := ctl.getSignatureHash(prefixKey)
+	sessionId, err := ctl.createUserSession(c, user.Uuid, time.Unix(expiryTs, 0))
+	if err != nil {
+		ctl.log.Println("LoginAPI err: ", err)
+		ctl.redirectToLoginPage(c, "/login?err=login_failed")
+		return
+	}
+
+	// Generate signature
+	prefixKey := ctl.getSignaturePrefixKey(sessionId, user.Uuid, user.Role, expiryTs, setting.SessionVersion)
+	hash, err := ctl.getSessionSignature(prefixKey)
 	if err != nil {
 		ctl.log.Println("LoginAPI err: ", err)
 		ctl.redirectToLoginPage(c, "/login?err=login_failed")
@@ -122,9 +186,11 @@ func (ctl *Controller) LoginAPI(c *gin.Context) {
 	}
 	signature := base64.StdEncoding.EncodeToString(hash)
 
+	session.Values["session_id"] = sessionId
 	session.Values["uuid"] = user.Uuid
 	session.Values["role"] = user.Role
 	session.Values["expiry_ts"] = expiryTs
+	session.Values["session_version"] = setting.SessionVersion
 	session.Values["signature"] = signature
 	session.Options = &sessions.Options{
 		MaxAge: 86400 * LOGIN_EXPIRY_LENGTH_DAY,
@@ -139,22 +205,20 @@ func (ctl *Controller) LoginAPI(c *gin.Context) {
 	ctl.redirectToLoginPage(c, "/?success=login")
 }
 
-// NOTE intentionally sleep 2 seconds for security purpose
+// NOTE requests are rate limited by OTPRateLimit
 func (ctl *Controller) OTP(c *gin.Context) {
-	time.Sleep(time.Second * time.Duration(RESPONSE_DELAY_SECOND))
-
 	var u UserOTP
 	if err := c.ShouldBind(&u); err != nil {
 		ctl.failJSONWithVagueError(c, "OTP", err)
 		return
 	}
-	valid, err := ctl.checkRecaptcha(u.RecaptchaResponse)
+	valid, err := ctl.captcha.Verify(u.CaptchaResponse, c.ClientIP(), CAPTCHA_ACTION_OTP)
 	if err != nil {
 		ctl.failJSONWithVagueError(c, "OTP", err)
 		return
 	}
 	if !valid {
-		ctl.failJSONWithVagueError(c, "OTP", errors.New("failed to pass recaptcha"))
+		ctl.failJSONWithVagueError(c, "OTP", errors.New("failed to pass captcha"))
 		return
 	}
 
@@ -165,6 +229,27 @@ func (ctl *Controller) OTP(c *gin.Context) {
 		return
 	}
 
+	// Don't send any password to the locked account
+	if ctl.isLoginLocked(user.Username) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("登入失敗次數過多, 請 %d 分鐘後再試", LOGIN_LOCKOUT_MINUTE)})
+		return
+	}
+
+	// Users who only use authenticator app don't need telegram
+	setting, err := ctl.getUserAuthSetting(user.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "OTP", err)
+		return
+	}
+	if setting.IsDisabled() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "帳號已停用"})
+		return
+	}
+	if !setting.RequiresTelegram() {
+		c.JSON(http.StatusOK, gin.H{"second_factor": setting.SecondFactor})
+		return
+	}
+
 	// Generate one-time password, length 23 that contains 4 digits and 4 symbols
 	otp, err := password.Generate(18, 3, 3, false, false)
 	if err != nil {
@@ -176,7 +261,7 @@ func (ctl *Controller) OTP(c *gin.Context) {
 	ctl.sender.Send(user.TelegramChatId, "One-time password:")
 	ctl.sender.Send(user.TelegramChatId, otp)
 
-	// Hash password with sha256
+	// Hash password with argon2id
 	otpHash, err := ctl.hashPassword(otp)
 	if err != nil {
 		ctl.failJSONWithVagueError(c, "456 OTP", err)
@@ -193,104 +278,175 @@ func (ctl *Controller) OTP(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{})
+	c.JSON(http.StatusOK, gin.H{"second_factor": setting.SecondFactor})
 }
 
 func (ctl *Controller) Logout(c *gin.Context) {
+	// Revoke the server side session as well, in case the cookie has been copied
+	if session, err := ctl.store.Get(c.Request, "user-session"); err == nil {
+		if sessionId, ok := session.Values["session_id"].(string); ok {
+			data := map[string]interface{}{
+				"revoked_at": time.Now(),
+			}
+			if _, err := ctl.model.UpdateUserSession(sessionId, data); err != nil {
+				ctl.log.Println("Logout err:", err)
+			}
+		}
+	}
 	ctl.clearSession(c)
 	ctl.redirectToLoginPage(c, "/login?success=true")
 }
 
-func (ctl *Controller) checkRecaptcha(response string) (bool, error) {
-	var googleCaptcha string = viper.GetString("RECAPTCHA_SECRET")
-	req, err := http.NewRequest("POST", RECAPTCHA_VERIFY_URL, nil)
-	q := req.URL.Query()
-	q.Add("secret", googleCaptcha)
-	q.Add("response", response)
-	req.URL.RawQuery = q.Encode()
-	client := &http.Client{}
-	var googleResponse map[string]interface{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return false, err
+func (ctl *Controller) tokenAuthCheck(c *gin.Context) bool {
+	// Already authenticated by the middleware
+	if _, ok := c.Get(USER_DATA_CONTEXT_KEY); ok {
+		return true
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return false, err
+
+	// Scripts using API token expect JSON rather than login page
+	if hasBearerToken(c) {
+		return ctl.apiAuthCheck(c)
 	}
-	err = json.Unmarshal(body, &googleResponse)
-	if err != nil {
-		return false, err
+
+	if errType := ctl.checkSession(c); errType != "" {
+		ctl.redirectToLoginPage(c, "/login?err="+errType)
+		return false
 	}
-	success := googleResponse["success"].(bool)
-	return success, nil
+	return true
 }
 
-func (ctl *Controller) tokenAuthCheck(c *gin.Context) bool {
+// Same as tokenAuthCheck, but responds JSON instead of redirecting to login page
+func (ctl *Controller) apiAuthCheck(c *gin.Context) bool {
+	if _, ok := c.Get(USER_DATA_CONTEXT_KEY); ok {
+		return true
+	}
+
+	var errType string
+	if hasBearerToken(c) {
+		errType = ctl.checkApiToken(c)
+	} else {
+		errType = ctl.checkSession(c)
+	}
+
+	switch errType {
+	case "":
+		return true
+	case "insufficient_scope":
+		ctl.failAPI(c, http.StatusForbidden, API_ERR_FORBIDDEN, errType)
+	default:
+		ctl.failAPI(c, http.StatusUnauthorized, API_ERR_UNAUTHORIZED, errType)
+	}
+	return false
+}
+
+// checkSession returns the error type if the session is invalid, otherwise empty string
+func (ctl *Controller) checkSession(c *gin.Context) string {
 	session, err := ctl.store.Get(c.Request, "user-session")
 	if err != nil {
-		ctl.log.Println("tokenAuthCheck err:", err)
-		ctl.redirectToLoginPage(c, "/login?err=internal_error")
-		return false
+		ctl.log.Println("checkSession err:", err)
+		return "internal_error"
 	}
 
 	expiryTs, ok := session.Values["expiry_ts"].(int64)
 	if ok {
 		if time.Now().Unix() > expiryTs {
-			ctl.redirectToLoginPage(c, "/login?err=session_expired")
-			return false
+			return "session_expired"
 		}
 	} else {
-		ctl.redirectToLoginPage(c, "/login?err=please_login")
-		return false
+		return "please_login"
 	}
 
 	uuid, ok := session.Values["uuid"].(string)
 	if !ok {
-		ctl.redirectToLoginPage(c, "/login?err=please_login")
-		return false
+		return "please_login"
 	}
 
 	role, ok := session.Values["role"].(int64)
 	if !ok {
-		ctl.redirectToLoginPage(c, "/login?err=please_login")
-		return false
+		return "please_login"
+	}
+
+	sessionId, ok := session.Values["session_id"].(string)
+	if !ok {
+		return "please_login"
+	}
+
+	sessionVersion, ok := session.Values["session_version"].(int64)
+	if !ok {
+		return "please_login"
 	}
 
 	// signature signed by server
 	signature, ok := session.Values["signature"].(string)
 	if !ok {
-		ctl.redirectToLoginPage(c, "/login?err=please_login")
-		return false
+		return "please_login"
 	}
 	signatureHash, err := base64.StdEncoding.DecodeString(signature)
 	if err != nil {
-		ctl.redirectToLoginPage(c, "/login?err=internal_error")
-		return false
+		return "internal_error"
 	}
 
 	// hash gnenrated by cookie data
-	prefixKey := ctl.getSignaturePrefixKey(uuid, role, expiryTs)
-	hash, err := ctl.getSignatureHash(prefixKey)
+	prefixKey := ctl.getSignaturePrefixKey(sessionId, uuid, role, expiryTs, sessionVersion)
+	hash, err := ctl.getSessionSignature(prefixKey)
 	if err != nil {
-		ctl.redirectToLoginPage(c, "/login?err=internal_error")
-		return false
+		return "internal_error"
 	}
 
-	if !reflect.DeepEqual(signatureHash, hash) {
-		ctl.redirectToLoginPage(c, "/login?err=internal_error")
-		return false
+	// NOTE constant-time comparison
+	if !hmac.Equal(signatureHash, hash) {
+		return "internal_error"
 	}
 
-	return true
+	// Sessions issued before the version was increased are no longer valid
+	setting, err := ctl.getUserAuthSetting(uuid)
+	if err != nil {
+		ctl.log.Println("checkSession err:", err)
+		return "internal_error"
+	}
+	if setting.SessionVersion != sessionVersion {
+		return "session_revoked"
+	}
+	if setting.IsDisabled() {
+		return "account_disabled"
+	}
+
+	// Server side session
+	if errType := ctl.checkUserSession(c, sessionId, uuid); errType != "" {
+		return errType
+	}
+
+	c.Set(USER_DATA_CONTEXT_KEY, &UserData{
+		Uuid:      uuid,
+		Role:      role,
+		SessionId: sessionId,
+	})
+	return ""
 }
 
-func (ctl *Controller) getSignaturePrefixKey(uuid string, role int64, expiryTs int64) []byte {
-	s := fmt.Sprintf("%s-%d-%d", uuid, expiryTs)
+func (ctl *Controller) getSignaturePrefixKey(sessionId string, uuid string, role int64, expiryTs int64, sessionVersion int64) []byte {
+	s := fmt.Sprintf("%s-%s-%d-%d-%d", sessionId, uuid, role, expiryTs, sessionVersion)
 	return []byte(s)
 }
 
+// getSessionSignature signs session cookies with HMAC-SHA256
+func (ctl *Controller) getSessionSignature(prefixKey []byte) ([]byte, error) {
+	key, err := hex.DecodeString(viper.GetString("SESSION_SIGNATURE_KEY"))
+	if err != nil {
+		return []byte{}, err
+	}
+	if len(key) == 0 {
+		return []byte{}, errors.New("SESSION_SIGNATURE_KEY is empty")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	if _, err = mac.Write(prefixKey); err != nil {
+		return []byte{}, err
+	}
+	return mac.Sum(nil), nil
+}
+
+// getSignatureHash is salted sha256, only for the data looked up by hash (e.g. recovery codes) and legacy password hashes
 func (ctl *Controller) getSignatureHash(sigKey []byte) ([]byte, error) {
 	// Combine prefix key with salt
 	salt, err := hex.DecodeString(viper.GetString("SHA256_HASH_SALT"))
@@ -308,10 +464,18 @@ func (ctl *Controller) getSignatureHash(sigKey []byte) ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
-func (ctl *Controller) hashPassword(pwd string) (string, error) {
-	hash, err := ctl.getSignatureHash([]byte(pwd))
-	if err != nil {
-		return "", err
+// getCaptchaProvider defaults to reCAPTCHA for backward compatibility
+func getCaptchaProvider() string {
+	if provider := viper.GetString("CAPTCHA_PROVIDER"); provider != "" {
+		return provider
+	}
+	return captcha.PROVIDER_RECAPTCHA
+}
+
+// getCaptchaConfig reads `CAPTCHA_<key>`, and falls back to the legacy `RECAPTCHA_<key>`
+func getCaptchaConfig(key string) string {
+	if v := viper.GetString("CAPTCHA_" + key); v != "" {
+		return v
 	}
-	return hex.EncodeToString(hash), nil
+	return viper.GetString("RECAPTCHA_" + key)
 }
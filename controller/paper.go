@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	"crypto-trading-bot-api/util/exchangeinfo"
+	"crypto-trading-bot-api/util/paper"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+)
+
+const (
+	PAPER_DEFAULT_INITIAL_BALANCE = 10000
+	PAPER_DEFAULT_LEVERAGE        = 10
+	PAPER_DEFAULT_FEE_RATE        = "0.0007"
+	PAPER_TRIGGER_INTERVAL_SECOND = 5
+)
+
+// newPaperTrading reads PAPER_PRICE_SOURCE, paper trading is disabled (nil) if it's not set. It's disabled unless
+// PAPER_ENGINE_ENABLED is set as well.
+//
+// NOTE paper trading is blocked on crypto-trading-bot-engine, which must build the same simulated exchange to open the
+// positions of PAPER_* strategies, don't set PAPER_ENGINE_ENABLED before it does
+func newPaperTrading() (paper.PriceSource, paper.Config, error) {
+	cfg := paper.Config{
+		Leverage: decimal.NewFromInt(PAPER_DEFAULT_LEVERAGE),
+	}
+	cfg.FeeRate, _ = decimal.NewFromString(PAPER_DEFAULT_FEE_RATE)
+	if viper.IsSet("PAPER_LEVERAGE") {
+		cfg.Leverage = decimal.NewFromFloat(viper.GetFloat64("PAPER_LEVERAGE"))
+	}
+	if viper.IsSet("PAPER_FEE_RATE") {
+		cfg.FeeRate = decimal.NewFromFloat(viper.GetFloat64("PAPER_FEE_RATE"))
+	}
+
+	if viper.GetString("PAPER_PRICE_SOURCE") == "" || !viper.GetBool("PAPER_ENGINE_ENABLED") {
+		return nil, cfg, nil
+	}
+	prices, err := paper.NewPriceSource(paper.PriceConfig{
+		Source:      viper.GetString("PAPER_PRICE_SOURCE"),
+		FeedURL:     viper.GetString("PAPER_PRICE_FEED_URL"),
+		CandlesDir:  viper.GetString("PAPER_CANDLES_DIR"),
+		ReplaySpeed: viper.GetFloat64("PAPER_REPLAY_SPEED"),
+	})
+	return prices, cfg, err
+}
+
+// runPaperTriggers fills the stop-loss orders of paper accounts whose trigger price is reached
+func (ctl *Controller) runPaperTriggers() {
+	for range time.Tick(time.Second * PAPER_TRIGGER_INTERVAL_SECOND) {
+		if err := paper.CheckAllTriggers(ctl.model, ctl.paperPrices, ctl.paperConfig); err != nil {
+			ctl.log.Println("[ERROR] runPaperTriggers err:", err)
+		}
+	}
+}
+
+// CreatePaperCredential creates a paper account, which is chosen by the strategies like the other credentials
+func (ctl *Controller) CreatePaperCredential(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	// Credentials can't be managed by API token
+	if ctl.getUserData(c).ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if ctl.paperPrices == nil {
+		ctl.redirectToLoginPage(c, "/user/credentials?err=paper_disabled")
+		return
+	}
+
+	exchangeName := c.DefaultPostForm("exchange", viper.GetString("DEFAULT_EXCHANGE"))
+	if !isEnabledExchange(exchangeName) {
+		ctl.redirectToLoginPage(c, "/user/credentials?err=exchange_unsupported")
+		return
+	}
+	label := strings.TrimSpace(c.PostForm("label"))
+	if label == "" {
+		ctl.redirectToLoginPage(c, "/user/credentials?err=empty_data")
+		return
+	}
+	if len(label) > CREDENTIAL_LABEL_MAX_LENGTH {
+		ctl.redirectToLoginPage(c, "/user/credentials?err=label_too_long")
+		return
+	}
+	balance := decimal.NewFromInt(PAPER_DEFAULT_INITIAL_BALANCE)
+	if c.PostForm("initial_balance") != "" {
+		var err error
+		balance, err = decimal.NewFromString(c.PostForm("initial_balance"))
+		if err != nil || !balance.IsPositive() {
+			ctl.redirectToLoginPage(c, "/user/credentials?err=invalid_balance")
+			return
+		}
+	}
+
+	userCookie := ctl.getUserData(c)
+	credentials, _, err := ctl.model.GetExchangeCredentialsByUser(userCookie.Uuid)
+	if err != nil {
+		ctl.log.Println("CreatePaperCredential err:", err)
+		ctl.redirectToLoginPage(c, "/user/credentials?err=internal_error")
+		return
+	}
+	for _, ec := range credentials {
+		if ec.Label == label {
+			ctl.redirectToLoginPage(c, "/user/credentials?err=duplicate_label")
+			return
+		}
+	}
+
+	// NOTE paper accounts are never the default, users.exchange_api_key is read by engine
+	now := time.Now()
+	credential := model.ExchangeCredential{
+		Uuid:           uuid.New().String(),
+		UserUuid:       userCookie.Uuid,
+		Label:          label,
+		Exchange:       exchangeinfo.PaperName(exchangeName),
+		VerifyStatus:   CREDENTIAL_STATUS_OK,
+		CanTrade:       true,
+		LastVerifiedAt: &now,
+	}
+	if _, _, err = ctl.model.CreateExchangeCredential(credential); err != nil {
+		ctl.log.Println("CreatePaperCredential err:", err)
+		ctl.redirectToLoginPage(c, "/user/credentials?err=internal_error")
+		return
+	}
+	account := model.PaperAccount{
+		CredentialUuid: credential.Uuid,
+		InitialBalance: balance,
+		Balance:        balance,
+	}
+	if _, _, err = ctl.model.CreatePaperAccount(account); err != nil {
+		ctl.log.Println("CreatePaperCredential err:", err)
+		ctl.redirectToLoginPage(c, "/user/credentials?err=internal_error")
+		return
+	}
+
+	ctl.redirectToLoginPage(c, "/user/credentials?success=create_paper")
+}
+
+func (ctl *Controller) newPaperExchange(credential *model.ExchangeCredential) *paper.Exchange {
+	return paper.NewExchange(ctl.model, credential.Uuid, ctl.paperPrices, ctl.paperConfig)
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id parameters, changing them makes the existing hashes rehashed on the next login
+const (
+	ARGON2_TIME     = 1
+	ARGON2_MEMORY   = 64 * 1024
+	ARGON2_THREADS  = 4
+	ARGON2_KEY_LEN  = 32
+	ARGON2_SALT_LEN = 16
+)
+
+// hashPassword returns the argon2id hash with a random salt in PHC string format,
+// e.g. $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+func (ctl *Controller) hashPassword(pwd string) (string, error) {
+	salt := make([]byte, ARGON2_SALT_LEN)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey([]byte(pwd), salt, ARGON2_TIME, ARGON2_MEMORY, ARGON2_THREADS, ARGON2_KEY_LEN)
+
+	s := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		ARGON2_MEMORY,
+		ARGON2_TIME,
+		ARGON2_THREADS,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	)
+	return s, nil
+}
+
+// verifyPassword compares the password with the stored hash in constant time,
+// needsRehash is true if the hash is legacy sha256 or made with outdated parameters
+func (ctl *Controller) verifyPassword(encoded string, pwd string) (ok bool, needsRehash bool, err error) {
+	if encoded == "" {
+		return false, false, nil
+	}
+
+	// Legacy hash, sha256 in hex with the global salt
+	if !strings.HasPrefix(encoded, "$argon2id$") {
+		legacyHash, err := ctl.getSignatureHash([]byte(pwd))
+		if err != nil {
+			return false, false, err
+		}
+		ok = subtle.ConstantTimeCompare([]byte(encoded), []byte(hex.EncodeToString(legacyHash))) == 1
+		return ok, ok, nil
+	}
+
+	// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, err
+	}
+	if version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, false, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, err
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, err
+	}
+
+	pwdHash := argon2.IDKey([]byte(pwd), salt, iterations, memory, threads, uint32(len(hash)))
+	if subtle.ConstantTimeCompare(hash, pwdHash) != 1 {
+		return false, false, nil
+	}
+	needsRehash = memory != ARGON2_MEMORY || iterations != ARGON2_TIME || threads != ARGON2_THREADS || len(hash) != ARGON2_KEY_LEN
+	return true, needsRehash, nil
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/contract"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	POSITION_WORKER_INTERVAL_SECOND = 10
+)
+
+// The orders of a strategy are changed by the site one by one, e.g. the stop-loss order replaced by the trailing
+// stop-loss and the strategy disabled by the user
+var strategyLocks sync.Map
+
+func lockStrategy(uuid string) func() {
+	mu, _ := strategyLocks.LoadOrStore(uuid, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// runPositionWorker manages the orders of the opened strategies which the engine doesn't know, strategy by strategy in
+// a single goroutine, so that two rules never change the orders of the same position at the same time
+func (ctl *Controller) runPositionWorker() {
+	// Take profit first, the stop-loss order is replaced by the remaining size, and the break-even rule may follow the
+	// closed level. Trailing is the last, the stop-loss is only moved to the better side
+	rules := []struct {
+		name  string
+		uuids func() ([]string, error)
+		run   func(uuid string) error
+	}{
+		{"take profit by levels", ctl.model.GetTakeProfitLadderStrategyUuids, ctl.takeProfitLadder},
+		{"move stop-loss to break-even", ctl.model.GetBreakEvenStrategyUuids, ctl.moveStopLossToBreakEven},
+		{"trail stop-loss", ctl.model.GetTrailingStopStrategyUuids, ctl.trailStopLoss},
+	}
+	for range time.Tick(time.Second * POSITION_WORKER_INTERVAL_SECOND) {
+		for _, rule := range rules {
+			uuids, err := rule.uuids()
+			if err != nil {
+				ctl.log.Println("[ERROR] runPositionWorker err:", err)
+				continue
+			}
+			for _, uuid := range uuids {
+				if err := rule.run(uuid); err != nil {
+					ctl.log.Printf("[ERROR] failed to %s of strategy '%s', err: %v", rule.name, uuid, err)
+				}
+			}
+		}
+	}
+}
+
+// isTrackedPosition returns true if the engine tracks the opened position of the strategy
+func isTrackedPosition(cs *db.ContractStrategy) bool {
+	return cs.Enabled == 1 && contract.Status(cs.PositionStatus) == contract.OPENED
+}
+
+// withStrategyDisabled runs fn while the engine doesn't track the strategy. The strategy is read again after it's
+// disabled, fn isn't run unless the position is still opened and the strategy is still enabled, e.g. it's closed by the
+// engine or disabled by the user in the meantime, and it's enabled again only in that case as well. If fn returns
+// errStopLossLost, the strategy is left disabled so that the engine doesn't manage the position without stop-loss
+func (ctl *Controller) withStrategyDisabled(uuid string, fn func(cs *db.ContractStrategy) error) (err error) {
+	unlock := lockStrategy(uuid)
+	defer unlock()
+
+	cs, err := ctl.db.GetContractStrategyByUuid(uuid)
+	if err != nil {
+		return err
+	}
+	if !isTrackedPosition(cs) {
+		return nil
+	}
+
+	if _, err = ctl.makeRequestToEngine(fmt.Sprintf("/event?action=disable&uuid=%s", uuid)); err != nil {
+		return err
+	}
+	defer func() {
+		if errors.Is(err, errStopLossLost) {
+			ctl.disableStrategyWithoutStopLoss(uuid)
+			return
+		}
+		cs, readErr := ctl.db.GetContractStrategyByUuid(uuid)
+		if readErr != nil {
+			if err == nil {
+				err = readErr
+			}
+			return
+		}
+		if !isTrackedPosition(cs) {
+			return
+		}
+		if _, enableErr := ctl.makeRequestToEngine(fmt.Sprintf("/event?action=enable&uuid=%s", uuid)); enableErr != nil && err == nil {
+			err = enableErr
+		}
+	}()
+
+	// The position may be updated by the engine before it's disabled
+	if cs, err = ctl.db.GetContractStrategyByUuid(uuid); err != nil {
+		return err
+	}
+	if !isTrackedPosition(cs) {
+		return nil
+	}
+	return fn(cs)
+}
+
+// disableStrategyWithoutStopLoss disables the strategy whose stop-loss order is lost, and notifies the user to set the
+// stop-loss manually
+func (ctl *Controller) disableStrategyWithoutStopLoss(uuid string) {
+	if _, err := ctl.db.UpdateContractStrategy(uuid, map[string]interface{}{"enabled": 0}); err != nil {
+		ctl.log.Println("[ERROR] failed to update db, err:", err)
+	}
+	cs, err := ctl.db.GetContractStrategyByUuid(uuid)
+	if err != nil {
+		ctl.log.Println("[ERROR] disableStrategyWithoutStopLoss err:", err)
+		return
+	}
+	user, err := ctl.db.GetUserByUuid(cs.UserUuid)
+	if err != nil {
+		ctl.log.Println("[ERROR] disableStrategyWithoutStopLoss err:", err)
+		return
+	}
+	if user.TelegramChatId != 0 {
+		ctl.sender.Send(user.TelegramChatId, fmt.Sprintf("策略 %s (%s) 的停損單更新失敗且無法還原, 倉位目前沒有停損單, 策略已暫停. 請盡快手動設定停損", cs.Symbol, cs.Uuid))
+	}
+}
@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	"crypto-trading-bot-api/util/exchangeinfo"
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/strategy/trigger"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// fieldErrors are the errors of the form by the keys of the html forms, e.g. "entry[price]", so that the forms can
+// highlight the fields
+type fieldErrors map[string]string
+
+func (e fieldErrors) Error() string {
+	var msgs []string
+	for _, key := range []string{"margin", "entry[price]", "stop_loss[price]", "take_profit[price]"} {
+		if msg, ok := e[key]; ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	for i := 1; i <= TAKE_PROFIT_MAX_LEVELS; i++ {
+		if msg, ok := e[fmt.Sprintf("take_profit[level_price_%d]", i)]; ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// respondStrategyError responds the fields along with the error if it's fieldErrors
+func respondStrategyError(c *gin.Context, err error) {
+	resp := gin.H{"error": err.Error()}
+	var fields fieldErrors
+	if errors.As(err, &fields) {
+		resp["fields"] = fields
+	}
+	c.JSON(http.StatusBadRequest, resp)
+}
+
+// failAPIWithFields is failAPI with the fields if it's fieldErrors
+func (ctl *Controller) failAPIWithFields(c *gin.Context, err error) {
+	var fields fieldErrors
+	if !errors.As(err, &fields) {
+		ctl.failAPI(c, http.StatusBadRequest, API_ERR_INVALID_PARAMS, err.Error())
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+		"code":   API_ERR_INVALID_PARAMS,
+		"error":  err.Error(),
+		"fields": fields,
+	})
+}
+
+// getSymbolRule returns the rule of the symbol of the strategy, the zero rule if there's none
+func (ctl *Controller) getSymbolRule(strategy *db.ContractStrategy) (*model.SymbolRule, error) {
+	// Paper accounts follow the rules of the underlying exchange
+	exchangeName := exchangeinfo.Underlying(strategy.Exchange)
+	rule, err := ctl.model.GetSymbolRule(exchangeName, strategy.Symbol)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctl.log.Printf("[WARN] symbol rule of '%s' of %s isn't found, the prices and size aren't checked", strategy.Symbol, exchangeName)
+		return &model.SymbolRule{}, nil
+	}
+	if err != nil {
+		ctl.log.Println("[ERROR] getSymbolRule err:", err)
+		return nil, errors.New("Internal error")
+	}
+	return rule, nil
+}
+
+// warnMissingSymbolRules logs the enabled symbols which don't have a rule in symbol_rules, nothing fills the table, so
+// the rules have to be inserted when a symbol is enabled
+func (ctl *Controller) warnMissingSymbolRules() {
+	for _, e := range getEnabledExchanges() {
+		symbols, _, err := ctl.db.GetEnabledContractSymbols(e.Name)
+		if err != nil {
+			ctl.log.Println("[ERROR] warnMissingSymbolRules err:", err)
+			return
+		}
+		var missing []string
+		for _, s := range symbols {
+			_, err := ctl.model.GetSymbolRule(e.Name, s.Name)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				missing = append(missing, s.Name)
+			} else if err != nil {
+				ctl.log.Println("[ERROR] warnMissingSymbolRules err:", err)
+				return
+			}
+		}
+		if len(missing) > 0 {
+			ctl.log.Printf("[WARN] symbol rules of %s aren't found, the prices and size aren't checked: %s", e.Name, strings.Join(missing, ", "))
+		}
+	}
+}
+
+// checkPrice sets the error of the key if the price isn't a multiple of the tick size or out of the price band
+func (fields fieldErrors) checkPrice(rule *model.SymbolRule, marketPrice decimal.Decimal, key string, price decimal.Decimal) {
+	if rule.TickSize.IsPositive() && !price.Mod(rule.TickSize).IsZero() {
+		fields[key] = fmt.Sprintf("價格需為 %s 的倍數", rule.TickSize.String())
+		return
+	}
+	if rule.PriceBandPercent.IsPositive() && price.Sub(marketPrice).Abs().GreaterThan(marketPrice.Mul(rule.PriceBandPercent)) {
+		fields[key] = fmt.Sprintf("價格需在市價 %s 的 %s%% 以內", marketPrice.String(), rule.PriceBandPercent.Mul(decimal.NewFromInt(100)).String())
+	}
+}
+
+// validatePreTrade checks the strategy against the rules of the symbol and the account before it's saved, so that the
+// orders won't be rejected by the exchange later. It returns fieldErrors if any of the fields is invalid
+func (ctl *Controller) validatePreTrade(ex exchange.Exchanger, strategy *db.ContractStrategy) error {
+	rule, err := ctl.getSymbolRule(strategy)
+	if err != nil {
+		return err
+	}
+
+	side := order.Side(strategy.Side)
+	ct, err := contract.NewContract(side, strategy.Params)
+	if err != nil {
+		return err
+	}
+	accountInfo, err := ex.GetAccountInfo()
+	if err != nil {
+		ctl.log.Println("[ERROR] validatePreTrade - failed to get account info, err:", err)
+		return fmt.Errorf("%s API server 無回應或 API Key 已失效", exchangeDisplayName(strategy.Exchange))
+	}
+	marketPrice, err := ex.GetMarketPrice(strategy.Symbol)
+	if err != nil {
+		ctl.log.Println("[ERROR] validatePreTrade - failed to get market price, err:", err)
+		return fmt.Errorf("%s API server 無回應", exchangeDisplayName(strategy.Exchange))
+	}
+
+	fields := fieldErrors{}
+	checkPrice := func(key string, price decimal.Decimal) {
+		fields.checkPrice(rule, marketPrice, key, price)
+	}
+
+	// The entry price of market strategies is the market price, and the trendline moves by time
+	entryPrice := marketPrice
+	if strategyEntryType(strategy.Params) != ENTRY_MARKET {
+		entryPrice = ct.EntryOrder.GetTrigger().GetPrice(time.Now())
+	}
+	if ct.EntryType == order.ENTRY_LIMIT && strategyEntryType(strategy.Params) != ENTRY_MARKET {
+		checkPrice("entry[price]", entryPrice)
+	}
+	// The trendline stop-loss is set after entry
+	if ct.EntryType == order.ENTRY_LIMIT && ct.StopLossOrder != nil && ct.StopLossOrder.GetTrigger() != nil {
+		checkPrice("stop_loss[price]", ct.StopLossOrder.GetTrigger().GetPrice(time.Now()))
+	}
+	if ct.TakeProfitOrder != nil && ct.TakeProfitOrder.GetTrigger() != nil {
+		checkPrice("take_profit[price]", ct.TakeProfitOrder.GetTrigger().GetPrice(time.Now()))
+	}
+	for i, level := range getTakeProfitLevels(strategy.Params) {
+		checkPrice(fmt.Sprintf("take_profit[level_price_%d]", i+1), level.Price)
+	}
+
+	// Margin is the position value, e.g. the leverage of the list page is margin divided by collateral
+	collateral := accountInfo["collateral"].(decimal.Decimal)
+	availableMargin := accountInfo["free_collateral"].(decimal.Decimal).Mul(accountInfo["leverage"].(decimal.Decimal))
+	switch {
+	case !strategy.Margin.IsPositive():
+		fields["margin"] = "保證金需大於 0"
+	case entryPrice.IsPositive() && rule.MinSize.IsPositive() && strategy.Margin.Div(entryPrice).LessThan(rule.MinSize):
+		fields["margin"] = fmt.Sprintf("開倉數量 %s 小於最小數量 %s", strategy.Margin.Div(entryPrice).Round(8).String(), rule.MinSize.String())
+	case rule.MaxLeverage > 0 && strategy.Margin.GreaterThan(collateral.Mul(decimal.NewFromInt(rule.MaxLeverage))):
+		fields["margin"] = fmt.Sprintf("保證金超過本金的最大槓桿 %dx", rule.MaxLeverage)
+	case strategy.Margin.GreaterThan(availableMargin):
+		fields["margin"] = fmt.Sprintf("保證金 %s 超過總可用餘額 %s", strategy.Margin.String(), availableMargin.StringFixed(1))
+	}
+
+	if len(fields) > 0 {
+		return fields
+	}
+	return nil
+}
+
+// validateNewStrategy is validatePreTrade of the strategy to be created, with the exchange of credential_uuid of the form
+func (ctl *Controller) validateNewStrategy(userUuid string, form formGetter, strategy *db.ContractStrategy) error {
+	ex, err := ctl.newStrategyExchange(userUuid, form)()
+	if err != nil {
+		return err
+	}
+	return ctl.validatePreTrade(ex, strategy)
+}
+
+// validateStrategyUpdate is validatePreTrade of the strategy with the data of processStrategyUpdate
+func (ctl *Controller) validateStrategyUpdate(strategy *db.ContractStrategy, data map[string]interface{}) error {
+	ex, err := ctl.newExchangeByUser(strategy.UserUuid, strategy.Uuid)
+	if err != nil {
+		return err
+	}
+	updated := *strategy
+	updated.Margin = data["margin"].(decimal.Decimal)
+	updated.Params = data["params"].(datatypes.JSONMap)
+	return ctl.validatePreTrade(ex, &updated)
+}
+
+// validateTpSl checks the prices of the stop-loss and take-profit form against the rules of the symbol like
+// validatePreTrade, before applyTpSl replaces the orders. The invalid params are left to applyTpSl
+func (ctl *Controller) validateTpSl(ex exchange.Exchanger, strategy *db.ContractStrategy, form formGetter) error {
+	rule, err := ctl.getSymbolRule(strategy)
+	if err != nil {
+		return err
+	}
+	marketPrice, err := ex.GetMarketPrice(strategy.Symbol)
+	if err != nil {
+		ctl.log.Println("[ERROR] validateTpSl - failed to get market price, err:", err)
+		return fmt.Errorf("%s API server 無回應", exchangeDisplayName(strategy.Exchange))
+	}
+
+	fields := fieldErrors{}
+	checkTrigger := func(prefix string) {
+		t, err := trigger.NewTrigger(map[string]interface{}{
+			"trigger_type": form(prefix + "[trigger_type]"),
+			"operator":     form(prefix + "[operator]"),
+			"price":        form(prefix + "[price]"),
+		})
+		if err != nil {
+			return
+		}
+		fields.checkPrice(rule, marketPrice, prefix+"[price]", t.GetPrice(time.Now()))
+	}
+
+	// Same cases as applyTpSl, the stop-loss of trendline strategies is only changed after entry
+	switch strategy.Params["entry_type"].(string) {
+	case order.ENTRY_LIMIT:
+		if form("stop_loss[enabled]") == "1" {
+			checkTrigger("stop_loss")
+		}
+	case order.ENTRY_TRENDLINE:
+		if _, ok := strategy.Params["stop_loss_order"]; ok && contract.Status(strategy.PositionStatus) == contract.OPENED {
+			checkTrigger("stop_loss")
+		}
+	}
+	if form("take_profit[enabled]") == "1" {
+		checkTrigger("take_profit")
+		for i := 1; i <= TAKE_PROFIT_MAX_LEVELS; i++ {
+			key := fmt.Sprintf("take_profit[level_price_%d]", i)
+			if price, err := decimal.NewFromString(form(key)); err == nil {
+				fields.checkPrice(rule, marketPrice, key, price)
+			}
+		}
+	}
+
+	if len(fields) > 0 {
+		return fields
+	}
+	return nil
+}
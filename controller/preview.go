@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/util/backtest"
+	"crypto-trading-bot-api/util/exchangeinfo"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/strategy/trigger"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Max points of the preview chart, the resolution is raised to the next one if there are more
+	PREVIEW_MAX_POINTS = 1000
+)
+
+// Resolutions of the preview chart in seconds, same as the options of the backtest panel
+var previewResolutions = []int64{60, 300, 900, 3600, 14400, 86400}
+
+// for API response
+type TrendlinePreview struct {
+	Resolution int64              `json:"resolution"`
+	Candles    int                `json:"candles"` // stored candles within the range
+	Points     []APIBacktestPoint `json:"points"`
+}
+
+// PreviewTrendline projects the trendline of the form to the candle intervals around the two points, along with the
+// stored candles of the symbol, posted by the new and edit trendline pages
+func (ctl *Controller) PreviewTrendline(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userCookie := ctl.getUserData(c)
+
+	// Exchange, symbol and side are of the strategy for the edit page, or of the form for the new page
+	var exchangeName, symbol string
+	var side int64
+	if uuid := c.PostForm("strategy_uuid"); uuid != "" {
+		strategy, err := ctl.db.GetContractStrategyByUuidByUser(uuid, userCookie.Uuid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
+			return
+		}
+		exchangeName, symbol, side = strategy.Exchange, strategy.Symbol, strategy.Side
+	} else {
+		credential, err := ctl.validateStrategyCredential(userCookie.Uuid, c.PostForm("credential_uuid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		exchangeName, symbol = strategyExchange(credential), c.PostForm("symbol")
+		if err = ctl.validateSymbol(exchangeName, symbol); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		side, err = strconv.ParseInt(c.PostForm("side"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "side is invalid"})
+			return
+		}
+	}
+
+	contractParams, err := ctl.processTrendlineContractParams(c.PostForm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ct, err := contract.NewContract(order.Side(side), contractParams)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entry, ok := ct.EntryOrder.(*order.Entry)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "entry type not supported"})
+		return
+	}
+	line, ok := entry.TrendlineTrigger.(*trigger.Line)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "entry type not supported"})
+		return
+	}
+
+	// From a half of the line before the first point, to a half after the second point or now
+	span := line.Time2.Sub(line.Time1)
+	if span <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time_2 must be later than time_1"})
+		return
+	}
+	from := line.Time1.Add(-span / 2)
+	to := line.Time2
+	if now := time.Now(); now.After(to) {
+		to = now
+	}
+	to = to.Add(span / 2)
+
+	resolution, err := previewResolution(c.PostForm("resolution"), from, to)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	step := time.Second * time.Duration(resolution)
+	from, to = from.Truncate(step), to.Truncate(step)
+
+	// Paper accounts share the candles of the underlying exchange
+	rows, _, err := ctl.model.GetCandles(exchangeinfo.Underlying(exchangeName), symbol, resolution, from, to, PREVIEW_MAX_POINTS+1)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "PreviewTrendline", err)
+		return
+	}
+	stored := make(map[int64]backtest.Candle, len(rows))
+	for _, row := range rows {
+		stored[row.OpenTime.Unix()] = backtest.Candle{
+			Time:  row.OpenTime,
+			Open:  row.Open,
+			High:  row.High,
+			Low:   row.Low,
+			Close: row.Close,
+		}
+	}
+	var candles []backtest.Candle
+	for t := from; !t.After(to); t = t.Add(step) {
+		candle, ok := stored[t.Unix()]
+		if !ok {
+			candle = backtest.Candle{Time: t}
+		}
+		candles = append(candles, candle)
+	}
+
+	preview := TrendlinePreview{Resolution: resolution, Candles: len(rows)}
+	for _, p := range backtest.Preview(order.Side(side), ct, candles) {
+		preview.Points = append(preview.Points, newAPIBacktestPoint(p))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": preview})
+}
+
+// previewResolution returns the given resolution, or the next one if there are too many points within the range
+func previewResolution(value string, from time.Time, to time.Time) (int64, error) {
+	resolution := int64(BACKTEST_DEFAULT_RESOLUTION)
+	if value != "" {
+		var err error
+		resolution, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || resolution <= 0 {
+			return 0, errors.New("resolution is invalid")
+		}
+	}
+	for _, r := range previewResolutions {
+		if r < resolution {
+			continue
+		}
+		if int64(to.Sub(from).Seconds())/r <= PREVIEW_MAX_POINTS {
+			return r, nil
+		}
+	}
+	return 0, errors.New("趨勢線的時間區間過長")
+}
@@ -0,0 +1,404 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// Confirmation code sent to the new telegram chat id
+	TELEGRAM_CODE_EXPIRY_SECOND = 600
+	TELEGRAM_CODE_RESEND_SECOND = 60
+	TELEGRAM_CODE_MAX_ATTEMPTS  = 5
+
+	// Number of audit logs shown in profile page
+	PROFILE_AUDIT_LOG_LIMIT = 20
+)
+
+// Notification preferences, the keys are the same as the columns of user_profiles
+var notifyKeys = []string{
+	"notify_entry",
+	"notify_stop_loss",
+	"notify_take_profit",
+	"notify_error",
+}
+
+// for template
+type AuditLogTmpl struct {
+	Action    string
+	Detail    string
+	ByAdmin   bool
+	Ip        string
+	CreatedAt string
+}
+
+func (ctl *Controller) ProfilePage(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var errMsg string
+	user, err := ctl.db.GetUserByUuid(userData.Uuid)
+	if err != nil {
+		ctl.log.Printf("[ERROR] failed to get user by '%s', err: %v", userData.Uuid, err)
+		errMsg = "用戶不存在"
+	}
+	profile, err := ctl.getUserProfile(userData.Uuid)
+	if err != nil {
+		ctl.log.Println("ProfilePage err:", err)
+		errMsg = "Internal error"
+	}
+	logs, _, err := ctl.model.GetUserAuditLogsByUser(userData.Uuid, PROFILE_AUDIT_LOG_LIMIT)
+	if err != nil {
+		ctl.log.Println("ProfilePage err:", err)
+		errMsg = "Internal error"
+	}
+
+	loc := profile.Location()
+	var logTmpls []AuditLogTmpl
+	for _, l := range logs {
+		var details []string
+		for key, value := range l.Detail {
+			details = append(details, fmt.Sprintf("%s: %v", key, value))
+		}
+		logTmpls = append(logTmpls, AuditLogTmpl{
+			Action:    l.Action,
+			Detail:    strings.Join(details, ", "),
+			ByAdmin:   l.ActorUuid != l.UserUuid,
+			Ip:        l.Ip,
+			CreatedAt: l.CreatedAt.In(loc).Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	var pendingTelegramChatId int64
+	if profile.HasPendingTelegramChatId() {
+		pendingTelegramChatId = profile.PendingTelegramChatId
+	}
+
+	c.HTML(http.StatusOK, "profile.html", gin.H{
+		"loggedIn":              true,
+		"role":                  userData.Role,
+		"error":                 errMsg,
+		"username":              user.Username,
+		"telegramChatId":        user.TelegramChatId,
+		"pendingTelegramChatId": pendingTelegramChatId,
+		"timezone":              profile.Timezone,
+		"serverTimezone":        time.Local.String(),
+		"profile":               profile,
+		"auditLogs":             logTmpls,
+	})
+}
+
+// UpdateProfile updates the optional fields `username`, `timezone` and `notify_*`,
+// telegram chat id is updated by SendTelegramCode and VerifyTelegramCode
+func (ctl *Controller) UpdateProfile(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	user, err := ctl.db.GetUserByUuid(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "UpdateProfile", err)
+		return
+	}
+	profile, err := ctl.getUserProfile(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "UpdateProfile", err)
+		return
+	}
+
+	// Username
+	username, hasUsername := c.GetPostForm("username")
+	username = strings.TrimSpace(username)
+	if hasUsername && username != user.Username {
+		if err = ctl.validateNewUsername(username); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Timezone
+	timezone, hasTimezone := c.GetPostForm("timezone")
+	timezone = strings.TrimSpace(timezone)
+	if hasTimezone && timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timezone is invalid"})
+			return
+		}
+	}
+
+	// Notification preferences
+	profileData := make(map[string]interface{})
+	notifyChanges := make(map[string]interface{})
+	current := map[string]bool{
+		"notify_entry":       profile.NotifyEntry,
+		"notify_stop_loss":   profile.NotifyStopLoss,
+		"notify_take_profit": profile.NotifyTakeProfit,
+		"notify_error":       profile.NotifyError,
+	}
+	for _, key := range notifyKeys {
+		v, ok := c.GetPostForm(key)
+		if !ok {
+			continue
+		}
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": key + " is invalid"})
+			return
+		}
+		if enabled != current[key] {
+			profileData[key] = enabled
+			notifyChanges[key] = enabled
+		}
+	}
+	if hasTimezone && timezone != profile.Timezone {
+		profileData["timezone"] = timezone
+	}
+
+	if hasUsername && username != user.Username {
+		data := map[string]interface{}{
+			"username": username,
+		}
+		if _, err = ctl.db.UpdateUser(user.Uuid, data); err != nil {
+			ctl.failJSONWithVagueError(c, "UpdateProfile", err)
+			return
+		}
+		ctl.recordAudit(c, user.Uuid, model.AUDIT_ACTION_USERNAME_UPDATE, map[string]interface{}{
+			"from": user.Username,
+			"to":   username,
+		})
+	}
+	if len(profileData) > 0 {
+		if err = ctl.model.SaveUserProfile(user.Uuid, profileData); err != nil {
+			ctl.failJSONWithVagueError(c, "UpdateProfile", err)
+			return
+		}
+	}
+	if _, ok := profileData["timezone"]; ok {
+		ctl.recordAudit(c, user.Uuid, model.AUDIT_ACTION_TIMEZONE_UPDATE, map[string]interface{}{
+			"from": profile.Timezone,
+			"to":   timezone,
+		})
+	}
+	if len(notifyChanges) > 0 {
+		ctl.recordAudit(c, user.Uuid, model.AUDIT_ACTION_NOTIFICATIONS_UPDATE, notifyChanges)
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// SendTelegramCode sends the confirmation code to the new telegram chat id, it's saved after being verified by VerifyTelegramCode
+func (ctl *Controller) SendTelegramCode(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	telegramChatId, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("telegram_chat_id")), 10, 64)
+	if err != nil || telegramChatId == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "telegram_chat_id is invalid"})
+		return
+	}
+	user, err := ctl.db.GetUserByUuid(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "SendTelegramCode", err)
+		return
+	}
+	if telegramChatId == user.TelegramChatId {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "與目前的 Telegram Chat ID 相同"})
+		return
+	}
+	profile, err := ctl.getUserProfile(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "SendTelegramCode", err)
+		return
+	}
+	if profile.TelegramCodeSentAt != nil && time.Since(*profile.TelegramCodeSentAt) < time.Second*TELEGRAM_CODE_RESEND_SECOND {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("請 %d 秒後再試", TELEGRAM_CODE_RESEND_SECOND)})
+		return
+	}
+
+	code, err := generateTelegramCode()
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "SendTelegramCode", err)
+		return
+	}
+	codeHash, err := ctl.hashTelegramCode(userData.Uuid, telegramChatId, code)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "SendTelegramCode", err)
+		return
+	}
+	data := map[string]interface{}{
+		"pending_telegram_chat_id": telegramChatId,
+		"telegram_code_hash":       codeHash,
+		"telegram_code_attempts":   0,
+		"telegram_code_sent_at":    time.Now(),
+		"telegram_code_expires_at": time.Now().Add(time.Second * TELEGRAM_CODE_EXPIRY_SECOND),
+	}
+	if err = ctl.model.SaveUserProfile(userData.Uuid, data); err != nil {
+		ctl.failJSONWithVagueError(c, "SendTelegramCode", err)
+		return
+	}
+
+	ctl.sender.Send(telegramChatId, fmt.Sprintf("fomobot 帳號 %s 的驗證碼: %s, %d 分鐘內有效", user.Username, code, TELEGRAM_CODE_EXPIRY_SECOND/60))
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (ctl *Controller) VerifyTelegramCode(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	profile, err := ctl.getUserProfile(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "VerifyTelegramCode", err)
+		return
+	}
+	if !profile.HasPendingTelegramChatId() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "驗證碼已過期, 請重新發送"})
+		return
+	}
+
+	// NOTE count the attempt before comparing, so that concurrent requests can't exceed the limit
+	allowed, err := ctl.model.IncrTelegramCodeAttempts(userData.Uuid, TELEGRAM_CODE_MAX_ATTEMPTS)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "VerifyTelegramCode", err)
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "驗證失敗次數過多, 請重新發送"})
+		return
+	}
+	codeHash, err := ctl.hashTelegramCode(userData.Uuid, profile.PendingTelegramChatId, c.PostForm("code"))
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "VerifyTelegramCode", err)
+		return
+	}
+	if !hmac.Equal([]byte(codeHash), []byte(profile.TelegramCodeHash)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "驗證碼錯誤"})
+		return
+	}
+
+	user, err := ctl.db.GetUserByUuid(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "VerifyTelegramCode", err)
+		return
+	}
+	data := map[string]interface{}{
+		"telegram_chat_id": profile.PendingTelegramChatId,
+	}
+	if _, err = ctl.db.UpdateUser(userData.Uuid, data); err != nil {
+		ctl.failJSONWithVagueError(c, "VerifyTelegramCode", err)
+		return
+	}
+	data = map[string]interface{}{
+		"pending_telegram_chat_id": 0,
+		"telegram_code_hash":       "",
+		"telegram_code_expires_at": nil,
+	}
+	if err = ctl.model.SaveUserProfile(userData.Uuid, data); err != nil {
+		ctl.log.Println("VerifyTelegramCode err:", err)
+	}
+	ctl.recordAudit(c, userData.Uuid, model.AUDIT_ACTION_TELEGRAM_CHAT_ID_UPDATE, map[string]interface{}{
+		"from": user.TelegramChatId,
+		"to":   profile.PendingTelegramChatId,
+	})
+
+	// Let the previous chat know, in case it's not changed by the user
+	if user.TelegramChatId != 0 {
+		ctl.sender.Send(user.TelegramChatId, fmt.Sprintf("fomobot 帳號 %s 的通知已改為傳送到其他 Telegram. 若非本人操作, 請留意帳號安全", user.Username))
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// getUserProfile returns the default profile if the user hasn't set it
+func (ctl *Controller) getUserProfile(userUuid string) (*model.UserProfile, error) {
+	profile, err := ctl.model.GetUserProfileByUser(userUuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.NewUserProfile(userUuid), nil
+	}
+	if err != nil {
+		return model.NewUserProfile(userUuid), err
+	}
+	return profile, nil
+}
+
+// getUserLocation returns the timezone of the user's profile for the times shown on the pages, the server timezone if
+// it fails
+func (ctl *Controller) getUserLocation(userUuid string) *time.Location {
+	profile, err := ctl.getUserProfile(userUuid)
+	if err != nil {
+		ctl.log.Println("getUserLocation err:", err)
+	}
+	return profile.Location()
+}
+
+// recordAudit records the change made by the current user, it's fine to carry on if it fails
+func (ctl *Controller) recordAudit(c *gin.Context, userUuid string, action string, detail map[string]interface{}) {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > SESSION_USER_AGENT_MAX_LENGTH {
+		userAgent = userAgent[:SESSION_USER_AGENT_MAX_LENGTH]
+	}
+	l := model.UserAuditLog{
+		UserUuid:  userUuid,
+		ActorUuid: ctl.getUserData(c).Uuid,
+		Action:    action,
+		Detail:    detail,
+		Ip:        c.ClientIP(),
+		UserAgent: userAgent,
+	}
+	if _, _, err := ctl.model.CreateUserAuditLog(l); err != nil {
+		ctl.log.Printf("[ERROR] failed to record audit '%s' of '%s', err: %v", action, userUuid, err)
+	}
+}
+
+// generateTelegramCode returns 6 digits
+func generateTelegramCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashTelegramCode binds the code to the user and the chat id
+func (ctl *Controller) hashTelegramCode(userUuid string, telegramChatId int64, code string) (string, error) {
+	hash, err := ctl.getSignatureHash([]byte(fmt.Sprintf("telegram-%s-%d-%s", userUuid, telegramChatId, strings.TrimSpace(code))))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash), nil
+}
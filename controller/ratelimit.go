@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/util/ratelimit"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Login, per minute
+const (
+	LOGIN_RATE_PER_IP       = 10
+	LOGIN_RATE_PER_USERNAME = 5
+)
+
+// One-time password sent via telegram, per minute
+const (
+	OTP_RATE_PER_IP        = 5
+	OTP_RATE_PER_USERNAME  = 1
+	OTP_BURST_PER_USERNAME = 3
+)
+
+// Lock the account after too many failures
+const (
+	LOGIN_MAX_FAILURES          = 5
+	LOGIN_FAILURE_WINDOW_MINUTE = 15
+	LOGIN_LOCKOUT_MINUTE        = 15
+)
+
+type rateLimit struct {
+	ip       ratelimit.Limiter
+	username ratelimit.Limiter
+}
+
+func newRateLimit(ipRate int, usernameRate int, usernameBurst int) *rateLimit {
+	return &rateLimit{
+		ip:       ratelimit.NewMemoryLimiter(float64(ipRate)/60, ipRate),
+		username: ratelimit.NewMemoryLimiter(float64(usernameRate)/60, usernameBurst),
+	}
+}
+
+func newLoginLockout() ratelimit.Lockout {
+	return ratelimit.NewMemoryLockout(LOGIN_MAX_FAILURES, time.Minute*LOGIN_FAILURE_WINDOW_MINUTE, time.Minute*LOGIN_LOCKOUT_MINUTE)
+}
+
+// LoginRateLimit is the middleware of LoginAPI
+func (ctl *Controller) LoginRateLimit(c *gin.Context) {
+	if !ctl.allowRequest(c, ctl.loginRateLimit) {
+		ctl.redirectToLoginPage(c, "/login?err=too_many_requests")
+		return
+	}
+	c.Next()
+}
+
+// OTPRateLimit is the middleware of OTP, which also prevents the user's telegram from being spammed
+func (ctl *Controller) OTPRateLimit(c *gin.Context) {
+	if !ctl.allowRequest(c, ctl.otpRateLimit) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "請求過於頻繁, 請稍後再試"})
+		return
+	}
+	c.Next()
+}
+
+// allowRequest checks both the IP and the username, it fails open if the store isn't available
+func (ctl *Controller) allowRequest(c *gin.Context, l *rateLimit) bool {
+	if !ctl.allowKey(c, l.ip, "ip:"+c.ClientIP()) {
+		return false
+	}
+	if username := strings.ToLower(strings.TrimSpace(c.PostForm("username"))); username != "" {
+		return ctl.allowKey(c, l.username, "username:"+username)
+	}
+	return true
+}
+
+func (ctl *Controller) allowKey(c *gin.Context, limiter ratelimit.Limiter, key string) bool {
+	allowed, err := limiter.Allow(key)
+	if err != nil {
+		ctl.log.Println("allowKey err:", err)
+		return true
+	}
+	if !allowed {
+		ctl.log.Printf("[WARN] %s %s is rate limited", c.FullPath(), key)
+	}
+	return allowed
+}
+
+// isLoginLocked returns true if the account is locked by too many failures
+func (ctl *Controller) isLoginLocked(username string) bool {
+	lockedUntil, err := ctl.loginLockout.LockedUntil(loginLockoutKey(username))
+	if err != nil {
+		ctl.log.Println("isLoginLocked err:", err)
+		return false
+	}
+	return !lockedUntil.IsZero()
+}
+
+// recordLoginFailure notifies the user when the account becomes locked
+func (ctl *Controller) recordLoginFailure(username string, telegramChatId int64) {
+	locked, err := ctl.loginLockout.Fail(loginLockoutKey(username))
+	if err != nil {
+		ctl.log.Println("recordLoginFailure err:", err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	ctl.log.Printf("[WARN] user '%s' is locked for %d minutes", username, LOGIN_LOCKOUT_MINUTE)
+	if telegramChatId != 0 {
+		ctl.sender.Send(telegramChatId, fmt.Sprintf("登入失敗次數過多, 帳號已暫時鎖定 %d 分鐘. 若非本人操作, 請留意帳號安全", LOGIN_LOCKOUT_MINUTE))
+	}
+}
+
+func (ctl *Controller) resetLoginFailures(username string) {
+	if err := ctl.loginLockout.Reset(loginLockoutKey(username)); err != nil {
+		ctl.log.Println("resetLoginFailures err:", err)
+	}
+}
+
+func loginLockoutKey(username string) string {
+	return "login:" + strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles, stored in users.role
+const (
+	ROLE_TRADER = 0 // default, also applied to the roles which aren't listed here for backward compatibility
+	ROLE_VIEWER = 10
+	ROLE_ADMIN  = 99
+)
+
+// Permissions
+const (
+	PERM_STRATEGY_VIEW  = "strategy.view"
+	PERM_STRATEGY_EDIT  = "strategy.edit"  // create, update and delete strategies
+	PERM_STRATEGY_TRADE = "strategy.trade" // enable, disable and reset strategies
+	PERM_STRATEGY_CLOSE = "strategy.close"
+	PERM_APIKEY_MANAGE  = "apikey.manage" // exchange API keys
+	PERM_ENGINE_VIEW    = "engine.view"
+	PERM_USER_MANAGE    = "user.manage"
+)
+
+var rolePermissions = map[int64][]string{
+	ROLE_VIEWER: {
+		PERM_STRATEGY_VIEW,
+	},
+	ROLE_TRADER: {
+		PERM_STRATEGY_VIEW,
+		PERM_STRATEGY_EDIT,
+		PERM_STRATEGY_TRADE,
+		PERM_STRATEGY_CLOSE,
+		PERM_APIKEY_MANAGE,
+	},
+	ROLE_ADMIN: {
+		PERM_STRATEGY_VIEW,
+		PERM_STRATEGY_EDIT,
+		PERM_STRATEGY_TRADE,
+		PERM_STRATEGY_CLOSE,
+		PERM_APIKEY_MANAGE,
+		PERM_ENGINE_VIEW,
+		PERM_USER_MANAGE,
+	},
+}
+
+// for template, in the order shown in the role selector
+var roleNames = []struct {
+	Role int64
+	Name string
+}{
+	{ROLE_VIEWER, "viewer"},
+	{ROLE_TRADER, "trader"},
+	{ROLE_ADMIN, "admin"},
+}
+
+// Require authenticates the request and checks the permission of the role,
+// the role is re-read from DB so that a stale or tampered role in the session can't be used
+func (ctl *Controller) Require(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isAPI := strings.HasPrefix(c.FullPath(), "/api/")
+		if isAPI {
+			if !ctl.apiAuthCheck(c) {
+				return
+			}
+		} else if !ctl.tokenAuthCheck(c) {
+			return
+		}
+
+		// NOTE the role is already read from DB by checkApiToken
+		userData := ctl.getUserData(c)
+		if userData.ApiToken == nil {
+			user, err := ctl.db.GetUserByUuid(userData.Uuid)
+			if err != nil {
+				ctl.log.Printf("[ERROR] failed to get user by '%s', err: %v", userData.Uuid, err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+				return
+			}
+			userData.Role = user.Role
+		}
+
+		if !HasPermission(userData.Role, permission) {
+			ctl.log.Printf("[WARN] user '%s' (role %d) doesn't have permission '%s'", userData.Uuid, userData.Role, permission)
+			if isAPI {
+				ctl.failAPI(c, http.StatusForbidden, API_ERR_FORBIDDEN, "Permission denied")
+			} else {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			}
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasPermission is also used by templates as `can`, e.g. {{ if can .role "engine.view" }}
+func HasPermission(role int64, permission string) bool {
+	for _, p := range getRolePermissions(role) {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func getRolePermissions(role int64) []string {
+	if permissions, ok := rolePermissions[role]; ok {
+		return permissions
+	}
+	return rolePermissions[ROLE_TRADER]
+}
+
+func isValidRole(role int64) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// RewrapEncryptedData re-encrypts the stored secrets by the current key of AES_KEYRING, run it after rotating the key
+// and remove the old key from the keyring once it's done, e.g. `./crypto-trading-bot-api rewrap_keys`
+//
+// The rows which fail are logged and skipped, an error is returned at the end so that the old key isn't removed while
+// it's still needed. NOTE users.exchange_api_key is only rewrapped if AES_ENGINE_VERSIONED_FORMAT is enabled, i.e.
+// engine can decrypt it, otherwise it stays encrypted by AES_PRIVATE_KEY and an error is returned as well
+func (ctl *Controller) RewrapEncryptedData() error {
+	ctl.log.Printf("[INFO] rewrapping encrypted data by key '%s'", ctl.keyring.CurrentId())
+
+	// Exchange credentials
+	credentials, _, err := ctl.model.GetExchangeCredentials()
+	if err != nil {
+		return err
+	}
+	var count, failed int
+	for _, ec := range credentials {
+		// Paper accounts don't have API key
+		if ec.EncryptedData == "" {
+			continue
+		}
+		rewrapped, ok, err := ctl.rewrapWithAES(ec.EncryptedData)
+		if err != nil {
+			ctl.log.Printf("[ERROR] failed to rewrap credential '%s', err: %v", ec.Uuid, err)
+			failed++
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if _, err = ctl.model.UpdateExchangeCredential(ec.Uuid, map[string]interface{}{"encrypted_data": rewrapped}); err != nil {
+			return err
+		}
+		count++
+	}
+	ctl.log.Printf("[INFO] %d of %d exchange credentials are rewrapped", count, len(credentials))
+
+	// TOTP secrets
+	settings, _, err := ctl.model.GetUserAuthSettingsWithTotpSecret()
+	if err != nil {
+		return err
+	}
+	count = 0
+	for _, s := range settings {
+		rewrapped, ok, err := ctl.rewrapWithAES(s.TotpSecret)
+		if err != nil {
+			ctl.log.Printf("[ERROR] failed to rewrap TOTP secret of '%s', err: %v", s.UserUuid, err)
+			failed++
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err = ctl.model.SaveUserAuthSetting(s.UserUuid, map[string]interface{}{"totp_secret": rewrapped}); err != nil {
+			return err
+		}
+		count++
+	}
+	ctl.log.Printf("[INFO] %d of %d TOTP secrets are rewrapped", count, len(settings))
+
+	// API keys read by engine
+	users, _, err := ctl.model.GetUsers()
+	if err != nil {
+		return err
+	}
+	if !viper.GetBool("AES_ENGINE_VERSIONED_FORMAT") {
+		var legacy int
+		for _, u := range users {
+			if u.ExchangeApiKey != "" {
+				legacy++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d secrets failed to be rewrapped", failed)
+		}
+		if legacy > 0 {
+			return fmt.Errorf("%d users' API keys are still encrypted by AES_PRIVATE_KEY for engine, AES_PRIVATE_KEY can't be rotated until AES_ENGINE_VERSIONED_FORMAT is enabled", legacy)
+		}
+		return nil
+	}
+	count = 0
+	for _, u := range users {
+		if u.ExchangeApiKey == "" {
+			continue
+		}
+		rewrapped, ok, err := ctl.rewrapWithAES(u.ExchangeApiKey)
+		if err != nil {
+			ctl.log.Printf("[ERROR] failed to rewrap API key of '%s', err: %v", u.Uuid, err)
+			failed++
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if _, err = ctl.db.UpdateUser(u.Uuid, map[string]interface{}{"exchange_api_key": rewrapped}); err != nil {
+			return err
+		}
+		count++
+	}
+	ctl.log.Printf("[INFO] %d of %d users' API keys are rewrapped", count, len(users))
+	if failed > 0 {
+		return fmt.Errorf("%d secrets failed to be rewrapped", failed)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// NOTE avoid writing DB on every request, last-seen is only updated once in a while
+	SESSION_TOUCH_INTERVAL_SECOND = 60
+
+	// Max length of user_sessions.user_agent
+	SESSION_USER_AGENT_MAX_LENGTH = 255
+)
+
+// for template
+type UserSessionTmpl struct {
+	Uuid       string
+	Ip         string
+	UserAgent  string
+	Current    bool
+	LastSeenAt string
+	CreatedAt  string
+}
+
+func (ctl *Controller) ListSessions(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var errMsg string
+	sessions, _, err := ctl.model.GetActiveUserSessionsByUser(userData.Uuid)
+	if err != nil {
+		ctl.log.Println("ListSessions err:", err)
+		errMsg = "Internal error"
+	}
+
+	loc := ctl.getUserLocation(userData.Uuid)
+	var sessionTmpls []UserSessionTmpl
+	for _, s := range sessions {
+		sessionTmpls = append(sessionTmpls, UserSessionTmpl{
+			Uuid:       s.Uuid,
+			Ip:         s.Ip,
+			UserAgent:  s.UserAgent,
+			Current:    s.Uuid == userData.SessionId,
+			LastSeenAt: s.LastSeenAt.In(loc).Format("2006-01-02 15:04:05"),
+			CreatedAt:  s.CreatedAt.In(loc).Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	c.HTML(http.StatusOK, "sessions.html", gin.H{
+		"loggedIn": true,
+		"role":     userData.Role,
+		"error":    errMsg,
+		"sessions": sessionTmpls,
+	})
+}
+
+func (ctl *Controller) RevokeSession(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	count, err := ctl.model.RevokeUserSessionByUser(c.Param("uuid"), userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "RevokeSession", err)
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// RevokeOtherSessions logs out all the devices except the current one
+func (ctl *Controller) RevokeOtherSessions(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	if _, err := ctl.model.RevokeUserSessionsByUser(userData.Uuid, userData.SessionId); err != nil {
+		ctl.failJSONWithVagueError(c, "RevokeOtherSessions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// AdminRevokeSessions revokes all the sessions of the user, e.g. the account is compromised
+func (ctl *Controller) AdminRevokeSessions(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	user, err := ctl.db.GetUserByUsername(c.PostForm("username"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用戶不存在"})
+		return
+	}
+	count, err := ctl.model.RevokeUserSessionsByUser(user.Uuid, "")
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "AdminRevokeSessions", err)
+		return
+	}
+	if err = ctl.model.IncrUserSessionVersion(user.Uuid); err != nil {
+		ctl.failJSONWithVagueError(c, "AdminRevokeSessions", err)
+		return
+	}
+	ctl.log.Printf("[INFO] %d sessions of user '%s' are revoked by '%s'", count, user.Uuid, userData.Uuid)
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+// createUserSession records the new login session and returns its uuid, which is stored in the cookie
+func (ctl *Controller) createUserSession(c *gin.Context, userUuid string, expiresAt time.Time) (string, error) {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > SESSION_USER_AGENT_MAX_LENGTH {
+		userAgent = userAgent[:SESSION_USER_AGENT_MAX_LENGTH]
+	}
+	s := model.UserSession{
+		Uuid:       uuid.New().String(),
+		UserUuid:   userUuid,
+		Ip:         c.ClientIP(),
+		UserAgent:  userAgent,
+		LastSeenAt: time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+	if _, _, err := ctl.model.CreateUserSession(s); err != nil {
+		return "", err
+	}
+	return s.Uuid, nil
+}
+
+// checkUserSession returns the error type if the session has been revoked, otherwise empty string
+func (ctl *Controller) checkUserSession(c *gin.Context, sessionId string, userUuid string) string {
+	s, err := ctl.model.GetUserSessionByUuid(sessionId)
+	if err != nil {
+		return "please_login"
+	}
+	if s.UserUuid != userUuid {
+		return "please_login"
+	}
+	if !s.IsActive() {
+		return "session_revoked"
+	}
+
+	// Record last-seen, it's fine to carry on if it fails
+	if time.Since(s.LastSeenAt) > time.Second*SESSION_TOUCH_INTERVAL_SECOND {
+		data := map[string]interface{}{
+			"ip":           c.ClientIP(),
+			"last_seen_at": time.Now(),
+		}
+		if _, err := ctl.model.UpdateUserSession(s.Uuid, data); err != nil {
+			ctl.log.Println("checkUserSession err:", err)
+		}
+	}
+	return ""
+}
+
+// revokeOtherSessions is called after sensitive changes, only the current session stays logged in
+func (ctl *Controller) revokeOtherSessions(c *gin.Context, caller string) {
+	userData := ctl.getUserData(c)
+	if _, err := ctl.model.RevokeUserSessionsByUser(userData.Uuid, userData.SessionId); err != nil {
+		ctl.log.Printf("[ERROR] %s failed to revoke sessions, err: %v", caller, err)
+	}
+}
@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// How margin of the form is given
+	MARGIN_FIXED        = "fixed"        // margin as it is, the default
+	MARGIN_RISK_PERCENT = "risk_percent" // risk is the percent of the collateral lost at the stop-loss
+	MARGIN_RISK_AMOUNT  = "risk_amount"  // risk is the amount lost at the stop-loss
+)
+
+// for API response, prices are strings as APIStrategy
+type APIMarginSizing struct {
+	Margin          string `json:"margin"`
+	RiskAmount      string `json:"risk_amount"`
+	EntryPrice      string `json:"entry_price"`
+	StopLossPrice   string `json:"stop_loss_price"`
+	AvailableMargin string `json:"available_margin"`
+	Warning         string `json:"warning,omitempty"`
+}
+
+// CalculateMargin computes margin by the risk of the form for the new and edit pages, without saving anything
+func (ctl *Controller) CalculateMargin(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userCookie := ctl.getUserData(c)
+
+	// Side and symbol are of the strategy for the edit page, or of the form for the new page
+	var symbol string
+	var side int64
+	var newEx func() (exchange.Exchanger, error)
+	if uuid := c.PostForm("strategy_uuid"); uuid != "" {
+		strategy, err := ctl.db.GetContractStrategyByUuidByUser(uuid, userCookie.Uuid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
+			return
+		}
+		symbol, side = strategy.Symbol, strategy.Side
+		newEx = func() (exchange.Exchanger, error) {
+			return ctl.newExchangeByUser(userCookie.Uuid, uuid)
+		}
+	} else {
+		credential, err := ctl.validateStrategyCredential(userCookie.Uuid, c.PostForm("credential_uuid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		symbol = c.PostForm("symbol")
+		if err = ctl.validateSymbol(strategyExchange(credential), symbol); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		side, err = strconv.ParseInt(c.PostForm("side"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "side is invalid"})
+			return
+		}
+		newEx = ctl.newStrategyExchange(userCookie.Uuid, c.PostForm)
+	}
+
+	contractParams, err := ctl.processContractParams(c.PostForm("entry_type"), c.PostForm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ex, err := newEx()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sizing, err := ctl.calculateMargin(ex, order.Side(side), symbol, contractParams, c.PostForm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sizing})
+}
+
+// newStrategyExchange returns the exchange of credential_uuid of the form for creating strategies
+func (ctl *Controller) newStrategyExchange(userUuid string, form formGetter) func() (exchange.Exchanger, error) {
+	return func() (exchange.Exchanger, error) {
+		credential, err := ctl.validateStrategyCredential(userUuid, form("credential_uuid"))
+		if err != nil {
+			return nil, err
+		}
+		if credential != nil {
+			return ctl.newExchangeWithCredential(credential)
+		}
+		return ctl.newExchangeByUser(userUuid, "")
+	}
+}
+
+// processMargin returns margin of the form, which is computed by the risk unless margin_mode is fixed. It's checked
+// against the available margin by validatePreTrade
+func (ctl *Controller) processMargin(form formGetter, side order.Side, symbol string, contractParams map[string]interface{}, newEx func() (exchange.Exchanger, error)) (margin decimal.Decimal, err error) {
+	switch form("margin_mode") {
+	case "", MARGIN_FIXED:
+		margin, err = decimal.NewFromString(form("margin"))
+		if err != nil {
+			err = errors.New("margin is invalid")
+		}
+		return
+	case MARGIN_RISK_PERCENT, MARGIN_RISK_AMOUNT:
+	default:
+		err = errors.New("margin_mode is invalid")
+		return
+	}
+
+	ex, err := newEx()
+	if err != nil {
+		return
+	}
+	sizing, err := ctl.calculateMargin(ex, side, symbol, contractParams, form)
+	if err != nil {
+		return
+	}
+	return decimal.RequireFromString(sizing.Margin), nil
+}
+
+// calculateMargin computes margin so that the position loses the risk at the stop-loss, i.e. margin = risk / (the
+// distance from the entry price to the stop-loss price in percent)
+func (ctl *Controller) calculateMargin(ex exchange.Exchanger, side order.Side, symbol string, contractParams map[string]interface{}, form formGetter) (*APIMarginSizing, error) {
+	risk, err := decimal.NewFromString(form("risk"))
+	if err != nil || !risk.IsPositive() {
+		return nil, errors.New("risk is invalid")
+	}
+
+	ct, err := contract.NewContract(side, contractParams)
+	if err != nil {
+		return nil, err
+	}
+	if ct.StopLossOrder == nil {
+		return nil, errors.New("以風險計算保證金需設定停損")
+	}
+
+	accountInfo, err := ex.GetAccountInfo()
+	if err != nil {
+		ctl.log.Println("[ERROR] calculateMargin - failed to get account info, err:", err)
+		return nil, errors.New("交易所 API server 無回應或 API Key 已失效")
+	}
+	collateral := accountInfo["collateral"].(decimal.Decimal)
+	availableMargin := accountInfo["free_collateral"].(decimal.Decimal).Mul(accountInfo["leverage"].(decimal.Decimal))
+
+	riskAmount := risk
+	if form("margin_mode") == MARGIN_RISK_PERCENT {
+		if risk.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+			return nil, errors.New("risk is invalid")
+		}
+		riskAmount = collateral.Mul(risk).Div(decimal.NewFromInt(100))
+	}
+
+	// Market strategies enter at the market price, the trendline is at the price of now
+	var entryPrice decimal.Decimal
+	if strategyEntryType(contractParams) == ENTRY_MARKET {
+		entryPrice, err = ex.GetMarketPrice(symbol)
+		if err != nil {
+			ctl.log.Println("[ERROR] calculateMargin - failed to get market price, err:", err)
+			return nil, errors.New("交易所 API server 無回應")
+		}
+	} else {
+		entryPrice = ct.EntryOrder.GetTrigger().GetPrice(time.Now())
+	}
+	if !entryPrice.IsPositive() {
+		return nil, errors.New("無法取得開倉價")
+	}
+
+	// Trendline stop-loss is loss_tolerance_percent away from the entry
+	var stopLossPrice, distance decimal.Decimal
+	if ct.EntryType == order.ENTRY_TRENDLINE {
+		distance = decimal.NewFromFloat(ct.StopLossOrder.(*order.StopLoss).LossTolerancePercent)
+		stopLossPrice = entryPrice.Mul(decimal.NewFromInt(1).Sub(distance))
+		if side == order.SHORT {
+			stopLossPrice = entryPrice.Mul(decimal.NewFromInt(1).Add(distance))
+		}
+	} else {
+		stopLossPrice = ct.StopLossOrder.GetTrigger().GetPrice(time.Now())
+		if isBetterPrice(side, stopLossPrice, entryPrice) || stopLossPrice.Equal(entryPrice) {
+			return nil, errors.New("停損價需在開倉價的虧損方向")
+		}
+		distance = entryPrice.Sub(stopLossPrice).Abs().Div(entryPrice)
+	}
+	if !distance.IsPositive() {
+		return nil, errors.New("停損價需在開倉價的虧損方向")
+	}
+
+	margin := riskAmount.Div(distance).Round(2)
+	sizing := &APIMarginSizing{
+		Margin:          margin.String(),
+		RiskAmount:      riskAmount.StringFixed(2),
+		EntryPrice:      entryPrice.String(),
+		StopLossPrice:   stopLossPrice.Round(8).String(),
+		AvailableMargin: availableMargin.StringFixed(1),
+	}
+	if margin.GreaterThan(availableMargin) {
+		sizing.Warning = fmt.Sprintf("保證金 %s 超過總可用餘額 %s", margin.String(), availableMargin.StringFixed(1))
+	}
+	return sizing, nil
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"bytes"
+	"crypto-trading-bot-api/model"
 	"crypto-trading-bot-engine/db"
 	"crypto-trading-bot-engine/exchange"
 	"encoding/json"
@@ -16,6 +17,8 @@ import (
 	"crypto-trading-bot-engine/strategy/order"
 	"crypto-trading-bot-engine/strategy/trigger"
 
+	"crypto-trading-bot-api/util/exchangeinfo"
+
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/leekchan/accounting"
@@ -28,6 +31,8 @@ import (
 type StrategyTmpl struct {
 	Uuid           string
 	Exchange       string
+	ExchangeName   string // display name
+	Paper          bool
 	Symbol         string
 	SymbolPart1    string
 	SymbolPart2    string
@@ -42,6 +47,33 @@ type StrategyTmpl struct {
 	TakeProfit     string
 	StopLoss       string
 	Comment        string
+
+	// Not empty if the API key of the strategy is broken or not verified for a while
+	CredentialWarning string
+}
+
+// for template
+type SymbolGroupTmpl struct {
+	Exchange     string
+	ExchangeName string
+	Symbols      []string
+}
+
+const (
+	// Market strategies are saved as limit strategies whose entry is triggered by any price (>= 0) with
+	// `market_entry` set, so that the engine opens the position at the first price after enabled and installs the
+	// stop-loss as the limit strategies
+	ENTRY_MARKET       = "market"
+	MARKET_ENTRY_PARAM = "market_entry"
+)
+
+// strategyEntryType returns the entry type of the params, including ENTRY_MARKET which isn't known by the engine
+func strategyEntryType(params map[string]interface{}) string {
+	if market, _ := params[MARKET_ENTRY_PARAM].(bool); market {
+		return ENTRY_MARKET
+	}
+	entryType, _ := params["entry_type"].(string)
+	return entryType
 }
 
 func (ctl *Controller) ListStrategies(c *gin.Context) {
@@ -57,9 +89,9 @@ func (ctl *Controller) ListStrategies(c *gin.Context) {
 	userCookie := ctl.getUserData(c)
 
 	// Get exchange account info
-	accountInfo, err := ctl.getExchangeAccountInfo(c)
+	accountInfo, err := ctl.getExchangeAccountInfo(c, "")
 	if err != nil {
-		errMsg = fmt.Sprintf("%s API server 無回應或 API Key 已失效", viper.GetString("DEFAULT_EXCHANGE"))
+		errMsg = "交易所 API server 無回應或 API Key 已失效"
 	}
 
 	// Get user data
@@ -69,16 +101,23 @@ func (ctl *Controller) ListStrategies(c *gin.Context) {
 		errMsg = "Internal error"
 	}
 
+	// API keys of the strategies, to flag the broken ones
+	credentialByStrategy, err := ctl.getCredentialsByStrategies(userCookie.Uuid, css)
+	if err != nil {
+		ctl.log.Println("strategy controller err: ", err)
+		errMsg = "Internal error"
+	}
+
 	// For money and currency formatting
 	ac := accounting.Accounting{Symbol: "$", Precision: 8}
 
-	symbolMap := make(map[string]bool)
+	symbolMap := make(map[string]map[string]bool)
 	var strategyTmpls []StrategyTmpl
 	for _, cs := range css {
 		var st StrategyTmpl
 
-		// Split symbol into 2 parts
-		symbol := strings.Split(cs.Symbol, "-")
+		// Split symbol into 2 parts, e.g. BTC-PERP or BTCUSDT
+		base, quote := exchangeinfo.SplitSymbol(cs.Exchange, cs.Symbol)
 
 		// (position status: 1) Get entry price if position has been opened
 		var entryPrice decimal.Decimal
@@ -108,10 +147,13 @@ func (ctl *Controller) ListStrategies(c *gin.Context) {
 				errMsg = "Internal error"
 				continue
 			}
-			st.EntryType = contract.EntryType
+			st.EntryType = strategyEntryType(cs.Params)
 
 			// This doesn't matter for position
 			st.BuyPrice = ac.FormatMoneyDecimal(contract.EntryOrder.GetTrigger().GetPrice(time.Now()))
+			if st.EntryType == ENTRY_MARKET {
+				st.BuyPrice = "市價"
+			}
 
 			if contract.StopLossOrder != nil {
 				// If entry_type is trendline, stop-loss trigger will be filled after entry order triggered
@@ -126,31 +168,40 @@ func (ctl *Controller) ListStrategies(c *gin.Context) {
 			}
 		}
 
-		if len(accountInfo) > 0 {
+		// NOTE account info is of the default credential
+		if len(accountInfo) > 0 && (credentialByStrategy[cs.Uuid] == nil || credentialByStrategy[cs.Uuid].IsDefault) {
 			st.Leverage = cs.Margin.Div(accountInfo["collateral"].(decimal.Decimal)).StringFixed(1)
 		}
 
 		st.Uuid = cs.Uuid
 		st.Exchange = cs.Exchange
+		st.ExchangeName = exchangeDisplayName(cs.Exchange)
+		st.Paper = exchangeinfo.IsPaper(cs.Exchange)
 		st.Symbol = cs.Symbol
-		st.SymbolPart1 = symbol[0]
-		st.SymbolPart2 = symbol[1]
+		st.SymbolPart1 = base
+		st.SymbolPart2 = quote
 		st.Side = cs.Side
 		st.Margin = ac.FormatMoneyDecimal(cs.Margin)
 		st.Enabled = cs.Enabled
 		st.PositionStatus = cs.PositionStatus
 		st.EntryPrice = ac.FormatMoneyDecimal(entryPrice)
 		st.Comment = cs.Comment
+		st.CredentialWarning = credentialWarning(credentialByStrategy[cs.Uuid])
 		strategyTmpls = append(strategyTmpls, st)
 
-		// Prepare symbols array for js
-		symbolMap[cs.Symbol] = true
+		// Prepare symbols of each exchange for js
+		if _, ok := symbolMap[cs.Exchange]; !ok {
+			symbolMap[cs.Exchange] = make(map[string]bool)
+		}
+		symbolMap[cs.Exchange][cs.Symbol] = true
 	}
 
-	// Prepare symbols array for js
-	var symbols []string
-	for key, _ := range symbolMap {
-		symbols = append(symbols, key)
+	// Prepare symbols of each exchange for js, e.g. {"FTX": ["BTC-PERP"]}
+	symbols := make(map[string][]string)
+	for exchangeName, m := range symbolMap {
+		for key := range m {
+			symbols[exchangeName] = append(symbols[exchangeName], key)
+		}
 	}
 
 	c.HTML(http.StatusOK, "list_strategies.html", gin.H{
@@ -170,17 +221,25 @@ func (ctl *Controller) NewStrategy(c *gin.Context) {
 
 	var errMsg string
 
-	// Get symbols
-	symbols, _, err := ctl.db.GetEnabledContractSymbols(viper.GetString("DEFAULT_EXCHANGE"))
-	if err != nil {
-		c.HTML(http.StatusOK, "new_trendline_strategy.html", gin.H{"error": "Symbols not found"})
-		return
+	// Get symbols of each exchange, the options are filtered by the exchange of the chosen credential
+	var symbolGroups []SymbolGroupTmpl
+	for _, e := range getEnabledExchanges() {
+		symbols, _, err := ctl.db.GetEnabledContractSymbols(e.Name)
+		if err != nil {
+			c.HTML(http.StatusOK, "new_trendline_strategy.html", gin.H{"error": "Symbols not found"})
+			return
+		}
+		group := SymbolGroupTmpl{Exchange: e.Name, ExchangeName: e.DisplayName}
+		for _, s := range symbols {
+			group.Symbols = append(group.Symbols, s.Name)
+		}
+		symbolGroups = append(symbolGroups, group)
 	}
 
 	var collateral, leverage, totalMargin, availableMargin decimal.Decimal
-	accountInfo, err := ctl.getExchangeAccountInfo(c)
+	accountInfo, err := ctl.getExchangeAccountInfo(c, "")
 	if err != nil {
-		errMsg = fmt.Sprintf("%s API server 無回應或 API Key 已失效", viper.GetString("DEFAULT_EXCHANGE"))
+		errMsg = "交易所 API server 無回應或 API Key 已失效"
 	} else {
 		collateral = accountInfo["collateral"].(decimal.Decimal)
 		leverage = accountInfo["leverage"].(decimal.Decimal)
@@ -188,20 +247,43 @@ func (ctl *Controller) NewStrategy(c *gin.Context) {
 		availableMargin = accountInfo["free_collateral"].(decimal.Decimal).Mul(leverage)
 	}
 
+	credentials, _, err := ctl.model.GetExchangeCredentialsByUser(ctl.getUserData(c).Uuid)
+	if err != nil {
+		ctl.log.Println("NewStrategy err:", err)
+		errMsg = "Internal error"
+	}
+	defaultExchange := viper.GetString("DEFAULT_EXCHANGE")
+	var paperCredentials []model.ExchangeCredential
+	for i, ec := range credentials {
+		if ec.IsDefault {
+			defaultExchange = ec.Exchange
+		}
+		if !ec.IsDefault && ctl.isStrategyCredential(&credentials[i]) {
+			paperCredentials = append(paperCredentials, ec)
+		}
+	}
+
 	newStrategyHtml := "new_trendline_strategy.html"
-	if c.FullPath() == "/strategy/new_limit" {
+	switch c.FullPath() {
+	case "/strategy/new_limit":
 		newStrategyHtml = "new_limit_strategy.html"
+	case "/strategy/new_market":
+		newStrategyHtml = "new_market_strategy.html"
 	}
 
 	c.HTML(http.StatusOK, newStrategyHtml, gin.H{
 		"loggedIn":        true,
 		"role":            ctl.getUserData(c).Role,
 		"error":           errMsg,
-		"symbols":         symbols,
+		"symbolGroups":    symbolGroups,
+		"defaultExchange": defaultExchange,
 		"collateral":      collateral.StringFixed(1),
 		"leverage":        leverage.StringFixed(0),
 		"totalMargin":     totalMargin.StringFixed(1),
 		"availableMargin": availableMargin.StringFixed(1),
+		"credentials":     paperCredentials,
+		"backtestFrom":    time.Now().UTC().AddDate(0, -1, 0).Format(BACKTEST_TIME_LAYOUT),
+		"backtestTo":      time.Now().UTC().Format(BACKTEST_TIME_LAYOUT),
 	})
 }
 
@@ -210,76 +292,23 @@ func (ctl *Controller) CreateStrategy(c *gin.Context) {
 		return
 	}
 
-	// Validate symbols
-	symbol := c.PostForm("symbol")
-	symbolrows, _, err := ctl.db.GetEnabledContractSymbols(viper.GetString("DEFAULT_EXCHANGE"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error: symbols not found"})
-		return
-	}
-	matched := false
-	for _, symbolRow := range symbolrows {
-		if symbolRow.Name == symbol {
-			matched = true
-		}
-	}
-	if !matched {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "symbol is invalid"})
-		return
-	}
-
-	// Validate side
-	sideString := c.PostForm("side")
-	side, err := strconv.ParseInt(sideString, 10, 64)
+	userCookie := ctl.getUserData(c)
+	credential, err := ctl.validateStrategyCredential(userCookie.Uuid, c.PostForm("credential_uuid"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "side is invalid"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Validate margin
-	margin, err := decimal.NewFromString(c.PostForm("margin"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "margin is invalid"})
-		return
-	}
-
-	// Convert params
-	var contractParams map[string]interface{}
-	switch c.PostForm("entry_type") {
-	case "trendline":
-		contractParams, err = ctl.processTrendlineContractParams(c)
-	case "limit":
-		contractParams, err = ctl.processLimitContractParams(c)
-	default:
-		err = errors.New("entry type not supported")
-	}
+	strategy, err := ctl.newContractStrategy(userCookie.Uuid, strategyExchange(credential), c.PostForm)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Validate contract params
-	_, err = contract.NewContract(order.Side(side), contractParams)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err = ctl.validateNewStrategy(userCookie.Uuid, c.PostForm, &strategy); err != nil {
+		respondStrategyError(c, err)
 		return
 	}
 
 	// Create strategy
-	userCookie := ctl.getUserData(c)
-	strategy := db.ContractStrategy{
-		Uuid:                  uuid.New().String(),
-		UserUuid:              userCookie.Uuid,
-		Symbol:                symbol,
-		Margin:                margin,
-		Side:                  side,
-		Params:                contractParams,
-		Enabled:               0,
-		PositionStatus:        0,
-		Exchange:              viper.GetString("DEFAULT_EXCHANGE"),
-		ExchangeOrdersDetails: datatypes.JSONMap{},
-		Comment:               c.PostForm("comment"),
-	}
 	insertId, count, err := ctl.db.CreateContractStrategy(strategy)
 	if err != nil {
 		// Capture `Error 1406: Data too long for column 'comment' at row 1`
@@ -297,6 +326,13 @@ func (ctl *Controller) CreateStrategy(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error"})
 		return
 	}
+	if credential != nil {
+		if err = ctl.model.CreateContractStrategyCredential(strategy.Uuid, credential.Uuid); err != nil {
+			ctl.log.Println("[ERROR] StrategyCreate db err: ", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error"})
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{})
 	return
@@ -323,9 +359,9 @@ func (ctl *Controller) ShowStrategy(c *gin.Context) {
 	ac := accounting.Accounting{Symbol: "$", Precision: 8}
 
 	// Get exchange account info
-	accountInfo, err := ctl.getExchangeAccountInfo(c)
+	accountInfo, err := ctl.getExchangeAccountInfo(c, uuid)
 	if err != nil {
-		errMsg = fmt.Sprintf("%s API server 無回應或 API Key 已失效", viper.GetString("DEFAULT_EXCHANGE"))
+		errMsg = fmt.Sprintf("%s API server 無回應或 API Key 已失效", exchangeDisplayName(strategy.Exchange))
 	}
 
 	var collateral, leverage, totalMargin, availableMargin decimal.Decimal
@@ -356,9 +392,10 @@ func (ctl *Controller) ShowStrategy(c *gin.Context) {
 		ordersDetails = string(b)
 	}
 
+	loc := ctl.getUserLocation(userCookie.Uuid)
 	lastPositionAt := "(未開倉)"
 	if strategy.LastPositionAt.Unix() > 0 {
-		lastPositionAt = strategy.LastPositionAt.Format("2006-01-02 15:04:05")
+		lastPositionAt = strategy.LastPositionAt.In(loc).Format("2006-01-02 15:04:05")
 	}
 
 	comment := "(未填)"
@@ -426,11 +463,11 @@ func (ctl *Controller) ShowStrategy(c *gin.Context) {
 		"comment":         comment,
 		"ordersDetails":   ordersDetails,
 		"lastPositionAt":  lastPositionAt,
-		"createdAt":       strategy.CreatedAt.Format("2006-01-02 15:04:05"),
-		"updatedAt":       strategy.UpdatedAt.Format("2006-01-02 15:04:05"),
+		"createdAt":       strategy.CreatedAt.In(loc).Format("2006-01-02 15:04:05"),
+		"updatedAt":       strategy.UpdatedAt.In(loc).Format("2006-01-02 15:04:05"),
 
 		// contract
-		"entryType": contract.EntryType,
+		"entryType": strategyEntryType(strategy.Params),
 
 		// shared params
 		"slEnabled":  slEnabled,
@@ -441,6 +478,19 @@ func (ctl *Controller) ShowStrategy(c *gin.Context) {
 		"tpPrice":    tpPrice,
 	}
 
+	// API key used by the strategy
+	data["credentialLabel"] = "預設"
+	if credential, err := ctl.getStrategyCredential(uuid, userCookie.Uuid); err != nil {
+		ctl.log.Println("ShowStrategy err:", err)
+	} else if credential != nil {
+		data["credentialLabel"] = credential.Label
+	}
+	data["exchangeName"] = exchangeDisplayName(strategy.Exchange)
+	data["paper"] = exchangeinfo.IsPaper(strategy.Exchange)
+	setTrailingStopTmplData(data, strategy.Params)
+	setBreakEvenTmplData(data, strategy.Params)
+	setTakeProfitLevelsTmplData(data, strategy.Params)
+
 	// trendline params
 	if contract.EntryType == order.ENTRY_TRENDLINE {
 		data["entryPrice1"] = contract.EntryOrder.(*order.Entry).TrendlineTrigger.(*trigger.Line).Price1
@@ -491,15 +541,30 @@ func (ctl *Controller) DeleteStrategy(c *gin.Context) {
 	}
 
 	// Delete data
-	result := ctl.db.GormDB.Delete(&strategy)
-	if result.Error != nil {
+	if err = ctl.deleteContractStrategy(strategy); err != nil {
 		ctl.log.Println("[ERROR] failed to delete strategy, err:", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{})
 }
 
+// deleteContractStrategy deletes the strategy along with the data of the site, e.g. the credential and the trailing
+// stop-loss, for both DeleteStrategy and APIDeleteStrategy
+func (ctl *Controller) deleteContractStrategy(strategy *db.ContractStrategy) error {
+	if result := ctl.db.GormDB.Delete(strategy); result.Error != nil {
+		return result.Error
+	}
+	if err := ctl.model.DeleteContractStrategyCredential(strategy.Uuid); err != nil {
+		ctl.log.Println("[ERROR] failed to delete credential of strategy, err:", err)
+	}
+	if _, err := ctl.model.DeleteTrailingStop(strategy.Uuid); err != nil {
+		ctl.log.Println("[ERROR] failed to delete trailing stop of strategy, err:", err)
+	}
+	return nil
+}
+
 func (ctl *Controller) EditLimit(c *gin.Context) {
 	if !ctl.tokenAuthCheck(c) {
 		return
@@ -528,9 +593,9 @@ func (ctl *Controller) EditLimit(c *gin.Context) {
 
 	var errMsg string
 	var collateral, leverage, totalMargin, availableMargin decimal.Decimal
-	accountInfo, err := ctl.getExchangeAccountInfo(c)
+	accountInfo, err := ctl.getExchangeAccountInfo(c, uuid)
 	if err != nil {
-		errMsg = fmt.Sprintf("%s API server 無回應或 API Key 已失效", viper.GetString("DEFAULT_EXCHANGE"))
+		errMsg = fmt.Sprintf("%s API server 無回應或 API Key 已失效", exchangeDisplayName(strategy.Exchange))
 	} else {
 		collateral = accountInfo["collateral"].(decimal.Decimal)
 		leverage = accountInfo["leverage"].(decimal.Decimal)
@@ -564,7 +629,13 @@ func (ctl *Controller) EditLimit(c *gin.Context) {
 		}
 	}
 
-	c.HTML(http.StatusOK, "edit_limit_strategy.html", gin.H{
+	// Market strategies are limit strategies of the engine, see ENTRY_MARKET
+	editStrategyHtml := "edit_limit_strategy.html"
+	if strategyEntryType(strategy.Params) == ENTRY_MARKET {
+		editStrategyHtml = "edit_market_strategy.html"
+	}
+
+	data := gin.H{
 		"loggedIn":        true,
 		"role":            ctl.getUserData(c).Role,
 		"error":           errMsg,
@@ -584,7 +655,12 @@ func (ctl *Controller) EditLimit(c *gin.Context) {
 		"tpEnabled":        tpEnabled,
 		"tpOperator":       tpOperator,
 		"tpPrice":          tpPrice,
-	})
+	}
+	setTrailingStopTmplData(data, strategy.Params)
+	setBreakEvenTmplData(data, strategy.Params)
+	setTakeProfitLevelsTmplData(data, strategy.Params)
+
+	c.HTML(http.StatusOK, editStrategyHtml, data)
 }
 
 func (ctl *Controller) EditTrendline(c *gin.Context) {
@@ -615,9 +691,9 @@ func (ctl *Controller) EditTrendline(c *gin.Context) {
 
 	var errMsg string
 	var collateral, leverage, totalMargin, availableMargin decimal.Decimal
-	accountInfo, err := ctl.getExchangeAccountInfo(c)
+	accountInfo, err := ctl.getExchangeAccountInfo(c, uuid)
 	if err != nil {
-		errMsg = fmt.Sprintf("%s API server 無回應或 API Key 已失效", viper.GetString("DEFAULT_EXCHANGE"))
+		errMsg = fmt.Sprintf("%s API server 無回應或 API Key 已失效", exchangeDisplayName(strategy.Exchange))
 	} else {
 		collateral = accountInfo["collateral"].(decimal.Decimal)
 		leverage = accountInfo["leverage"].(decimal.Decimal)
@@ -649,7 +725,7 @@ func (ctl *Controller) EditTrendline(c *gin.Context) {
 		}
 	}
 
-	c.HTML(http.StatusOK, "edit_trendline_strategy.html", gin.H{
+	data := gin.H{
 		"loggedIn":        true,
 		"role":            ctl.getUserData(c).Role,
 		"error":           errMsg,
@@ -673,7 +749,12 @@ func (ctl *Controller) EditTrendline(c *gin.Context) {
 		"tpEnabled":             tpEnabled,
 		"tpOperator":            tpOperator,
 		"tpPrice":               tpPrice,
-	})
+	}
+	setTrailingStopTmplData(data, strategy.Params)
+	setBreakEvenTmplData(data, strategy.Params)
+	setTakeProfitLevelsTmplData(data, strategy.Params)
+
+	c.HTML(http.StatusOK, "edit_trendline_strategy.html", data)
 }
 
 func (ctl *Controller) UpdateStrategy(c *gin.Context) {
@@ -702,41 +783,18 @@ func (ctl *Controller) UpdateStrategy(c *gin.Context) {
 		return
 	}
 
-	// Validate margin
-	margin, err := decimal.NewFromString(c.PostForm("margin"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "margin is invalid"})
-		return
-	}
-
-	// Convert params
-	var contractParams map[string]interface{}
-	switch c.PostForm("entry_type") {
-	case "trendline":
-		contractParams, err = ctl.processTrendlineContractParams(c)
-	case "limit":
-		contractParams, err = ctl.processLimitContractParams(c)
-	default:
-		err = errors.New("entry type not supported")
-	}
+	// Validate params
+	data, err := ctl.processStrategyUpdate(strategy, c.PostForm("entry_type"), c.PostForm)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Validate contract params
-	_, err = contract.NewContract(order.Side(strategy.Side), contractParams)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err = ctl.validateStrategyUpdate(strategy, data); err != nil {
+		respondStrategyError(c, err)
 		return
 	}
 
 	// Update strategy
-	data := map[string]interface{}{
-		"margin":  margin,
-		"params":  datatypes.JSONMap(contractParams),
-		"comment": c.PostForm("comment"),
-	}
 	if _, err := ctl.db.UpdateContractStrategy(uuid, data); err != nil {
 		ctl.log.Println("failed to update db, err:", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error"})
@@ -802,7 +860,7 @@ func (ctl *Controller) EditTpSl(c *gin.Context) {
 		}
 	}
 
-	c.HTML(http.StatusOK, "edit_strategy_tpsl.html", gin.H{
+	data := gin.H{
 		"loggedIn":      true,
 		"role":          ctl.getUserData(c).Role,
 		"error":         errMsg,
@@ -816,7 +874,10 @@ func (ctl *Controller) EditTpSl(c *gin.Context) {
 		"tpTriggerType": tpTriggerType,
 		"tpOperator":    tpOperator,
 		"tpPrice":       tpPrice,
-	})
+	}
+	setTakeProfitLevelsTmplData(data, strategy.Params)
+
+	c.HTML(http.StatusOK, "edit_strategy_tpsl.html", data)
 }
 
 func (ctl *Controller) UpdateTpSl(c *gin.Context) {
@@ -847,37 +908,71 @@ func (ctl *Controller) UpdateTpSl(c *gin.Context) {
 	}
 
 	// New exchange
-	ex, err := ctl.newExchange(c)
+	ex, err := ctl.newExchange(c, uuid)
 	if err != nil {
 		return
 	}
 
+	// Process stop-loss and take-profit
+	if err := ctl.validateTpSl(ex, strategy, c.PostForm); err != nil {
+		respondStrategyError(c, err)
+		return
+	}
+	if err := ctl.applyTpSl(ex, strategy, c.PostForm); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Update DB
+	data := map[string]interface{}{
+		"params":                  strategy.Params,
+		"exchange_orders_details": strategy.ExchangeOrdersDetails,
+		"comment":                 c.PostForm("comment"),
+	}
+	if _, err := ctl.db.UpdateContractStrategy(uuid, data); err != nil {
+		ctl.log.Println("failed to update db, err:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// applyTpSl updates stop-loss and take-profit params, and replaces the stop-loss order if the position has been opened
+func (ctl *Controller) applyTpSl(ex exchange.Exchanger, strategy *db.ContractStrategy, form formGetter) error {
 	// Process stop-loss
 	switch strategy.Params["entry_type"].(string) {
 	case order.ENTRY_LIMIT:
-		if c.PostForm("stop_loss[enabled]") == "1" {
+		if form("stop_loss[enabled]") == "1" {
 			// Validate stop-loss params
 			slTriggerParams := map[string]interface{}{
-				"trigger_type": c.PostForm("stop_loss[trigger_type]"),
-				"operator":     c.PostForm("stop_loss[operator]"),
-				"price":        c.PostForm("stop_loss[price]"),
+				"trigger_type": form("stop_loss[trigger_type]"),
+				"operator":     form("stop_loss[operator]"),
+				"price":        form("stop_loss[price]"),
 			}
 			slTrigger, err := trigger.NewTrigger(slTriggerParams)
 			if err != nil {
 				ctl.log.Println("new stop-loss trigger, err: ", err)
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error"})
-				return
+				return errors.New("Internal error")
 			}
-			strategy.Params["stop_loss_order"] = map[string]interface{}{
+			stopLossOrder := map[string]interface{}{
 				"trigger": slTriggerParams,
 			}
+			// Keep the trailing stop-loss and the break-even rule, they're not on the page
+			if old, ok := strategy.Params["stop_loss_order"].(map[string]interface{}); ok {
+				for _, key := range []string{"trailing", "break_even"} {
+					if old[key] != nil {
+						stopLossOrder[key] = old[key]
+					}
+				}
+			}
+			strategy.Params["stop_loss_order"] = stopLossOrder
 
 			// Update stop-loss order trigger
 			if contract.Status(strategy.PositionStatus) == contract.OPENED {
 				// Cancel open trigger order if exists
 				if err := ctl.cancelStopLossOrder(ex, strategy); err != nil {
-					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-					return
+					return err
 				}
 				// It's ok if key doesn't exist
 				delete(strategy.ExchangeOrdersDetails, "stop_loss_order")
@@ -885,8 +980,7 @@ func (ctl *Controller) UpdateTpSl(c *gin.Context) {
 				// Place stop-loss order
 				orderId, err := ctl.updateStopLossOrder(ex, strategy, slTrigger.GetPrice(time.Now()))
 				if err != nil {
-					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-					return
+					return err
 				}
 				strategy.ExchangeOrdersDetails["stop_loss_order"] = map[string]interface{}{
 					"order_id": float64(orderId),
@@ -899,8 +993,7 @@ func (ctl *Controller) UpdateTpSl(c *gin.Context) {
 			if contract.Status(strategy.PositionStatus) == contract.OPENED {
 				// Cancel open trigger order
 				if err := ctl.cancelStopLossOrder(ex, strategy); err != nil {
-					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-					return
+					return err
 				}
 				delete(strategy.ExchangeOrdersDetails, "stop_loss_order")
 			}
@@ -911,30 +1004,27 @@ func (ctl *Controller) UpdateTpSl(c *gin.Context) {
 		_, ok := strategy.Params["stop_loss_order"]
 		if ok && contract.Status(strategy.PositionStatus) == contract.OPENED {
 			slTriggerParams := map[string]interface{}{
-				"trigger_type": c.PostForm("stop_loss[trigger_type]"),
-				"operator":     c.PostForm("stop_loss[operator]"),
-				"price":        c.PostForm("stop_loss[price]"),
+				"trigger_type": form("stop_loss[trigger_type]"),
+				"operator":     form("stop_loss[operator]"),
+				"price":        form("stop_loss[price]"),
 			}
 			slTrigger, err := trigger.NewTrigger(slTriggerParams)
 			if err != nil {
 				ctl.log.Println("new stop-loss trigger, err: ", err)
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error"})
-				return
+				return errors.New("Internal error")
 			}
 			strategy.Params["stop_loss_order"].(map[string]interface{})["trigger"] = slTriggerParams
 
 			// Cancel open trigger order if exists
 			if err := ctl.cancelStopLossOrder(ex, strategy); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-				return
+				return err
 			}
 			delete(strategy.ExchangeOrdersDetails, "stop_loss_order")
 
 			// Place stop-loss order
 			orderId, err := ctl.updateStopLossOrder(ex, strategy, slTrigger.GetPrice(time.Now()))
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-				return
+				return err
 			}
 			strategy.ExchangeOrdersDetails["stop_loss_order"] = map[string]interface{}{
 				"order_id": float64(orderId),
@@ -943,39 +1033,34 @@ func (ctl *Controller) UpdateTpSl(c *gin.Context) {
 	}
 
 	// Process take-profit
-	if c.PostForm("take_profit[enabled]") == "1" {
+	if form("take_profit[enabled]") == "1" {
 		// Validate take-profit params
 		tpTriggerParams := map[string]interface{}{
-			"trigger_type": c.PostForm("take_profit[trigger_type]"),
-			"operator":     c.PostForm("take_profit[operator]"),
-			"price":        c.PostForm("take_profit[price]"),
+			"trigger_type": form("take_profit[trigger_type]"),
+			"operator":     form("take_profit[operator]"),
+			"price":        form("take_profit[price]"),
 		}
-		_, err = trigger.NewTrigger(tpTriggerParams)
+		_, err := trigger.NewTrigger(tpTriggerParams)
 		if err != nil {
 			ctl.log.Println("new take-profit trigger, err: ", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error"})
-			return
+			return errors.New("Internal error")
 		}
-		strategy.Params["take_profit_order"] = map[string]interface{}{
+		takeProfitOrder := map[string]interface{}{
 			"trigger": tpTriggerParams,
 		}
+		levels, err := processTakeProfitLevels(form)
+		if err != nil {
+			return err
+		}
+		if len(levels) > 0 {
+			takeProfitOrder["levels"] = levels
+		}
+		strategy.Params["take_profit_order"] = takeProfitOrder
 	} else {
 		delete(strategy.Params, "take_profit_order")
 	}
 
-	// Update DB
-	data := map[string]interface{}{
-		"params":                  strategy.Params,
-		"exchange_orders_details": strategy.ExchangeOrdersDetails,
-		"comment":                 c.PostForm("comment"),
-	}
-	if _, err := ctl.db.UpdateContractStrategy(uuid, data); err != nil {
-		ctl.log.Println("failed to update db, err:", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal error"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{})
+	return nil
 }
 
 // NOTE This endpoint isn't ready as there is a bug in the SDK which can't fetch correct price from position
@@ -1007,7 +1092,7 @@ func (ctl *Controller) UpdateOrdersDetails(c *gin.Context) {
 	}
 
 	// New exchange
-	ex, err := ctl.newExchange(c)
+	ex, err := ctl.newExchange(c, uuid)
 	if err != nil {
 		return
 	}
@@ -1038,8 +1123,9 @@ func (ctl *Controller) UpdateOrdersDetails(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{})
 }
 
-func (ctl *Controller) getExchangeAccountInfo(c *gin.Context) (accountInfo map[string]interface{}, err error) {
-	ex, err := ctl.newExchange(c)
+// getExchangeAccountInfo uses the credential chosen by the strategy, or the default one if strategyUuid is empty
+func (ctl *Controller) getExchangeAccountInfo(c *gin.Context, strategyUuid string) (accountInfo map[string]interface{}, err error) {
+	ex, err := ctl.newExchange(c, strategyUuid)
 	if err != nil {
 		return
 	}
@@ -1047,17 +1133,41 @@ func (ctl *Controller) getExchangeAccountInfo(c *gin.Context) (accountInfo map[s
 	// Get account info from exchange
 	accountInfo, err = ex.GetAccountInfo()
 	if err != nil {
-		ctl.log.Printf("failed to get account info from %s, err: %s", viper.GetString("DEFAULT_EXCHANGE"), err.Error())
+		ctl.log.Printf("failed to get account info of strategy '%s', err: %s", strategyUuid, err.Error())
 	}
 	return
 }
 
-func (ctl *Controller) newExchange(c *gin.Context) (ex exchange.Exchanger, err error) {
-	// Get user data
-	userCookie := ctl.getUserData(c)
-	user, err := ctl.db.GetUserByUuid(userCookie.Uuid)
+// newExchange uses the credential chosen by the strategy, or the default one if strategyUuid is empty
+func (ctl *Controller) newExchange(c *gin.Context, strategyUuid string) (ex exchange.Exchanger, err error) {
+	return ctl.newExchangeByUser(ctl.getUserData(c).Uuid, strategyUuid)
+}
+
+// newExchangeByUser is newExchange without the request, e.g. for the background jobs
+func (ctl *Controller) newExchangeByUser(userUuid string, strategyUuid string) (ex exchange.Exchanger, err error) {
+	if strategyUuid != "" {
+		credential, err := ctl.getStrategyCredential(strategyUuid, userUuid)
+		if err != nil {
+			ctl.log.Printf("[ERROR] failed to get credential of strategy '%s', err: %v", strategyUuid, err)
+			return nil, errors.New("Internal error")
+		}
+		if credential != nil {
+			return ctl.newExchangeWithCredential(credential)
+		}
+	}
+
+	// The default credential knows its exchange
+	credential, err := ctl.validateStrategyCredential(userUuid, "")
 	if err != nil {
-		ctl.log.Printf("[ERROR] failed to get user by '%s', err: %v", userCookie.Uuid, err)
+		return
+	}
+	if credential != nil {
+		return ctl.newExchangeWithCredential(credential)
+	}
+
+	user, err := ctl.db.GetUserByUuid(userUuid)
+	if err != nil {
+		ctl.log.Printf("[ERROR] failed to get user by '%s', err: %v", userUuid, err)
 		err = errors.New("用戶不存在")
 		return
 	}
@@ -1068,7 +1178,14 @@ func (ctl *Controller) newExchange(c *gin.Context) (ex exchange.Exchanger, err e
 	}
 
 	// New exchange
-	ex, err = exchange.NewExchange(viper.GetString("DEFAULT_EXCHANGE"), user.ExchangeApiKey)
+	encryptedData, err := ctl.toEngineCiphertext(user.ExchangeApiKey)
+	if err != nil {
+		ctl.log.Printf("[ERROR] failed to decrypt API key of '%s', err: %v", user.Uuid, err)
+		err = errors.New("Internal error")
+		return
+	}
+	// NOTE the API key saved before credentials were introduced is of DEFAULT_EXCHANGE
+	ex, err = exchange.NewExchange(viper.GetString("DEFAULT_EXCHANGE"), encryptedData)
 	if err != nil {
 		ctl.log.Println("[ERROR] failed to new exchange")
 		err = errors.New("API Key 可能已失效, 請確認或重試一次")
@@ -1077,14 +1194,128 @@ func (ctl *Controller) newExchange(c *gin.Context) (ex exchange.Exchanger, err e
 	return
 }
 
-func (ctl *Controller) processLimitContractParams(c *gin.Context) (map[string]interface{}, error) {
+// newContractStrategy validates the form and builds a strategy of the exchange which hasn't been saved yet
+func (ctl *Controller) newContractStrategy(userUuid string, exchangeName string, form formGetter) (strategy db.ContractStrategy, err error) {
+	// Validate symbols
+	symbol := form("symbol")
+	if err = ctl.validateSymbol(exchangeName, symbol); err != nil {
+		return
+	}
+
+	// Validate side
+	side, err := strconv.ParseInt(form("side"), 10, 64)
+	if err != nil {
+		err = errors.New("side is invalid")
+		return
+	}
+
+	// Convert params
+	contractParams, err := ctl.processContractParams(form("entry_type"), form)
+	if err != nil {
+		return
+	}
+
+	// Validate contract params
+	if _, err = contract.NewContract(order.Side(side), contractParams); err != nil {
+		return
+	}
+
+	// Validate margin, or compute it by the risk
+	margin, err := ctl.processMargin(form, order.Side(side), symbol, contractParams, ctl.newStrategyExchange(userUuid, form))
+	if err != nil {
+		return
+	}
+
+	strategy = db.ContractStrategy{
+		Uuid:                  uuid.New().String(),
+		UserUuid:              userUuid,
+		Symbol:                symbol,
+		Margin:                margin,
+		Side:                  side,
+		Params:                contractParams,
+		Enabled:               0,
+		PositionStatus:        0,
+		Exchange:              exchangeName,
+		ExchangeOrdersDetails: datatypes.JSONMap{},
+		Comment:               form("comment"),
+	}
+	return
+}
+
+// processStrategyUpdate validates the form and returns the data to be updated
+func (ctl *Controller) processStrategyUpdate(strategy *db.ContractStrategy, entryType string, form formGetter) (data map[string]interface{}, err error) {
+	// Convert params
+	contractParams, err := ctl.processContractParams(entryType, form)
+	if err != nil {
+		return
+	}
+
+	// Validate contract params
+	if _, err = contract.NewContract(order.Side(strategy.Side), contractParams); err != nil {
+		return
+	}
+
+	// Validate margin, or compute it by the risk
+	newEx := func() (exchange.Exchanger, error) {
+		return ctl.newExchangeByUser(strategy.UserUuid, strategy.Uuid)
+	}
+	margin, err := ctl.processMargin(form, order.Side(strategy.Side), strategy.Symbol, contractParams, newEx)
+	if err != nil {
+		return
+	}
+
+	data = map[string]interface{}{
+		"margin":  margin,
+		"params":  datatypes.JSONMap(contractParams),
+		"comment": form("comment"),
+	}
+	return
+}
+
+// Read form values in the same way for both html forms (c.PostForm) and API requests
+type formGetter func(key string) string
+
+func (ctl *Controller) processContractParams(entryType string, form formGetter) (contractParams map[string]interface{}, err error) {
+	switch entryType {
+	case order.ENTRY_TRENDLINE:
+		contractParams, err = ctl.processTrendlineContractParams(form)
+	case order.ENTRY_LIMIT:
+		contractParams, err = ctl.processLimitContractParams(form)
+	case ENTRY_MARKET:
+		contractParams, err = ctl.processMarketContractParams(form)
+	default:
+		err = errors.New("entry type not supported")
+	}
+	return
+}
+
+// validateSymbol makes sure the symbol is enabled on the exchange
+func (ctl *Controller) validateSymbol(exchangeName string, symbol string) error {
+	if !isEnabledExchange(exchangeinfo.Underlying(exchangeName)) || (exchangeinfo.IsPaper(exchangeName) && ctl.paperPrices == nil) {
+		return errors.New("exchange is not supported")
+	}
+
+	// Paper accounts trade the symbols of the underlying exchange
+	symbolrows, _, err := ctl.db.GetEnabledContractSymbols(exchangeinfo.Underlying(exchangeName))
+	if err != nil {
+		return errors.New("Internal error: symbols not found")
+	}
+	for _, symbolRow := range symbolrows {
+		if symbolRow.Name == symbol {
+			return nil
+		}
+	}
+	return errors.New("symbol is invalid")
+}
+
+func (ctl *Controller) processLimitContractParams(form formGetter) (map[string]interface{}, error) {
 	// Stop-loss or take-profit enabled
-	stopLossEnabled := c.PostForm("stop_loss[enabled]")
-	takeProfitEnabled := c.PostForm("take_profit[enabled]")
+	stopLossEnabled := form("stop_loss[enabled]")
+	takeProfitEnabled := form("take_profit[enabled]")
 
 	// flip_operator_enabled
 	var flipOperatorEnabled bool
-	enabled := c.PostForm("entry[flip_operator_enabled]")
+	enabled := form("entry[flip_operator_enabled]")
 	switch enabled {
 	case "1":
 		flipOperatorEnabled = true
@@ -1096,88 +1327,186 @@ func (ctl *Controller) processLimitContractParams(c *gin.Context) (map[string]in
 
 	// Prepare contract params
 	contractParams := map[string]interface{}{
-		"entry_type": c.PostForm("entry_type"),
+		"entry_type": form("entry_type"),
 		"entry_order": map[string]interface{}{
 			"trigger": map[string]interface{}{
-				"trigger_type": c.PostForm("entry[trigger_type]"),
-				"operator":     c.PostForm("entry[operator]"),
-				"price":        c.PostForm("entry[price]"),
+				"trigger_type": form("entry[trigger_type]"),
+				"operator":     form("entry[operator]"),
+				"price":        form("entry[price]"),
 			},
 			"flip_operator_enabled": flipOperatorEnabled,
 		},
 	}
 	if stopLossEnabled == "1" {
-		contractParams["stop_loss_order"] = map[string]interface{}{
+		stopLossOrder := map[string]interface{}{
 			"trigger": map[string]interface{}{
-				"trigger_type": c.PostForm("stop_loss[trigger_type]"),
-				"operator":     c.PostForm("stop_loss[operator]"),
-				"price":        c.PostForm("stop_loss[price]"),
+				"trigger_type": form("stop_loss[trigger_type]"),
+				"operator":     form("stop_loss[operator]"),
+				"price":        form("stop_loss[price]"),
 			},
 		}
+		trailing, err := processTrailingStopParams(form)
+		if err != nil {
+			return map[string]interface{}{}, err
+		}
+		if trailing != nil {
+			stopLossOrder["trailing"] = trailing
+		}
+		breakEven, err := processBreakEvenParams(form)
+		if err != nil {
+			return map[string]interface{}{}, err
+		}
+		if breakEven != nil {
+			stopLossOrder["break_even"] = breakEven
+		}
+		contractParams["stop_loss_order"] = stopLossOrder
 	}
 	if takeProfitEnabled == "1" {
-		contractParams["take_profit_order"] = map[string]interface{}{
+		takeProfitOrder := map[string]interface{}{
 			"trigger": map[string]interface{}{
-				"trigger_type": c.PostForm("take_profit[trigger_type]"),
-				"operator":     c.PostForm("take_profit[operator]"),
-				"price":        c.PostForm("take_profit[price]"),
+				"trigger_type": form("take_profit[trigger_type]"),
+				"operator":     form("take_profit[operator]"),
+				"price":        form("take_profit[price]"),
 			},
 		}
+		levels, err := processTakeProfitLevels(form)
+		if err != nil {
+			return map[string]interface{}{}, err
+		}
+		if len(levels) > 0 {
+			takeProfitOrder["levels"] = levels
+		}
+		contractParams["take_profit_order"] = takeProfitOrder
+	}
+
+	return contractParams, nil
+}
+
+// processMarketContractParams builds a limit entry triggered by any price, stop-loss and take-profit are the same as
+// the limit strategies
+func (ctl *Controller) processMarketContractParams(form formGetter) (map[string]interface{}, error) {
+	marketForm := func(key string) string {
+		switch key {
+		case "entry_type":
+			return order.ENTRY_LIMIT
+		case "entry[trigger_type]":
+			return "limit"
+		case "entry[operator]":
+			return ">="
+		case "entry[price]":
+			return "0"
+		case "entry[flip_operator_enabled]":
+			return "0"
+		}
+		return form(key)
+	}
+	contractParams, err := ctl.processLimitContractParams(marketForm)
+	if err != nil {
+		return map[string]interface{}{}, err
 	}
+	contractParams[MARKET_ENTRY_PARAM] = true
 
+	// The entry price is unknown, stop-loss and take-profit can only be checked against each other here, and against
+	// the market price when it's enabled
+	if form("stop_loss[enabled]") != "1" || form("take_profit[enabled]") != "1" {
+		return contractParams, nil
+	}
+	slPrice, err := decimal.NewFromString(form("stop_loss[price]"))
+	if err != nil {
+		return map[string]interface{}{}, errors.New("stop_loss price is invalid")
+	}
+	tpPrice, err := decimal.NewFromString(form("take_profit[price]"))
+	if err != nil {
+		return map[string]interface{}{}, errors.New("take_profit price is invalid")
+	}
+	switch {
+	case form("stop_loss[operator]") == form("take_profit[operator]"):
+		return map[string]interface{}{}, errors.New("停損與停利的方向不可相同")
+	case form("stop_loss[operator]") == "<=" && !slPrice.LessThan(tpPrice):
+		return map[string]interface{}{}, errors.New("停損價需低於停利價")
+	case form("stop_loss[operator]") == ">=" && !slPrice.GreaterThan(tpPrice):
+		return map[string]interface{}{}, errors.New("停損價需高於停利價")
+	}
 	return contractParams, nil
 }
 
-func (ctl *Controller) processTrendlineContractParams(c *gin.Context) (map[string]interface{}, error) {
-	params, err := ctl.convertTrendlineContractParams(c)
+func (ctl *Controller) processTrendlineContractParams(form formGetter) (map[string]interface{}, error) {
+	params, err := ctl.convertTrendlineContractParams(form)
 	if err != nil {
 		return map[string]interface{}{}, err
 	}
 
 	// Stop-loss or take-profit enabled
-	stopLossEnabled := c.PostForm("stop_loss[enabled]")
-	takeProfitEnabled := c.PostForm("take_profit[enabled]")
+	stopLossEnabled := form("stop_loss[enabled]")
+	takeProfitEnabled := form("take_profit[enabled]")
 
 	// Prepare contract params
 	contractParams := map[string]interface{}{
-		"entry_type": c.PostForm("entry_type"),
+		"entry_type": form("entry_type"),
 		"entry_order": map[string]interface{}{
 			"trendline_trigger": map[string]interface{}{
-				"trigger_type": c.PostForm("entry[trigger_type]"),
-				"operator":     c.PostForm("entry[operator]"),
+				"trigger_type": form("entry[trigger_type]"),
+				"operator":     form("entry[operator]"),
 				"time_1":       params["time_1"].(time.Time).Format(time.RFC3339),
-				"price_1":      c.PostForm("entry[price_1]"),
+				"price_1":      form("entry[price_1]"),
 				"time_2":       params["time_2"].(time.Time).Format(time.RFC3339),
-				"price_2":      c.PostForm("entry[price_2]"),
+				"price_2":      form("entry[price_2]"),
 			},
 			"trendline_offset_percent": params["trendline_offset_percent"].(float64),
 			"flip_operator_enabled":    params["flip_operator_enabled"].(bool),
 		},
 	}
 	if stopLossEnabled == "1" {
-		contractParams["stop_loss_order"] = map[string]interface{}{
+		stopLossOrder := map[string]interface{}{
 			"loss_tolerance_percent":         params["loss_tolerance_percent"].(float64),
 			"trendline_readjustment_enabled": params["trendline_readjustment_enabled"].(bool),
 		}
+		trailing, err := processTrailingStopParams(form)
+		if err != nil {
+			return map[string]interface{}{}, err
+		}
+		// Both would move the stop-loss order
+		if trailing != nil && params["trendline_readjustment_enabled"].(bool) {
+			return map[string]interface{}{}, errors.New("移動停損不可與趨勢線停損調整同時啟用")
+		}
+		if trailing != nil {
+			stopLossOrder["trailing"] = trailing
+		}
+		breakEven, err := processBreakEvenParams(form)
+		if err != nil {
+			return map[string]interface{}{}, err
+		}
+		if breakEven != nil {
+			stopLossOrder["break_even"] = breakEven
+		}
+		contractParams["stop_loss_order"] = stopLossOrder
 	}
 	if takeProfitEnabled == "1" {
-		contractParams["take_profit_order"] = map[string]interface{}{
+		takeProfitOrder := map[string]interface{}{
 			"trigger": map[string]interface{}{
-				"trigger_type": c.PostForm("take_profit[trigger_type]"),
-				"operator":     c.PostForm("take_profit[operator]"),
-				"price":        c.PostForm("take_profit[price]"),
+				"trigger_type": form("take_profit[trigger_type]"),
+				"operator":     form("take_profit[operator]"),
+				"price":        form("take_profit[price]"),
 			},
 		}
+		levels, err := processTakeProfitLevels(form)
+		if err != nil {
+			return map[string]interface{}{}, err
+		}
+		if len(levels) > 0 {
+			takeProfitOrder["levels"] = levels
+		}
+		contractParams["take_profit_order"] = takeProfitOrder
 	}
 
 	return contractParams, nil
 }
 
-func (ctl *Controller) convertTrendlineContractParams(c *gin.Context) (map[string]interface{}, error) {
+func (ctl *Controller) convertTrendlineContractParams(form formGetter) (map[string]interface{}, error) {
 	data := make(map[string]interface{})
 
 	// time 1
-	time1 := c.PostForm("entry[time_1]")
+	time1 := form("entry[time_1]")
 	data["time_1"] = time.Now() // set default to avoid panic
 	if time1 == "" {
 		return data, errors.New("time_1 is missing")
@@ -1189,7 +1518,7 @@ func (ctl *Controller) convertTrendlineContractParams(c *gin.Context) (map[strin
 	data["time_1"] = t
 
 	// time 2
-	time2 := c.PostForm("entry[time_2]")
+	time2 := form("entry[time_2]")
 	data["time_2"] = time.Now()
 	if time2 == "" {
 		return data, errors.New("time_2 is missing")
@@ -1201,7 +1530,7 @@ func (ctl *Controller) convertTrendlineContractParams(c *gin.Context) (map[strin
 	data["time_2"] = t
 
 	// trendline_offset_percent
-	entryPercent, err := decimal.NewFromString(c.PostForm("entry[trendline_offset_percent]"))
+	entryPercent, err := decimal.NewFromString(form("entry[trendline_offset_percent]"))
 	if err != nil {
 		return data, errors.New("trendline_offset_percent is invalid")
 	}
@@ -1209,7 +1538,7 @@ func (ctl *Controller) convertTrendlineContractParams(c *gin.Context) (map[strin
 
 	// flip_operator_enabled
 	data["flip_operator_enabled"] = false
-	enabled := c.PostForm("entry[flip_operator_enabled]")
+	enabled := form("entry[flip_operator_enabled]")
 	switch enabled {
 	case "1":
 		data["flip_operator_enabled"] = true
@@ -1220,11 +1549,11 @@ func (ctl *Controller) convertTrendlineContractParams(c *gin.Context) (map[strin
 	}
 
 	// stop loss enabled
-	stopLossEnabled := c.PostForm("stop_loss[enabled]")
+	stopLossEnabled := form("stop_loss[enabled]")
 
 	// loss_tolerance_percent
 	if stopLossEnabled == "1" {
-		lossPercent, err := decimal.NewFromString(c.PostForm("stop_loss[loss_tolerance_percent]"))
+		lossPercent, err := decimal.NewFromString(form("stop_loss[loss_tolerance_percent]"))
 		if err != nil {
 			return data, errors.New("loss_tolerance_percent is invalid")
 		}
@@ -1233,7 +1562,7 @@ func (ctl *Controller) convertTrendlineContractParams(c *gin.Context) (map[strin
 
 	// trendline_readjustment_enabled
 	if stopLossEnabled == "1" {
-		enabled = c.PostForm("stop_loss[trendline_readjustment_enabled]")
+		enabled = form("stop_loss[trendline_readjustment_enabled]")
 		data["trendline_readjustment_enabled"] = false
 		if enabled == "" {
 			return data, errors.New("trendline_readjustment_enabled is invalid")
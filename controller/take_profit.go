@@ -0,0 +1,264 @@
+package controller
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/strategy/trigger"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// Partial take-profit levels before take_profit[price], which closes the rest of the position
+	TAKE_PROFIT_MAX_LEVELS = 3
+)
+
+// `levels` of take_profit_order, percent is a fraction of the opened size, e.g. 0.5 means 50%
+type takeProfitLevel struct {
+	Price   decimal.Decimal
+	Percent decimal.Decimal
+}
+
+// processTakeProfitLevels returns `levels` of take_profit_order in the order of being triggered, nil if there's none.
+// The levels share the operator of take_profit[operator] and must be triggered before take_profit[price]
+func processTakeProfitLevels(form formGetter) ([]interface{}, error) {
+	operator := form("take_profit[operator]")
+	finalPrice, err := decimal.NewFromString(form("take_profit[price]"))
+	if err != nil {
+		return nil, errors.New("take_profit price is invalid")
+	}
+
+	var levels []interface{}
+	prev := decimal.Zero
+	total := decimal.Zero
+	for i := 1; i <= TAKE_PROFIT_MAX_LEVELS; i++ {
+		priceValue := form(fmt.Sprintf("take_profit[level_price_%d]", i))
+		percentValue := form(fmt.Sprintf("take_profit[level_percent_%d]", i))
+		if priceValue == "" && percentValue == "" {
+			continue
+		}
+
+		price, err := decimal.NewFromString(priceValue)
+		if err != nil || !price.IsPositive() {
+			return nil, fmt.Errorf("level_price_%d is invalid", i)
+		}
+		percent, err := decimal.NewFromString(percentValue)
+		if err != nil || !percent.IsPositive() {
+			return nil, fmt.Errorf("level_percent_%d is invalid", i)
+		}
+
+		// e.g. for `>=`, 58000 and 59000 before 60000
+		switch operator {
+		case ">=":
+			if !price.LessThan(finalPrice) || (prev.IsPositive() && !price.GreaterThan(prev)) {
+				return nil, errors.New("分批停利價需依序低於停利價")
+			}
+		case "<=":
+			if !price.GreaterThan(finalPrice) || (prev.IsPositive() && !price.LessThan(prev)) {
+				return nil, errors.New("分批停利價需依序高於停利價")
+			}
+		default:
+			return nil, errors.New("take_profit operator is invalid")
+		}
+		prev = price
+
+		total = total.Add(percent)
+		fraction, _ := percent.Div(decimal.NewFromInt(100)).Float64()
+		levels = append(levels, map[string]interface{}{
+			"price":   price.String(),
+			"percent": fraction,
+		})
+	}
+
+	// The rest is closed by take_profit[price]
+	if total.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+		return nil, errors.New("分批停利比例總和需小於 100%")
+	}
+	return levels, nil
+}
+
+// getTakeProfitLevels returns nil if the take-profit levels aren't set
+func getTakeProfitLevels(params map[string]interface{}) []takeProfitLevel {
+	takeProfit, ok := params["take_profit_order"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	values, ok := takeProfit["levels"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var levels []takeProfitLevel
+	for _, v := range values {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		priceValue, _ := m["price"].(string)
+		price, err := decimal.NewFromString(priceValue)
+		if err != nil {
+			return nil
+		}
+		percent, _ := m["percent"].(float64)
+		levels = append(levels, takeProfitLevel{Price: price, Percent: decimal.NewFromFloat(percent)})
+	}
+	return levels
+}
+
+// formPercent is the percent in the unit of the html forms, e.g. "50" means 50%
+func (l takeProfitLevel) formPercent() string {
+	return l.Percent.Mul(decimal.NewFromInt(100)).String()
+}
+
+// setTakeProfitLevelsTmplData sets the take-profit levels of the params for the templates
+func setTakeProfitLevelsTmplData(data gin.H, params map[string]interface{}) {
+	levels := getTakeProfitLevels(params)
+	var list []gin.H
+	for i, level := range levels {
+		data[fmt.Sprintf("tpLevelPrice%d", i+1)] = level.Price.String()
+		data[fmt.Sprintf("tpLevelPercent%d", i+1)] = level.formPercent()
+		list = append(list, gin.H{"price": level.Price.String(), "percent": level.formPercent()})
+	}
+	data["tpLevels"] = list
+}
+
+// takeProfitLadder closes the next level of the strategy if its price is reached, the engine only closes the whole
+// position at take_profit[price]. It's run by runPositionWorker
+func (ctl *Controller) takeProfitLadder(uuid string) error {
+	cs, err := ctl.db.GetContractStrategyByUuid(uuid)
+	if err != nil {
+		return err
+	}
+	if !isTrackedPosition(cs) {
+		return nil
+	}
+	_, level, err := nextTakeProfitLevel(cs)
+	if err != nil || level == nil {
+		return err
+	}
+
+	ex, err := ctl.newExchangeByUser(cs.UserUuid, cs.Uuid)
+	if err != nil {
+		return err
+	}
+	price, err := ex.GetMarketPrice(cs.Symbol)
+	if err != nil {
+		return err
+	}
+	tpTrigger, err := trigger.NewTrigger(map[string]interface{}{
+		"trigger_type": "limit",
+		"operator":     cs.Params["take_profit_order"].(map[string]interface{})["trigger"].(map[string]interface{})["operator"],
+		"price":        level.Price.String(),
+	})
+	if err != nil {
+		return err
+	}
+	if !tpTrigger.IsPriceTriggered(price) {
+		return nil
+	}
+
+	return ctl.withStrategyDisabled(uuid, func(cs *db.ContractStrategy) error {
+		// The level may be closed since the read
+		ladder, next, err := nextTakeProfitLevel(cs)
+		if err != nil || next == nil || !next.Price.Equal(level.Price) {
+			return err
+		}
+		openedSize, err := decimal.NewFromString(ladder["opened_size"].(string))
+		if err != nil {
+			return err
+		}
+		size := openedSize.Mul(next.Percent)
+		if openedSize.Exponent() < 0 {
+			size = size.Round(-openedSize.Exponent())
+		}
+		return ctl.closeTakeProfitLevel(ex, cs, ladder, size, price)
+	})
+}
+
+// nextTakeProfitLevel returns the level to be closed next, nil if there's none. The closed levels are recorded in
+// `take_profit_levels` of exchange_orders_details with the size opened, and `size` of entry_order becomes the remaining
+// size so that the stop-loss orders placed later have the same size as the position
+func nextTakeProfitLevel(cs *db.ContractStrategy) (ladder map[string]interface{}, level *takeProfitLevel, err error) {
+	levels := getTakeProfitLevels(cs.Params)
+	if len(levels) == 0 {
+		return
+	}
+	entryDetails, ok := cs.ExchangeOrdersDetails["entry_order"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	entrySize, err := decimal.NewFromString(fmt.Sprint(entryDetails["size"]))
+	if err != nil {
+		return
+	}
+
+	// The closed levels belong to the last position only
+	ladder, _ = cs.ExchangeOrdersDetails["take_profit_levels"].(map[string]interface{})
+	if ladder == nil || ladder["position_at"] != cs.LastPositionAt.Format(time.RFC3339) {
+		ladder = map[string]interface{}{
+			"position_at": cs.LastPositionAt.Format(time.RFC3339),
+			"opened_size": entrySize.String(),
+			"closed":      []interface{}{},
+		}
+	}
+	closed, _ := ladder["closed"].([]interface{})
+	if len(closed) >= len(levels) {
+		return
+	}
+	ladder["closed"] = closed
+	return ladder, &levels[len(closed)], nil
+}
+
+// closeTakeProfitLevel closes the size of the position and replaces the stop-loss order by the remaining size. It must be
+// run by withStrategyDisabled
+func (ctl *Controller) closeTakeProfitLevel(ex exchange.Exchanger, cs *db.ContractStrategy, ladder map[string]interface{}, size decimal.Decimal, price decimal.Decimal) (err error) {
+	positionInfo, err := ex.RetryGetPosition(cs.Symbol, 30, 2)
+	if err != nil {
+		return err
+	}
+	remaining, err := decimal.NewFromString(positionInfo["size"].(string))
+	if err != nil {
+		return err
+	}
+	// Leave the rest to take_profit[price] of the engine, in case the position was reduced outside
+	if !size.IsPositive() || size.GreaterThanOrEqual(remaining) {
+		return fmt.Errorf("size %s of the level isn't less than the position %s", size.String(), remaining.String())
+	}
+
+	if err = ex.ClosePosition(cs.Symbol, order.Side(cs.Side), size); err != nil {
+		return err
+	}
+	remaining = remaining.Sub(size)
+
+	// NOTE the level is closed, save it even if the stop-loss order fails so that it won't be closed again, before the
+	// engine tracks the strategy again. The strategy is read after it's disabled, so nothing else is overwritten
+	ladder["closed"] = append(ladder["closed"].([]interface{}), map[string]interface{}{
+		"price":          price.String(),
+		"size":           size.String(),
+		"remaining_size": remaining.String(),
+		"closed_at":      time.Now().UTC().Format(time.RFC3339),
+	})
+	cs.ExchangeOrdersDetails["take_profit_levels"] = ladder
+	cs.ExchangeOrdersDetails["entry_order"].(map[string]interface{})["size"] = remaining.String()
+	defer func() {
+		if _, dbErr := ctl.db.UpdateContractStrategy(cs.Uuid, map[string]interface{}{"exchange_orders_details": cs.ExchangeOrdersDetails}); dbErr != nil && err == nil {
+			err = dbErr
+		}
+	}()
+
+	// The stop-loss order has the size of the position, it's placed again if it fails, or the strategy is left disabled by
+	// withStrategyDisabled if it can't be placed at all
+	if _, ok := cs.ExchangeOrdersDetails["stop_loss_order"].(map[string]interface{}); !ok {
+		return nil
+	}
+	stop, err := stopLossPrice(cs)
+	if err != nil {
+		return err
+	}
+	return ctl.replaceStopLossOrder(ex, cs, stop, stop)
+}
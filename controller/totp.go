@@ -0,0 +1,373 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	"crypto-trading-bot-api/util/totp"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+const (
+	// Shown in authenticator apps
+	TOTP_ISSUER = "fomobot"
+
+	RECOVERY_CODE_COUNT = 10
+)
+
+func (ctl *Controller) SecurityPage(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var errMsg string
+	setting, err := ctl.getUserAuthSetting(userData.Uuid)
+	if err != nil {
+		ctl.log.Println("SecurityPage err:", err)
+		errMsg = "Internal error"
+	}
+	recoveryCodeCount, err := ctl.model.CountUnusedUserRecoveryCodes(userData.Uuid)
+	if err != nil {
+		ctl.log.Println("SecurityPage err:", err)
+		errMsg = "Internal error"
+	}
+
+	c.HTML(http.StatusOK, "security.html", gin.H{
+		"loggedIn":          true,
+		"role":              userData.Role,
+		"error":             errMsg,
+		"secondFactor":      setting.SecondFactor,
+		"totpEnabled":       setting.TotpEnabled(),
+		"recoveryCodeCount": recoveryCodeCount,
+	})
+}
+
+// SetupTotp generates a new secret, it won't take effect until it's verified by EnableTotp
+func (ctl *Controller) SetupTotp(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	setting, err := ctl.getUserAuthSetting(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "SetupTotp", err)
+		return
+	}
+	if setting.TotpEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authenticator 已啟用, 請先停用"})
+		return
+	}
+	user, err := ctl.db.GetUserByUuid(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "SetupTotp", err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "SetupTotp", err)
+		return
+	}
+	encryptedSecret, err := ctl.encryptWithAES([]byte(secret))
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "SetupTotp", err)
+		return
+	}
+	data := map[string]interface{}{
+		"totp_secret":    encryptedSecret,
+		"totp_last_step": 0,
+	}
+	if err = ctl.model.SaveUserAuthSetting(userData.Uuid, data); err != nil {
+		ctl.failJSONWithVagueError(c, "SetupTotp", err)
+		return
+	}
+
+	// QR code for authenticator apps
+	uri := totp.URI(TOTP_ISSUER, user.Username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "SetupTotp", err)
+		return
+	}
+
+	// NOTE the secret is only shown once
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"qrcode": "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// EnableTotp verifies the first code from the authenticator app, and generates recovery codes
+func (ctl *Controller) EnableTotp(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	setting, err := ctl.getUserAuthSetting(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "EnableTotp", err)
+		return
+	}
+	if setting.TotpEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authenticator 已啟用"})
+		return
+	}
+	if setting.TotpSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "請先設定 Authenticator"})
+		return
+	}
+	secret, err := ctl.decryptWithAES(setting.TotpSecret)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "EnableTotp", err)
+		return
+	}
+	step, ok := totp.Validate(string(secret), c.PostForm("code"), time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "驗證碼錯誤"})
+		return
+	}
+
+	codes, err := ctl.resetRecoveryCodes(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "EnableTotp", err)
+		return
+	}
+	data := map[string]interface{}{
+		"totp_enabled_at": time.Now(),
+		"totp_last_step":  step,
+	}
+	if err = ctl.model.SaveUserAuthSetting(userData.Uuid, data); err != nil {
+		ctl.failJSONWithVagueError(c, "EnableTotp", err)
+		return
+	}
+
+	// NOTE recovery codes are only shown once
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (ctl *Controller) DisableTotp(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	setting, err := ctl.getUserAuthSetting(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "DisableTotp", err)
+		return
+	}
+	if err := ctl.verifyTotp(setting, c.PostForm("code")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Fall back to telegram
+	data := map[string]interface{}{
+		"second_factor":   model.SECOND_FACTOR_TELEGRAM,
+		"totp_secret":     "",
+		"totp_enabled_at": nil,
+		"totp_last_step":  0,
+	}
+	if err = ctl.model.SaveUserAuthSetting(userData.Uuid, data); err != nil {
+		ctl.failJSONWithVagueError(c, "DisableTotp", err)
+		return
+	}
+	if err = ctl.model.ReplaceUserRecoveryCodes(userData.Uuid, []string{}); err != nil {
+		ctl.failJSONWithVagueError(c, "DisableTotp", err)
+		return
+	}
+
+	// Second factor is weakened, log out the other devices
+	ctl.revokeOtherSessions(c, "DisableTotp")
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (ctl *Controller) RegenerateRecoveryCodes(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	setting, err := ctl.getUserAuthSetting(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "RegenerateRecoveryCodes", err)
+		return
+	}
+	if err := ctl.verifyTotp(setting, c.PostForm("code")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := ctl.resetRecoveryCodes(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "RegenerateRecoveryCodes", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (ctl *Controller) UpdateSecondFactor(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+	userData := ctl.getUserData(c)
+	if userData.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	secondFactor := c.PostForm("second_factor")
+	switch secondFactor {
+	case model.SECOND_FACTOR_TELEGRAM, model.SECOND_FACTOR_TOTP, model.SECOND_FACTOR_BOTH:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "second_factor is invalid"})
+		return
+	}
+
+	setting, err := ctl.getUserAuthSetting(userData.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "UpdateSecondFactor", err)
+		return
+	}
+	if secondFactor != model.SECOND_FACTOR_TELEGRAM && !setting.TotpEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "請先啟用 Authenticator"})
+		return
+	}
+	// Same as DisableTotp, the session alone can't drop the authenticator
+	if setting.RequiresTotp() && secondFactor != setting.SecondFactor {
+		if err := ctl.verifyTotp(setting, c.PostForm("code")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	data := map[string]interface{}{
+		"second_factor": secondFactor,
+	}
+	if err = ctl.model.SaveUserAuthSetting(userData.Uuid, data); err != nil {
+		ctl.failJSONWithVagueError(c, "UpdateSecondFactor", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// getUserAuthSetting returns the default setting if the user hasn't set it
+func (ctl *Controller) getUserAuthSetting(userUuid string) (*model.UserAuthSetting, error) {
+	setting, err := ctl.model.GetUserAuthSettingByUser(userUuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.NewUserAuthSetting(userUuid), nil
+	}
+	if err != nil {
+		return model.NewUserAuthSetting(userUuid), err
+	}
+	return setting, nil
+}
+
+// verifyTotp accepts either the code from authenticator app or an unused recovery code
+func (ctl *Controller) verifyTotp(setting *model.UserAuthSetting, code string) error {
+	if !setting.TotpEnabled() {
+		return errors.New("Authenticator 未啟用")
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return errors.New("請輸入驗證碼")
+	}
+
+	secret, err := ctl.decryptWithAES(setting.TotpSecret)
+	if err != nil {
+		ctl.log.Println("[ERROR] verifyTotp failed to decrypt secret, err:", err)
+		return errors.New("Internal error")
+	}
+	if step, ok := totp.Validate(string(secret), code, time.Now()); ok {
+		accepted, err := ctl.model.UpdateTotpLastStep(setting.UserUuid, step)
+		if err != nil {
+			ctl.log.Println("[ERROR] verifyTotp err:", err)
+			return errors.New("Internal error")
+		}
+		if !accepted {
+			return errors.New("驗證碼已使用過, 請等待下一組")
+		}
+		return nil
+	}
+
+	// Recovery code
+	hash, err := ctl.hashRecoveryCode(code)
+	if err != nil {
+		return errors.New("Internal error")
+	}
+	used, err := ctl.model.UseUserRecoveryCode(setting.UserUuid, hash)
+	if err != nil {
+		ctl.log.Println("[ERROR] verifyTotp err:", err)
+		return errors.New("Internal error")
+	}
+	if !used {
+		return errors.New("驗證碼錯誤")
+	}
+	return nil
+}
+
+// resetRecoveryCodes replaces all the recovery codes, and returns the new ones in plain text
+func (ctl *Controller) resetRecoveryCodes(userUuid string) ([]string, error) {
+	var codes, hashes []string
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return []string{}, err
+		}
+		s := hex.EncodeToString(b)
+		code := fmt.Sprintf("%s-%s", s[:5], s[5:])
+		hash, err := ctl.hashRecoveryCode(code)
+		if err != nil {
+			return []string{}, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+	if err := ctl.model.ReplaceUserRecoveryCodes(userUuid, hashes); err != nil {
+		return []string{}, err
+	}
+	return codes, nil
+}
+
+func (ctl *Controller) hashRecoveryCode(code string) (string, error) {
+	hash, err := ctl.getSignatureHash([]byte(strings.ToLower(strings.TrimSpace(code))))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash), nil
+}
@@ -0,0 +1,328 @@
+package controller
+
+import (
+	"crypto-trading-bot-api/model"
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	// Modes of the trailing stop-loss
+	TRAILING_PERCENT = "percent"
+	TRAILING_AMOUNT  = "amount"
+
+	// The stop-loss order is replaced only if it moves more than 0.1% of the price, instead of on every tick
+	TRAILING_STOP_MIN_MOVE = "0.001"
+)
+
+// `trailing` of stop_loss_order, value is a fraction for TRAILING_PERCENT, e.g. 0.01 means 1%
+type trailingStop struct {
+	Mode            string
+	Value           decimal.Decimal
+	ActivationPrice decimal.Decimal // zero if it's activated once opened
+}
+
+// processTrailingStopParams returns `trailing` of stop_loss_order, nil if it's not enabled
+func processTrailingStopParams(form formGetter) (map[string]interface{}, error) {
+	if form("stop_loss[trailing_enabled]") != "1" {
+		return nil, nil
+	}
+
+	value, err := decimal.NewFromString(form("stop_loss[trailing_value]"))
+	if err != nil || !value.IsPositive() {
+		return nil, errors.New("trailing_value is invalid")
+	}
+	trailing := map[string]interface{}{}
+	switch mode := form("stop_loss[trailing_mode]"); mode {
+	case TRAILING_PERCENT:
+		if value.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+			return nil, errors.New("trailing_value is invalid")
+		}
+		trailing["mode"] = mode
+		trailing["value"], _ = value.Div(decimal.NewFromInt(100)).Float64()
+	case TRAILING_AMOUNT:
+		trailing["mode"] = mode
+		trailing["value"], _ = value.Float64()
+	default:
+		return nil, errors.New("trailing_mode is invalid")
+	}
+
+	if activationPrice := form("stop_loss[trailing_activation_price]"); activationPrice != "" {
+		price, err := decimal.NewFromString(activationPrice)
+		if err != nil || !price.IsPositive() {
+			return nil, errors.New("trailing_activation_price is invalid")
+		}
+		trailing["activation_price"] = price.String()
+	}
+	return trailing, nil
+}
+
+// getTrailingStop returns nil if the trailing stop-loss isn't set
+func getTrailingStop(params map[string]interface{}) *trailingStop {
+	stopLoss, ok := params["stop_loss_order"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	m, ok := stopLoss["trailing"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	t := &trailingStop{}
+	t.Mode, _ = m["mode"].(string)
+	value, _ := m["value"].(float64)
+	t.Value = decimal.NewFromFloat(value)
+	if price, ok := m["activation_price"].(string); ok {
+		t.ActivationPrice, _ = decimal.NewFromString(price)
+	}
+	if (t.Mode != TRAILING_PERCENT && t.Mode != TRAILING_AMOUNT) || !t.Value.IsPositive() {
+		return nil
+	}
+	return t
+}
+
+// formValue is the value in the unit of the html forms, e.g. "1" means 1%
+func (t *trailingStop) formValue() string {
+	if t.Mode == TRAILING_PERCENT {
+		return t.Value.Mul(decimal.NewFromInt(100)).String()
+	}
+	return t.Value.String()
+}
+
+// stopPrice is below the peak price of long, or above the peak price of short
+func (t *trailingStop) stopPrice(side order.Side, peak decimal.Decimal) decimal.Decimal {
+	distance := t.Value
+	if t.Mode == TRAILING_PERCENT {
+		distance = peak.Mul(t.Value)
+	}
+	if side == order.SHORT {
+		return peak.Add(distance)
+	}
+	return peak.Sub(distance)
+}
+
+// setTrailingStopTmplData sets the trailing stop-loss of the params for the templates
+func setTrailingStopTmplData(data gin.H, params map[string]interface{}) {
+	t := getTrailingStop(params)
+	data["slTrailingEnabled"] = t != nil
+	if t == nil {
+		return
+	}
+	data["slTrailingAmount"] = t.Mode == TRAILING_AMOUNT
+	data["slTrailingValue"] = t.formValue()
+	if t.ActivationPrice.IsPositive() {
+		data["slTrailingActivationPrice"] = t.ActivationPrice.String()
+	}
+}
+
+// trailStopLoss follows the peak price since the position is opened (or the activation price is reached), and replaces
+// the stop-loss order if the stop price moves to the better side. The engine only knows the fixed stop-loss, it's run by
+// runPositionWorker
+func (ctl *Controller) trailStopLoss(uuid string) error {
+	cs, err := ctl.db.GetContractStrategyByUuid(uuid)
+	if err != nil {
+		return err
+	}
+	t := getTrailingStop(cs.Params)
+	if t == nil || !isTrackedPosition(cs) {
+		return nil
+	}
+	// The stop-loss order is placed by the engine after entry
+	if _, ok := cs.ExchangeOrdersDetails["stop_loss_order"].(map[string]interface{}); !ok {
+		return nil
+	}
+	ct, err := contract.NewContract(order.Side(cs.Side), cs.Params)
+	if err != nil {
+		return err
+	}
+
+	ex, err := ctl.newExchangeByUser(cs.UserUuid, cs.Uuid)
+	if err != nil {
+		return err
+	}
+	price, err := ex.GetMarketPrice(cs.Symbol)
+	if err != nil {
+		return err
+	}
+
+	// The state is reset for a new position
+	ts, err := ctl.model.GetTrailingStop(uuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ts, err = &model.TrailingStop{StrategyUuid: uuid}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if !ts.PositionAt.Equal(cs.LastPositionAt) {
+		ts.PositionAt = cs.LastPositionAt
+		ts.PeakPrice = decimal.Zero
+		ts.StopPrice = decimal.Zero
+	}
+
+	side := order.Side(cs.Side)
+	if ts.PeakPrice.IsZero() && t.ActivationPrice.IsPositive() && !isBetterPrice(side, price, t.ActivationPrice) && !price.Equal(t.ActivationPrice) {
+		return nil
+	}
+	if ts.PeakPrice.IsZero() || isBetterPrice(side, price, ts.PeakPrice) {
+		ts.PeakPrice = price
+	}
+
+	// Round to the decimal places of the price, the exchanges reject the prices finer than the tick size
+	stop := t.stopPrice(side, ts.PeakPrice)
+	if price.Exponent() < 0 {
+		stop = stop.Round(-price.Exponent())
+	}
+
+	// Only move to the better side and far enough, and never over the price
+	current := ts.StopPrice
+	if ct.StopLossOrder != nil && ct.StopLossOrder.GetTrigger() != nil {
+		current = ct.StopLossOrder.GetTrigger().GetPrice(time.Now())
+	}
+	minMove := price.Mul(decimal.RequireFromString(TRAILING_STOP_MIN_MOVE))
+	moved := !current.IsPositive() || (isBetterPrice(side, stop, current) && stop.Sub(current).Abs().GreaterThanOrEqual(minMove))
+	if !moved || !stop.IsPositive() || !isBetterPrice(side, price, stop) {
+		_, err = ctl.model.SaveTrailingStop(ts)
+		return err
+	}
+
+	err = ctl.withStrategyDisabled(uuid, func(cs *db.ContractStrategy) error {
+		// The peak price belongs to the position read before
+		if !cs.LastPositionAt.Equal(ts.PositionAt) || !isStopLossMovable(cs, stop) {
+			return nil
+		}
+		if err := ctl.moveStopLossOrder(ex, cs, stop); err != nil {
+			return err
+		}
+		ts.StopPrice = stop
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ctl.model.SaveTrailingStop(ts)
+	return err
+}
+
+// isStopLossMovable returns true if the stop-loss order has been placed, and the stop is better than its trigger
+func isStopLossMovable(cs *db.ContractStrategy, stop decimal.Decimal) bool {
+	if _, ok := cs.ExchangeOrdersDetails["stop_loss_order"].(map[string]interface{}); !ok {
+		return false
+	}
+	ct, err := contract.NewContract(order.Side(cs.Side), cs.Params)
+	if err != nil {
+		return false
+	}
+	if ct.StopLossOrder == nil || ct.StopLossOrder.GetTrigger() == nil {
+		return true
+	}
+	return isBetterPrice(order.Side(cs.Side), stop, ct.StopLossOrder.GetTrigger().GetPrice(time.Now()))
+}
+
+// errStopLossLost is returned if the stop-loss order of the position is cancelled and can't be placed again, the strategy
+// is left disabled by withStrategyDisabled and the user is notified
+var errStopLossLost = errors.New("stop-loss order is lost")
+
+// moveStopLossOrder replaces the stop-loss order, the same as updating stop-loss of the disabled strategy. It must be
+// run by withStrategyDisabled, and it's shared by the trailing stop-loss and the break-even rule
+func (ctl *Controller) moveStopLossOrder(ex exchange.Exchanger, cs *db.ContractStrategy, stop decimal.Decimal) (err error) {
+	previous, err := stopLossPrice(cs)
+	if err != nil {
+		return err
+	}
+	if err = ctl.replaceStopLossOrder(ex, cs, stop, previous); err != nil {
+		return err
+	}
+
+	// Same as applyTpSl, the trigger of trendline strategies is set after entry as well
+	operator := "<="
+	if order.Side(cs.Side) == order.SHORT {
+		operator = ">="
+	}
+	cs.Params["stop_loss_order"].(map[string]interface{})["trigger"] = map[string]interface{}{
+		"trigger_type": "limit",
+		"operator":     operator,
+		"price":        stop.String(),
+	}
+
+	data := map[string]interface{}{
+		"params":                  cs.Params,
+		"exchange_orders_details": cs.ExchangeOrdersDetails,
+	}
+	_, err = ctl.db.UpdateContractStrategy(cs.Uuid, data)
+	return err
+}
+
+// replaceStopLossOrder cancels the stop-loss order and places it at the stop by the size of entry_order. If it fails, the
+// stop-loss order is placed again at the previous price, and errStopLossLost is returned if that fails as well. The
+// order id is saved in exchange_orders_details if it isn't moved
+func (ctl *Controller) replaceStopLossOrder(ex exchange.Exchanger, cs *db.ContractStrategy, stop decimal.Decimal, previous decimal.Decimal) error {
+	if err := ctl.cancelStopLossOrder(ex, cs); err != nil {
+		return err
+	}
+	delete(cs.ExchangeOrdersDetails, "stop_loss_order")
+	orderId, err := ctl.updateStopLossOrder(ex, cs, stop)
+	if err == nil {
+		cs.ExchangeOrdersDetails["stop_loss_order"] = map[string]interface{}{
+			"order_id": float64(orderId),
+		}
+		return nil
+	}
+
+	ctl.log.Printf("[ERROR] failed to replace stop-loss order of strategy '%s' at %s, placing it at %s again, err: %v", cs.Uuid, stop.String(), previous.String(), err)
+	orderId, restoreErr := ctl.updateStopLossOrder(ex, cs, previous)
+	if restoreErr != nil {
+		err = fmt.Errorf("%w: %v, %v", errStopLossLost, err, restoreErr)
+	} else {
+		cs.ExchangeOrdersDetails["stop_loss_order"] = map[string]interface{}{
+			"order_id": float64(orderId),
+		}
+	}
+	// NOTE the stop-loss order is changed, save it so that the old one won't be cancelled again
+	if _, dbErr := ctl.db.UpdateContractStrategy(cs.Uuid, map[string]interface{}{"exchange_orders_details": cs.ExchangeOrdersDetails}); dbErr != nil {
+		ctl.log.Println("[ERROR] failed to update db, err:", dbErr)
+	}
+	return err
+}
+
+// stopLossPrice returns the price of the stop-loss order of the opened position, the trendline strategies without the
+// trigger are loss_tolerance_percent away from the entry price, see calculateMargin
+func stopLossPrice(cs *db.ContractStrategy) (decimal.Decimal, error) {
+	ct, err := contract.NewContract(order.Side(cs.Side), cs.Params)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if ct.StopLossOrder == nil {
+		return decimal.Zero, errors.New("stop-loss isn't set")
+	}
+	if ct.StopLossOrder.GetTrigger() != nil {
+		return ct.StopLossOrder.GetTrigger().GetPrice(time.Now()), nil
+	}
+
+	entryDetails, _ := cs.ExchangeOrdersDetails["entry_order"].(map[string]interface{})
+	entryPrice, err := decimal.NewFromString(fmt.Sprint(entryDetails["price"]))
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("entry price '%v' is invalid", entryDetails["price"])
+	}
+	distance := decimal.NewFromFloat(ct.StopLossOrder.(*order.StopLoss).LossTolerancePercent)
+	if order.Side(cs.Side) == order.SHORT {
+		return entryPrice.Mul(decimal.NewFromInt(1).Add(distance)), nil
+	}
+	return entryPrice.Mul(decimal.NewFromInt(1).Sub(distance)), nil
+}
+
+// isBetterPrice returns true if a is higher than b for long, or lower than b for short
+func isBetterPrice(side order.Side, a decimal.Decimal, b decimal.Decimal) bool {
+	if side == order.SHORT {
+		return a.LessThan(b)
+	}
+	return a.GreaterThan(b)
+}
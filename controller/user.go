@@ -1,17 +1,118 @@
 package controller
 
 import (
-	"crypto-trading-bot-engine/util/aes"
-	"encoding/hex"
+	"crypto-trading-bot-api/model"
+	"crypto-trading-bot-api/util/exchangeinfo"
+	"crypto-trading-bot-api/util/keycheck"
+	engineDb "crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/strategy/contract"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 	"github.com/spf13/viper"
+	"gorm.io/gorm"
 )
 
-func (ctl *Controller) NewApiKey(c *gin.Context) {
+const (
+	// Label of the credential imported from users.exchange_api_key
+	LEGACY_CREDENTIAL_LABEL = "default"
+
+	CREDENTIAL_LABEL_MAX_LENGTH = 64
+
+	// Flagged in the strategy list if it's not verified for a while
+	CREDENTIAL_VERIFY_STALE_DAY = 7
+)
+
+// getEnabledExchanges returns the exchanges of config EXCHANGES (e.g. [FTX, BINANCE]), or DEFAULT_EXCHANGE if it's not set
+func getEnabledExchanges() []*exchangeinfo.Exchange {
+	names := viper.GetStringSlice("EXCHANGES")
+	if len(names) == 0 {
+		names = []string{viper.GetString("DEFAULT_EXCHANGE")}
+	}
+	var exchanges []*exchangeinfo.Exchange
+	for _, name := range names {
+		if e := exchangeinfo.Get(name); e != nil {
+			exchanges = append(exchanges, e)
+		}
+	}
+	return exchanges
+}
+
+func isEnabledExchange(name string) bool {
+	for _, e := range getEnabledExchanges() {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// exchangeDisplayName returns the name itself if the exchange isn't supported
+func exchangeDisplayName(name string) string {
+	e := exchangeinfo.Get(exchangeinfo.Underlying(name))
+	if e == nil {
+		return name
+	}
+	if exchangeinfo.IsPaper(name) {
+		return e.DisplayName + " (模擬)"
+	}
+	return e.DisplayName
+}
+
+// strategyExchange returns the exchange of the credential, or DEFAULT_EXCHANGE if the user hasn't had any credential
+func strategyExchange(credential *model.ExchangeCredential) string {
+	if credential == nil {
+		return viper.GetString("DEFAULT_EXCHANGE")
+	}
+	return credential.Exchange
+}
+
+// Result of the credential verification
+const (
+	CREDENTIAL_STATUS_OK      = "ok"
+	CREDENTIAL_STATUS_WARNING = "warning" // withdrawal is enabled but allowed by CREDENTIAL_ALLOW_WITHDRAWAL
+	CREDENTIAL_STATUS_FAILED  = "failed"
+)
+
+// Reasons of the rejected credentials, also used as `err` of /user/credentials
+var credentialCheckMessages = map[string]string{
+	"key_invalid":             "API Key 或 Secret 錯誤",
+	"key_ip_not_allowed":      "API Key 的 IP 白名單不包含本服務",
+	"key_subaccount_mismatch": "API Key 不屬於此 Subaccount",
+	"key_read_only":           "API Key 沒有交易權限",
+	"key_withdrawal_enabled":  "API Key 開啟了提領權限, 請關閉後重新建立",
+	"exchange_unsupported":    "不支援此交易所",
+	"paper_disabled":          "模擬交易未啟用",
+	"invalid_balance":         "初始資金格式錯誤",
+	"exchange_unavailable":    "交易所無回應, 請稍後再試",
+}
+
+// for template
+type CredentialTmpl struct {
+	Uuid           string
+	Label          string
+	Exchange       string
+	Subaccount     string
+	IsDefault      bool
+	IsPaper        bool
+	VerifyStatus   string
+	VerifyMessage  string
+	CanTrade       bool
+	CanWithdraw    bool
+	IpRestricted   string
+	LastVerifiedAt string
+	CreatedAt      string
+}
+
+func (ctl *Controller) ListCredentials(c *gin.Context) {
 	if !ctl.tokenAuthCheck(c) {
 		return
 	}
@@ -19,109 +120,637 @@ func (ctl *Controller) NewApiKey(c *gin.Context) {
 	errMsg := c.Query("err")
 
 	userCookie := ctl.getUserData(c)
+	var credentials []model.ExchangeCredential
 	user, err := ctl.db.GetUserByUuid(userCookie.Uuid)
 	if err != nil {
 		ctl.log.Printf("[ERROR] failed to get user by '%s', err: %v", userCookie.Uuid, err)
-		errMsg = "用戶不存在"
+		errMsg = "internal_error"
+	} else if credentials, err = ctl.getExchangeCredentials(user); err != nil {
+		ctl.log.Println("ListCredentials err:", err)
+		errMsg = "internal_error"
 	}
-	apiKeyExists := false
-	if user.ExchangeApiKey != "" {
-		apiKeyExists = true
+
+	loc := ctl.getUserLocation(userCookie.Uuid)
+	var credentialTmpls []CredentialTmpl
+	for _, ec := range credentials {
+		tmpl := CredentialTmpl{
+			Uuid:           ec.Uuid,
+			Label:          ec.Label,
+			Exchange:       exchangeDisplayName(ec.Exchange),
+			Subaccount:     ec.Subaccount,
+			IsDefault:      ec.IsDefault,
+			IsPaper:        exchangeinfo.IsPaper(ec.Exchange),
+			VerifyStatus:   ec.VerifyStatus,
+			VerifyMessage:  ec.VerifyMessage,
+			CanTrade:       ec.CanTrade,
+			CanWithdraw:    ec.CanWithdraw,
+			IpRestricted:   "未知",
+			LastVerifiedAt: "(未驗證)",
+			CreatedAt:      ec.CreatedAt.In(loc).Format("2006-01-02 15:04:05"),
+		}
+		if ec.IpRestricted != nil && *ec.IpRestricted {
+			tmpl.IpRestricted = "有"
+		} else if ec.IpRestricted != nil {
+			tmpl.IpRestricted = "無"
+		}
+		if ec.LastVerifiedAt != nil {
+			tmpl.LastVerifiedAt = ec.LastVerifiedAt.In(loc).Format("2006-01-02 15:04:05")
+		}
+		credentialTmpls = append(credentialTmpls, tmpl)
 	}
 
-	c.HTML(http.StatusOK, "new_apikey.html", gin.H{
+	c.HTML(http.StatusOK, "credentials.html", gin.H{
 		"loggedIn":     true,
 		"role":         userCookie.Role,
 		"success":      success,
 		"errMsg":       errMsg,
-		"apiKeyExists": apiKeyExists,
+		"checkMessage": credentialCheckMessages[errMsg],
+		"exchanges":    getEnabledExchanges(),
+		"paperEnabled": ctl.paperPrices != nil,
+		"credentials":  credentialTmpls,
 	})
 }
 
-func (ctl *Controller) UpdateApiKey(c *gin.Context) {
+func (ctl *Controller) CreateCredential(c *gin.Context) {
 	if !ctl.tokenAuthCheck(c) {
 		return
 	}
+	// Credentials can't be managed by API token
+	if ctl.getUserData(c).ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
 
-	apiKey := c.PostForm("api_key")
-	apiSecret := c.PostForm("api_secret")
-	subaccount := c.PostForm("subaccount")
-	if apiKey == "" || apiSecret == "" || subaccount == "" {
-		ctl.redirectToLoginPage(c, "/user/apikey/new?err=empty_data")
+	exchangeName := c.DefaultPostForm("exchange", viper.GetString("DEFAULT_EXCHANGE"))
+	if !isEnabledExchange(exchangeName) {
+		ctl.redirectToLoginPage(c, "/user/credentials?err=exchange_unsupported")
 		return
 	}
 
-	details := make(map[string]interface{})
-	details = map[string]interface{}{
-		viper.GetString("DEFAULT_EXCHANGE"): map[string]interface{}{
-			"api_key":    apiKey,
-			"api_secret": apiSecret,
-			"subaccount": subaccount,
-		},
+	// Fields differ by exchange, e.g. subaccount of FTX
+	label := strings.TrimSpace(c.PostForm("label"))
+	if label == "" {
+		ctl.redirectToLoginPage(c, "/user/credentials?err=empty_data")
+		return
+	}
+	fields := make(map[string]string)
+	for _, f := range exchangeinfo.Get(exchangeName).CredentialFields {
+		fields[f.Name] = strings.TrimSpace(c.PostForm(exchangeName + "[" + f.Name + "]"))
+		if f.Required && fields[f.Name] == "" {
+			ctl.redirectToLoginPage(c, "/user/credentials?err=empty_data")
+			return
+		}
+	}
+	if len(label) > CREDENTIAL_LABEL_MAX_LENGTH {
+		ctl.redirectToLoginPage(c, "/user/credentials?err=label_too_long")
+		return
 	}
 
-	b, err := json.Marshal(details)
+	userCookie := ctl.getUserData(c)
+	credentials, _, err := ctl.model.GetExchangeCredentialsByUser(userCookie.Uuid)
 	if err != nil {
-		ctl.log.Println("UpdateApiKey err:", err)
-		ctl.redirectToLoginPage(c, "/user/apikey/new?err=internal_error")
+		ctl.log.Println("CreateCredential err:", err)
+		ctl.redirectToLoginPage(c, "/user/credentials?err=internal_error")
 		return
 	}
+	for _, ec := range credentials {
+		if ec.Label == label {
+			ctl.redirectToLoginPage(c, "/user/credentials?err=duplicate_label")
+			return
+		}
+	}
 
-	// Encrypt data using AES
-	key, err := hex.DecodeString(viper.GetString("AES_PRIVATE_KEY"))
-	if err != nil {
-		ctl.log.Println("UpdateApiKey err:", err)
-		ctl.redirectToLoginPage(c, "/user/apikey/new?err=internal_error")
+	// Verify the key before saving it
+	verifyResult, errCode := ctl.checkCredential(exchangeName, fields["api_key"], fields["api_secret"], fields["subaccount"])
+	if errCode != "" {
+		ctl.redirectToLoginPage(c, "/user/credentials?err="+errCode)
 		return
 	}
-	iv64, data64, err := aes.Encrypt([]byte(key), b)
+
+	encryptedData, err := ctl.encryptCredential(exchangeName, fields)
 	if err != nil {
-		ctl.log.Println("UpdateApiKey err:", err)
-		ctl.redirectToLoginPage(c, "/user/apikey/new?err=internal_error")
+		ctl.log.Println("CreateCredential err:", err)
+		ctl.redirectToLoginPage(c, "/user/credentials?err=internal_error")
 		return
 	}
-	encryptedData := fmt.Sprintf("%s;%s", iv64, data64)
 
-	// Update data
-	userCookie := ctl.getUserData(c)
-	data := map[string]interface{}{
-		"exchange_api_key": encryptedData,
+	// The first one becomes the default
+	credential := model.ExchangeCredential{
+		Uuid:          uuid.New().String(),
+		UserUuid:      userCookie.Uuid,
+		Label:         label,
+		Exchange:      exchangeName,
+		Subaccount:    fields["subaccount"],
+		EncryptedData: encryptedData,
+		IsDefault:     len(credentials) == 0,
 	}
-	if _, err = ctl.db.UpdateUser(userCookie.Uuid, data); err != nil {
-		ctl.redirectToLoginPage(c, "/user/apikey/new?err=internal_error")
+	verifyResult.applyTo(&credential)
+	if _, _, err = ctl.model.CreateExchangeCredential(credential); err != nil {
+		ctl.log.Println("CreateCredential err:", err)
+		ctl.redirectToLoginPage(c, "/user/credentials?err=internal_error")
 		return
 	}
+	if credential.IsDefault {
+		if err = ctl.syncDefaultCredential(userCookie.Uuid, &credential); err != nil {
+			ctl.log.Println("CreateCredential err:", err)
+			ctl.redirectToLoginPage(c, "/user/credentials?err=internal_error")
+			return
+		}
+	}
+
+	// API key is changed, log out the other devices
+	ctl.revokeOtherSessions(c, "CreateCredential")
 
-	ctl.redirectToLoginPage(c, "/user/apikey/new?success=update")
+	if verifyResult.Status == CREDENTIAL_STATUS_WARNING {
+		ctl.redirectToLoginPage(c, "/user/credentials?success=create_with_withdrawal")
+		return
+	}
+	ctl.redirectToLoginPage(c, "/user/credentials?success=create")
 }
 
-func (ctl *Controller) DeleteApiKey(c *gin.Context) {
+func (ctl *Controller) DeleteCredential(c *gin.Context) {
 	if !ctl.tokenAuthCheck(c) {
 		return
 	}
 
 	userCookie := ctl.getUserData(c)
-	data := map[string]interface{}{
-		"exchange_api_key": nil,
+	if userCookie.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	credential, err := ctl.model.GetExchangeCredentialByUuidByUser(c.Param("uuid"), userCookie.Uuid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// Strategies keep using the credential after they're created
+	count, err := ctl.model.CountStrategiesByExchangeCredential(credential.Uuid)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "DeleteCredential", err)
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "仍有策略使用此 API Key, 請先刪除相關策略"})
+		return
 	}
-	if _, err := ctl.db.UpdateUser(userCookie.Uuid, data); err != nil {
-		ctl.failJSONWithVagueError(c, "DeleteApiKey", err)
+
+	if _, err = ctl.model.DeleteExchangeCredential(credential.Uuid); err != nil {
+		ctl.failJSONWithVagueError(c, "DeleteCredential", err)
 		return
 	}
+	if exchangeinfo.IsPaper(credential.Exchange) {
+		if err = ctl.model.DeletePaperAccount(credential.Uuid); err != nil {
+			ctl.failJSONWithVagueError(c, "DeleteCredential", err)
+			return
+		}
+	}
+
+	// Promote the oldest one (except paper accounts) to default, or remove the default API key if there's none left
+	if credential.IsDefault {
+		credentials, _, err := ctl.model.GetExchangeCredentialsByUser(userCookie.Uuid)
+		if err != nil {
+			ctl.failJSONWithVagueError(c, "DeleteCredential", err)
+			return
+		}
+		var next *model.ExchangeCredential
+		for i := range credentials {
+			if !exchangeinfo.IsPaper(credentials[i].Exchange) {
+				next = &credentials[i]
+				break
+			}
+		}
+		if next != nil {
+			if err = ctl.model.SetDefaultExchangeCredential(next.Uuid, userCookie.Uuid); err != nil {
+				ctl.failJSONWithVagueError(c, "DeleteCredential", err)
+				return
+			}
+		}
+		if err = ctl.syncDefaultCredential(userCookie.Uuid, next); err != nil {
+			ctl.failJSONWithVagueError(c, "DeleteCredential", err)
+			return
+		}
+	}
+
+	// API key is removed, log out the other devices
+	ctl.revokeOtherSessions(c, "DeleteCredential")
 
 	c.JSON(http.StatusOK, gin.H{})
 }
 
-func (ctl *Controller) TestApiKey(c *gin.Context) {
+// SetDefaultCredential changes the credential pinned by new strategies, it's also copied to users.exchange_api_key
+func (ctl *Controller) SetDefaultCredential(c *gin.Context) {
 	if !ctl.tokenAuthCheck(c) {
 		return
 	}
 
-	_, err := ctl.getExchangeAccountInfo(c)
+	userCookie := ctl.getUserData(c)
+	if userCookie.ApiToken != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	credential, err := ctl.model.GetExchangeCredentialByUuidByUser(c.Param("uuid"), userCookie.Uuid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
+		return
+	}
+	if exchangeinfo.IsPaper(credential.Exchange) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "模擬帳戶不可設為預設"})
+		return
+	}
+	inUse, err := ctl.hasRunningStrategiesOfOtherCredential(userCookie.Uuid, credential.Uuid)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "發生未知錯誤, 請更新 API Key"})
+		ctl.failJSONWithVagueError(c, "SetDefaultCredential", err)
+		return
+	}
+	if inUse {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "請先暫停並平倉使用目前預設 API Key 的策略"})
+		return
+	}
+	if err = ctl.model.SetDefaultExchangeCredential(credential.Uuid, userCookie.Uuid); err != nil {
+		ctl.failJSONWithVagueError(c, "SetDefaultCredential", err)
+		return
+	}
+	if err = ctl.syncDefaultCredential(userCookie.Uuid, credential); err != nil {
+		ctl.failJSONWithVagueError(c, "SetDefaultCredential", err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+func (ctl *Controller) TestCredential(c *gin.Context) {
+	if !ctl.tokenAuthCheck(c) {
+		return
+	}
+
+	userCookie := ctl.getUserData(c)
+	credential, err := ctl.model.GetExchangeCredentialByUuidByUser(c.Param("uuid"), userCookie.Uuid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// Paper accounts only need the price source
+	if exchangeinfo.IsPaper(credential.Exchange) {
+		if ctl.paperPrices == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": credentialCheckMessages["paper_disabled"]})
+			return
+		}
+		info, err := ctl.newPaperExchange(credential).GetAccountInfo()
+		if err != nil {
+			ctl.log.Printf("[ERROR] failed to get paper account '%s', err: %v", credential.Uuid, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無法取得模擬帳戶價格, 請確認價格來源"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": CREDENTIAL_STATUS_OK, "message": "模擬帳戶資金: " + info["collateral"].(decimal.Decimal).StringFixed(2)})
+		return
+	}
+
+	apiKey, apiSecret, subaccount, err := ctl.decryptCredential(credential)
+	if err != nil {
+		ctl.failJSONWithVagueError(c, "TestCredential", err)
+		return
+	}
+
+	// The result is saved even if it fails, so that the strategy list can flag the broken key
+	verifyResult, errCode := ctl.checkCredential(credential.Exchange, apiKey, apiSecret, subaccount)
+	if errCode != "exchange_unavailable" {
+		if _, err = ctl.model.UpdateExchangeCredential(credential.Uuid, verifyResult.toData()); err != nil {
+			ctl.log.Println("TestCredential err:", err)
+		}
+	}
+	if errCode != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": credentialCheckMessages[errCode]})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": verifyResult.Status, "message": verifyResult.Message})
+}
+
+// credentialVerifyResult is saved to exchange_credentials
+type credentialVerifyResult struct {
+	Status       string
+	Message      string
+	CanTrade     bool
+	CanWithdraw  bool
+	IpRestricted *bool
+	VerifiedAt   time.Time
+}
+
+func (r *credentialVerifyResult) applyTo(ec *model.ExchangeCredential) {
+	ec.VerifyStatus = r.Status
+	ec.VerifyMessage = r.Message
+	ec.CanTrade = r.CanTrade
+	ec.CanWithdraw = r.CanWithdraw
+	ec.IpRestricted = r.IpRestricted
+	ec.LastVerifiedAt = &r.VerifiedAt
+}
+
+func (r *credentialVerifyResult) toData() map[string]interface{} {
+	return map[string]interface{}{
+		"verify_status":    r.Status,
+		"verify_message":   r.Message,
+		"can_trade":        r.CanTrade,
+		"can_withdraw":     r.CanWithdraw,
+		"ip_restricted":    r.IpRestricted,
+		"last_verified_at": r.VerifiedAt,
+	}
+}
+
+// checkCredential verifies the key against the exchange, returns the code of credentialCheckMessages if it's rejected.
+// Keys without trading permission are rejected, so are the keys with withdrawal permission unless CREDENTIAL_ALLOW_WITHDRAWAL is enabled
+func (ctl *Controller) checkCredential(exchangeName string, apiKey string, apiSecret string, subaccount string) (*credentialVerifyResult, string) {
+	r := &credentialVerifyResult{
+		Status:     CREDENTIAL_STATUS_FAILED,
+		VerifiedAt: time.Now(),
+	}
+	fail := func(errCode string) (*credentialVerifyResult, string) {
+		r.Message = credentialCheckMessages[errCode]
+		return r, errCode
+	}
+
+	checker, err := keycheck.NewChecker(exchangeName)
+	if err != nil {
+		ctl.log.Println("[ERROR] checkCredential err:", err)
+		return fail("exchange_unsupported")
+	}
+	result, err := checker.Check(apiKey, apiSecret, subaccount)
+	switch {
+	case errors.Is(err, keycheck.ErrInvalidKey):
+		return fail("key_invalid")
+	case errors.Is(err, keycheck.ErrIpNotAllowed):
+		restricted := true
+		r.IpRestricted = &restricted
+		return fail("key_ip_not_allowed")
+	case errors.Is(err, keycheck.ErrSubaccountMismatch):
+		return fail("key_subaccount_mismatch")
+	case err != nil:
+		ctl.log.Printf("[ERROR] failed to check API key on %s, err: %v", exchangeName, err)
+		return fail("exchange_unavailable")
+	}
+
+	r.CanTrade = result.CanTrade
+	r.CanWithdraw = result.CanWithdraw
+	r.IpRestricted = result.IpRestricted
+	if !result.CanTrade {
+		return fail("key_read_only")
+	}
+	if result.CanWithdraw {
+		if !viper.GetBool("CREDENTIAL_ALLOW_WITHDRAWAL") {
+			return fail("key_withdrawal_enabled")
+		}
+		r.Status = CREDENTIAL_STATUS_WARNING
+		r.Message = "API Key 開啟了提領權限, 強烈建議關閉"
+		return r, ""
+	}
+	r.Status = CREDENTIAL_STATUS_OK
+	return r, ""
+}
+
+// credentialWarning returns the reason why the key should be checked, for the strategy list
+func credentialWarning(ec *model.ExchangeCredential) string {
+	if ec == nil || exchangeinfo.IsPaper(ec.Exchange) {
+		return ""
+	}
+	switch {
+	case ec.VerifyStatus == CREDENTIAL_STATUS_FAILED:
+		return fmt.Sprintf("API Key '%s' 驗證失敗: %s", ec.Label, ec.VerifyMessage)
+	case ec.LastVerifiedAt == nil:
+		return fmt.Sprintf("API Key '%s' 尚未驗證", ec.Label)
+	case time.Since(*ec.LastVerifiedAt) > CREDENTIAL_VERIFY_STALE_DAY*24*time.Hour:
+		return fmt.Sprintf("API Key '%s' 超過 %d 天未驗證", ec.Label, CREDENTIAL_VERIFY_STALE_DAY)
+	case ec.VerifyStatus == CREDENTIAL_STATUS_WARNING:
+		return fmt.Sprintf("API Key '%s' 開啟了提領權限", ec.Label)
+	}
+	return ""
+}
+
+// getExchangeCredentials imports the API key saved in users.exchange_api_key before credentials were introduced
+func (ctl *Controller) getExchangeCredentials(user *engineDb.User) ([]model.ExchangeCredential, error) {
+	credentials, _, err := ctl.model.GetExchangeCredentialsByUser(user.Uuid)
+	if err != nil || len(credentials) > 0 || user.ExchangeApiKey == "" {
+		return credentials, err
+	}
+
+	b, err := ctl.decryptWithAES(user.ExchangeApiKey)
+	if err != nil {
+		return credentials, err
+	}
+	details := make(map[string]map[string]interface{})
+	if err = json.Unmarshal(b, &details); err != nil {
+		return credentials, err
+	}
+	for exchangeName, detail := range details {
+		subaccount, _ := detail["subaccount"].(string)
+		credential := model.ExchangeCredential{
+			Uuid:          uuid.New().String(),
+			UserUuid:      user.Uuid,
+			Label:         LEGACY_CREDENTIAL_LABEL,
+			Exchange:      exchangeName,
+			Subaccount:    subaccount,
+			EncryptedData: user.ExchangeApiKey,
+			IsDefault:     true,
+		}
+		if _, _, err = ctl.model.CreateExchangeCredential(credential); err != nil {
+			return credentials, err
+		}
+		ctl.log.Printf("[INFO] API key of user '%s' is imported as credential '%s'", user.Uuid, credential.Uuid)
+		credentials = append(credentials, credential)
+
+		// NOTE only one exchange was saved by UpdateApiKey
+		break
+	}
+	return credentials, nil
+}
+
+// syncDefaultCredential copies the default credential to users.exchange_api_key, which is used by engine
+// for the strategies without credential, pass nil to remove it
+func (ctl *Controller) syncDefaultCredential(userUuid string, credential *model.ExchangeCredential) error {
+	data := map[string]interface{}{
+		"exchange_api_key": nil,
+	}
+	if credential != nil {
+		encryptedData, err := ctl.toEngineCiphertext(credential.EncryptedData)
+		if err != nil {
+			return err
+		}
+		data["exchange_api_key"] = encryptedData
+	}
+	_, err := ctl.db.UpdateUser(userUuid, data)
+	return err
+}
+
+// decryptCredential returns the key saved by encryptCredential
+func (ctl *Controller) decryptCredential(credential *model.ExchangeCredential) (apiKey string, apiSecret string, subaccount string, err error) {
+	b, err := ctl.decryptWithAES(credential.EncryptedData)
+	if err != nil {
+		return
+	}
+	details := make(map[string]map[string]interface{})
+	if err = json.Unmarshal(b, &details); err != nil {
+		return
+	}
+	detail, ok := details[credential.Exchange]
+	if !ok {
+		err = fmt.Errorf("exchange '%s' not found in credential '%s'", credential.Exchange, credential.Uuid)
+		return
+	}
+	apiKey, _ = detail["api_key"].(string)
+	apiSecret, _ = detail["api_secret"].(string)
+	subaccount, _ = detail["subaccount"].(string)
+	return
+}
+
+// encryptCredential returns the same format as users.exchange_api_key, fields are CredentialFields of the exchange
+func (ctl *Controller) encryptCredential(exchangeName string, fields map[string]string) (string, error) {
+	detail := make(map[string]interface{})
+	for name, value := range fields {
+		detail[name] = value
+	}
+	details := map[string]interface{}{
+		exchangeName: detail,
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		return "", err
+	}
+
+	// Encrypt data using AES
+	return ctl.encryptWithAES(b)
+}
+
+func (ctl *Controller) newExchangeWithCredential(credential *model.ExchangeCredential) (ex exchange.Exchanger, err error) {
+	if exchangeinfo.IsPaper(credential.Exchange) {
+		if ctl.paperPrices == nil {
+			err = errors.New("模擬交易未啟用")
+			return
+		}
+		return ctl.newPaperExchange(credential), nil
+	}
+
+	encryptedData, err := ctl.toEngineCiphertext(credential.EncryptedData)
+	if err != nil {
+		ctl.log.Printf("[ERROR] failed to decrypt credential '%s', err: %v", credential.Uuid, err)
+		err = errors.New("Internal error")
+		return
+	}
+	ex, err = exchange.NewExchange(credential.Exchange, encryptedData)
+	if err != nil {
+		ctl.log.Printf("[ERROR] failed to new exchange by credential '%s'", credential.Uuid)
+		err = errors.New("API Key 可能已失效, 請確認或重試一次")
+		return
+	}
+	return
+}
+
+// getStrategyCredential returns nil if the strategy uses the default credential
+func (ctl *Controller) getStrategyCredential(strategyUuid string, userUuid string) (*model.ExchangeCredential, error) {
+	credential, err := ctl.model.GetExchangeCredentialByStrategy(strategyUuid, userUuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return credential, err
+}
+
+// getCredentialsByStrategies returns the map of strategy uuid to the credential, the strategies without credential
+// are mapped to the default one
+func (ctl *Controller) getCredentialsByStrategies(userUuid string, css []engineDb.ContractStrategy) (map[string]*model.ExchangeCredential, error) {
+	m := make(map[string]*model.ExchangeCredential)
+	if len(css) == 0 {
+		return m, nil
+	}
+	credentials, _, err := ctl.model.GetExchangeCredentialsByUser(userUuid)
+	if err != nil {
+		return m, err
+	}
+	var strategyUuids []string
+	for _, cs := range css {
+		strategyUuids = append(strategyUuids, cs.Uuid)
+	}
+	credentialUuids, err := ctl.model.GetStrategyCredentialUuids(strategyUuids)
+	if err != nil {
+		return m, err
+	}
+
+	byUuid := make(map[string]*model.ExchangeCredential)
+	var defaultCredential *model.ExchangeCredential
+	for i := range credentials {
+		byUuid[credentials[i].Uuid] = &credentials[i]
+		if credentials[i].IsDefault {
+			defaultCredential = &credentials[i]
+		}
+	}
+	for _, cs := range css {
+		if credentialUuid, ok := credentialUuids[cs.Uuid]; ok {
+			m[cs.Uuid] = byUuid[credentialUuid]
+		} else {
+			m[cs.Uuid] = defaultCredential
+		}
+	}
+	return m, nil
+}
+
+// hasRunningStrategiesOfOtherCredential returns true if any strategy of the user bound to another credential is enabled
+// or opened. NOTE crypto-trading-bot-engine trades all the strategies by users.exchange_api_key, so changing the default
+// credential would move their orders to another account than the one closing them
+func (ctl *Controller) hasRunningStrategiesOfOtherCredential(userUuid string, credentialUuid string) (bool, error) {
+	strategies, _, err := ctl.db.GetContractStrategiesByUser(userUuid)
+	if err != nil {
+		return false, err
+	}
+	credentialByStrategy, err := ctl.getCredentialsByStrategies(userUuid, strategies)
+	if err != nil {
+		return false, err
+	}
+	for _, cs := range strategies {
+		if cs.Enabled == 0 && contract.Status(cs.PositionStatus) == contract.CLOSED {
+			continue
+		}
+		ec := credentialByStrategy[cs.Uuid]
+		if ec != nil && exchangeinfo.IsPaper(ec.Exchange) {
+			continue
+		}
+		if ec == nil || ec.Uuid != credentialUuid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isStrategyCredential returns true if strategies can be created with the credential, i.e. the default credential or
+// the paper accounts if paper trading is enabled. NOTE choosing the other credentials per strategy is blocked until
+// crypto-trading-bot-engine reads contract_strategy_credentials, it opens positions by users.exchange_api_key only
+func (ctl *Controller) isStrategyCredential(ec *model.ExchangeCredential) bool {
+	if exchangeinfo.IsPaper(ec.Exchange) {
+		return ctl.paperPrices != nil
+	}
+	return ec.IsDefault
+}
+
+// validateStrategyCredential pins the default credential if it's not chosen, so that the strategy won't be moved to
+// another account when the default is changed, returns nil if the user hasn't had any credential
+func (ctl *Controller) validateStrategyCredential(userUuid string, credentialUuid string) (*model.ExchangeCredential, error) {
+	if credentialUuid != "" {
+		credential, err := ctl.model.GetExchangeCredentialByUuidByUser(credentialUuid, userUuid)
+		if err != nil {
+			return nil, errors.New("credential_uuid is invalid")
+		}
+		if !ctl.isStrategyCredential(credential) {
+			return nil, errors.New("策略只能使用預設 API Key 或模擬帳戶")
+		}
+		return credential, nil
+	}
+
+	credentials, _, err := ctl.model.GetExchangeCredentialsByUser(userUuid)
+	if err != nil {
+		ctl.log.Println("[ERROR] validateStrategyCredential err:", err)
+		return nil, errors.New("Internal error")
+	}
+	for i := range credentials {
+		if credentials[i].IsDefault {
+			return &credentials[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -10,8 +10,11 @@ require (
 	github.com/leekchan/accounting v1.0.0
 	github.com/sethvargo/go-password v0.2.0
 	github.com/shopspring/decimal v1.2.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/spf13/viper v1.9.0
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
 	gorm.io/datatypes v1.0.2
+	gorm.io/gorm v1.21.15
 )
 
 require (
@@ -47,14 +50,12 @@ require (
 	github.com/subosito/gotenv v1.2.0 // indirect
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
 	github.com/ugorji/go/codec v1.1.7 // indirect
-	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5 // indirect
 	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf // indirect
 	golang.org/x/text v0.3.6 // indirect
 	google.golang.org/protobuf v1.27.1 // indirect
 	gopkg.in/ini.v1 v1.63.2 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gorm.io/driver/mysql v1.1.2 // indirect
-	gorm.io/gorm v1.21.15 // indirect
 )
 
 replace crypto-trading-bot-engine => ../crypto-trading-bot-engine
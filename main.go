@@ -27,8 +27,19 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	r := gin.Default()
 	controller := controller.InitController(l)
+
+	// Re-encrypt the stored secrets after rotating AES key, e.g. `./crypto-trading-bot-api rewrap_keys`
+	if len(os.Args) > 1 && os.Args[1] == "rewrap_keys" {
+		if err := controller.RewrapEncryptedData(); err != nil {
+			l.Fatal(err)
+		}
+		return
+	}
+
+	controller.RunWorkers()
+
+	r := gin.Default()
 	setRouter(r, controller)
 
 	if viper.GetString("ENV") == "prod" {
@@ -0,0 +1,17 @@
+CREATE TABLE `api_tokens` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `uuid` varchar(36) NOT NULL,
+  `user_uuid` varchar(36) NOT NULL,
+  `name` varchar(100) NOT NULL,
+  `token_hash` char(64) NOT NULL COMMENT 'sha256 of the token',
+  `scopes` varchar(255) NOT NULL COMMENT 'comma-separated, e.g. read,trade',
+  `expires_at` datetime DEFAULT NULL,
+  `last_used_at` datetime DEFAULT NULL,
+  `revoked_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uuid` (`uuid`),
+  UNIQUE KEY `token_hash` (`token_hash`),
+  KEY `user_uuid` (`user_uuid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,22 @@
+CREATE TABLE `user_auth_settings` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_uuid` varchar(36) NOT NULL,
+  `second_factor` varchar(20) NOT NULL DEFAULT 'telegram' COMMENT 'telegram, totp or both',
+  `totp_secret` varchar(255) NOT NULL DEFAULT '' COMMENT 'encrypted by AES',
+  `totp_enabled_at` datetime DEFAULT NULL,
+  `totp_last_step` bigint NOT NULL DEFAULT 0,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `user_uuid` (`user_uuid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `user_recovery_codes` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_uuid` varchar(36) NOT NULL,
+  `code_hash` char(64) NOT NULL,
+  `used_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `user_uuid` (`user_uuid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
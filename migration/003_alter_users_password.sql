@@ -0,0 +1,2 @@
+-- argon2id hashes in PHC string format are longer than the legacy sha256 hex
+ALTER TABLE `users` MODIFY `password` varchar(255) NOT NULL DEFAULT '';
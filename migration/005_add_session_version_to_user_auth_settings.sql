@@ -0,0 +1 @@
+ALTER TABLE `user_auth_settings` ADD `session_version` bigint NOT NULL DEFAULT 0 COMMENT 'signed in session cookies' AFTER `totp_last_step`;
@@ -0,0 +1,17 @@
+CREATE TABLE `user_invites` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `uuid` varchar(36) NOT NULL,
+  `token_hash` char(64) NOT NULL COMMENT 'sha256 of the token in the invite link',
+  `role` bigint NOT NULL DEFAULT 0,
+  `telegram_chat_id` bigint NOT NULL DEFAULT 0 COMMENT '0 means filled in by the invitee',
+  `created_by` varchar(36) NOT NULL,
+  `user_uuid` varchar(36) DEFAULT NULL COMMENT 'the user created by this invite',
+  `expires_at` datetime NOT NULL,
+  `used_at` datetime DEFAULT NULL,
+  `revoked_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uuid` (`uuid`),
+  UNIQUE KEY `token_hash` (`token_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1 @@
+ALTER TABLE `user_auth_settings` ADD `disabled_at` datetime DEFAULT NULL COMMENT 'disabled by admin' AFTER `session_version`;
@@ -0,0 +1,18 @@
+CREATE TABLE `user_profiles` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_uuid` varchar(36) NOT NULL,
+  `timezone` varchar(64) NOT NULL DEFAULT '' COMMENT 'IANA name, empty means the server timezone',
+  `notify_entry` tinyint(1) NOT NULL DEFAULT 1 COMMENT 'notification preferences, read by crypto-trading-bot-engine',
+  `notify_stop_loss` tinyint(1) NOT NULL DEFAULT 1,
+  `notify_take_profit` tinyint(1) NOT NULL DEFAULT 1,
+  `notify_error` tinyint(1) NOT NULL DEFAULT 1,
+  `pending_telegram_chat_id` bigint NOT NULL DEFAULT 0 COMMENT 'saved to users.telegram_chat_id once verified',
+  `telegram_code_hash` char(64) NOT NULL DEFAULT '',
+  `telegram_code_attempts` int NOT NULL DEFAULT 0,
+  `telegram_code_sent_at` datetime DEFAULT NULL,
+  `telegram_code_expires_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `user_uuid` (`user_uuid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,12 @@
+CREATE TABLE `user_audit_logs` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_uuid` varchar(36) NOT NULL COMMENT 'the user being changed',
+  `actor_uuid` varchar(36) NOT NULL COMMENT 'the user making the change, e.g. admin',
+  `action` varchar(64) NOT NULL,
+  `detail` json DEFAULT NULL,
+  `ip` varchar(45) NOT NULL DEFAULT '',
+  `user_agent` varchar(255) NOT NULL DEFAULT '',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `user_uuid` (`user_uuid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
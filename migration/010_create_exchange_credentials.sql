@@ -0,0 +1,27 @@
+CREATE TABLE `exchange_credentials` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `uuid` varchar(36) NOT NULL,
+  `user_uuid` varchar(36) NOT NULL,
+  `label` varchar(64) NOT NULL,
+  `exchange` varchar(20) NOT NULL,
+  `subaccount` varchar(64) NOT NULL DEFAULT '',
+  `encrypted_data` text NOT NULL COMMENT 'encrypted by AES, same format as users.exchange_api_key',
+  `is_default` tinyint(1) NOT NULL DEFAULT 0 COMMENT 'copied to users.exchange_api_key for the strategies without credential',
+  `last_verified_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uuid` (`uuid`),
+  UNIQUE KEY `user_uuid_label` (`user_uuid`, `label`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `contract_strategy_credentials` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `strategy_uuid` varchar(36) NOT NULL,
+  `credential_uuid` varchar(36) NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `strategy_uuid` (`strategy_uuid`),
+  KEY `credential_uuid` (`credential_uuid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,6 @@
+ALTER TABLE `exchange_credentials`
+  ADD `verify_status` varchar(20) NOT NULL DEFAULT '' COMMENT 'ok, warning or failed, empty if never verified' AFTER `is_default`,
+  ADD `verify_message` varchar(255) NOT NULL DEFAULT '' AFTER `verify_status`,
+  ADD `can_trade` tinyint(1) NOT NULL DEFAULT 0 AFTER `verify_message`,
+  ADD `can_withdraw` tinyint(1) NOT NULL DEFAULT 0 AFTER `can_trade`,
+  ADD `ip_restricted` tinyint(1) DEFAULT NULL COMMENT 'NULL if the exchange does not tell' AFTER `can_withdraw`;
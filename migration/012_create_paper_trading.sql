@@ -0,0 +1,43 @@
+CREATE TABLE `paper_accounts` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `credential_uuid` varchar(36) NOT NULL COMMENT 'exchange_credentials of PAPER_* exchange',
+  `initial_balance` decimal(20,8) NOT NULL,
+  `balance` decimal(20,8) NOT NULL COMMENT 'realized, fees are deducted',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `credential_uuid` (`credential_uuid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `paper_positions` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `credential_uuid` varchar(36) NOT NULL,
+  `symbol` varchar(32) NOT NULL,
+  `side` tinyint NOT NULL COMMENT '0: short, 1: long',
+  `size` decimal(20,8) NOT NULL,
+  `entry_price` decimal(20,8) NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `credential_uuid_symbol` (`credential_uuid`, `symbol`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `paper_orders` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'order id returned to the caller',
+  `credential_uuid` varchar(36) NOT NULL,
+  `symbol` varchar(32) NOT NULL,
+  `side` tinyint NOT NULL COMMENT 'side of the order, 0: sell, 1: buy',
+  `type` varchar(10) NOT NULL COMMENT 'market or stop',
+  `size` decimal(20,8) NOT NULL,
+  `trigger_price` decimal(20,8) NOT NULL DEFAULT 0,
+  `fill_price` decimal(20,8) NOT NULL DEFAULT 0,
+  `fee` decimal(20,8) NOT NULL DEFAULT 0,
+  `realized_pnl` decimal(20,8) NOT NULL DEFAULT 0,
+  `status` varchar(10) NOT NULL COMMENT 'open, filled or cancelled',
+  `filled_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `credential_uuid_status` (`credential_uuid`, `status`),
+  KEY `status_type` (`status`, `type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
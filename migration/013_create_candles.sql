@@ -0,0 +1,15 @@
+CREATE TABLE `candles` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `exchange` varchar(20) NOT NULL,
+  `symbol` varchar(32) NOT NULL,
+  `resolution` int unsigned NOT NULL COMMENT 'seconds, e.g. 3600 of 1h candles',
+  `open_time` datetime NOT NULL,
+  `open` decimal(20,8) NOT NULL,
+  `high` decimal(20,8) NOT NULL,
+  `low` decimal(20,8) NOT NULL,
+  `close` decimal(20,8) NOT NULL,
+  `volume` decimal(30,8) NOT NULL DEFAULT 0,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `exchange_symbol_resolution_open_time` (`exchange`, `symbol`, `resolution`, `open_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,11 @@
+CREATE TABLE `trailing_stops` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `strategy_uuid` varchar(36) NOT NULL,
+  `position_at` datetime NOT NULL COMMENT 'last_position_at of the strategy, the state is reset for a new position',
+  `peak_price` decimal(20,8) NOT NULL COMMENT 'highest price of long, lowest price of short since activated',
+  `stop_price` decimal(20,8) NOT NULL DEFAULT 0 COMMENT 'price of the stop-loss order placed by trailing, 0 if not moved yet',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `strategy_uuid` (`strategy_uuid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+CREATE TABLE `symbol_rules` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `exchange` varchar(20) NOT NULL COMMENT 'underlying exchange, shared by the paper accounts',
+  `symbol` varchar(32) NOT NULL,
+  `tick_size` decimal(20,8) NOT NULL DEFAULT 0 COMMENT 'prices must be multiples of it, 0 if unchecked',
+  `min_size` decimal(20,8) NOT NULL DEFAULT 0 COMMENT 'minimum order size in the base asset, 0 if unchecked',
+  `max_leverage` int unsigned NOT NULL DEFAULT 0 COMMENT '0 if unchecked',
+  `price_band_percent` decimal(10,4) NOT NULL DEFAULT 0 COMMENT 'max distance of the prices from the market price, e.g. 0.1 means 10%, 0 if unchecked',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `exchange_symbol` (`exchange`, `symbol`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
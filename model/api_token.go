@@ -0,0 +1,80 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	API_TOKEN_SCOPE_READ  = "read"
+	API_TOKEN_SCOPE_TRADE = "trade"
+	API_TOKEN_SCOPE_ADMIN = "admin"
+)
+
+type ApiToken struct {
+	Id         int64
+	Uuid       string
+	UserUuid   string
+	Name       string
+	TokenHash  string
+	Scopes     string // comma-separated, e.g. "read,trade"
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (db *DB) CreateApiToken(t ApiToken) (int64, int64, error) {
+	result := db.GormDB.Create(&t)
+	return t.Id, result.RowsAffected, result.Error
+}
+
+func (db *DB) GetApiTokensByUser(userUuid string) ([]ApiToken, int64, error) {
+	var tokens []ApiToken
+	result := db.GormDB.Where("user_uuid = ?", userUuid).Order("id DESC").Find(&tokens)
+	return tokens, result.RowsAffected, result.Error
+}
+
+func (db *DB) GetApiTokenByHash(tokenHash string) (*ApiToken, error) {
+	var t ApiToken
+	result := db.GormDB.Where("token_hash = ?", tokenHash).First(&t)
+	return &t, result.Error
+}
+
+func (db *DB) GetApiTokenByUuidByUser(uuid string, userUuid string) (*ApiToken, error) {
+	var t ApiToken
+	result := db.GormDB.Where("uuid = ? AND user_uuid = ?", uuid, userUuid).First(&t)
+	return &t, result.Error
+}
+
+func (db *DB) UpdateApiToken(uuid string, data map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(&ApiToken{}).Where("uuid = ?", uuid).Updates(data)
+	return result.RowsAffected, result.Error
+}
+
+func (t *ApiToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ApiToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+func (t *ApiToken) IsActive() bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+		return false
+	}
+	return true
+}
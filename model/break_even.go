@@ -0,0 +1,11 @@
+package model
+
+// GetBreakEvenStrategyUuids returns the enabled strategies whose position is opened with the break-even rule,
+// NOTE it reads contract_strategies which is owned by crypto-trading-bot-engine
+func (db *DB) GetBreakEvenStrategyUuids() ([]string, error) {
+	var uuids []string
+	result := db.GormDB.Table("contract_strategies").
+		Where("enabled = 1 AND position_status = 1 AND JSON_EXTRACT(params, '$.stop_loss_order.break_even') IS NOT NULL").
+		Pluck("uuid", &uuids)
+	return uuids, result.Error
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm/clause"
+)
+
+// Candle is the historical OHLCV imported for backtesting, see util/backtest
+type Candle struct {
+	Id         int64
+	Exchange   string
+	Symbol     string
+	Resolution int64
+	OpenTime   time.Time
+	Open       decimal.Decimal
+	High       decimal.Decimal
+	Low        decimal.Decimal
+	Close      decimal.Decimal
+	Volume     decimal.Decimal
+	CreatedAt  time.Time
+}
+
+// SaveCandles overwrites the candles of the same open time
+func (db *DB) SaveCandles(candles []Candle) (int64, error) {
+	result := db.GormDB.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"open", "high", "low", "close", "volume"}),
+	}).CreateInBatches(candles, 500)
+	return result.RowsAffected, result.Error
+}
+
+func (db *DB) GetCandles(exchange string, symbol string, resolution int64, from time.Time, to time.Time, limit int) ([]Candle, int64, error) {
+	var candles []Candle
+	result := db.GormDB.Where("exchange = ? AND symbol = ? AND resolution = ? AND open_time >= ? AND open_time <= ?", exchange, symbol, resolution, from, to).
+		Order("open_time ASC").
+		Limit(limit).
+		Find(&candles)
+	return candles, result.RowsAffected, result.Error
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExchangeCredential is an API key of the exchange, a user can have several, e.g. one per subaccount
+type ExchangeCredential struct {
+	Id             int64
+	Uuid           string
+	UserUuid       string
+	Label          string
+	Exchange       string
+	Subaccount     string
+	EncryptedData  string // same format as users.exchange_api_key, so that it can be passed to exchange.NewExchange
+	IsDefault      bool   // copied to users.exchange_api_key, used by the strategies without credential
+	VerifyStatus   string // result of the last verification, empty if it's never verified
+	VerifyMessage  string
+	CanTrade       bool
+	CanWithdraw    bool
+	IpRestricted   *bool // nil if the exchange doesn't tell
+	LastVerifiedAt *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// ContractStrategyCredential is the credential chosen by the strategy, NOTE contract_strategies is owned by crypto-trading-bot-engine
+type ContractStrategyCredential struct {
+	Id             int64
+	StrategyUuid   string
+	CredentialUuid string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (db *DB) CreateExchangeCredential(ec ExchangeCredential) (int64, int64, error) {
+	result := db.GormDB.Create(&ec)
+	return ec.Id, result.RowsAffected, result.Error
+}
+
+// GetExchangeCredentials lists the credentials of all users, e.g. for rewrapping the encrypted data
+func (db *DB) GetExchangeCredentials() ([]ExchangeCredential, int64, error) {
+	var credentials []ExchangeCredential
+	result := db.GormDB.Order("id ASC").Find(&credentials)
+	return credentials, result.RowsAffected, result.Error
+}
+
+func (db *DB) GetExchangeCredentialsByUser(userUuid string) ([]ExchangeCredential, int64, error) {
+	var credentials []ExchangeCredential
+	result := db.GormDB.Where("user_uuid = ?", userUuid).Order("id ASC").Find(&credentials)
+	return credentials, result.RowsAffected, result.Error
+}
+
+func (db *DB) GetExchangeCredentialByUuidByUser(uuid string, userUuid string) (*ExchangeCredential, error) {
+	var ec ExchangeCredential
+	result := db.GormDB.Where("uuid = ? AND user_uuid = ?", uuid, userUuid).First(&ec)
+	return &ec, result.Error
+}
+
+func (db *DB) UpdateExchangeCredential(uuid string, data map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(&ExchangeCredential{}).Where("uuid = ?", uuid).Updates(data)
+	return result.RowsAffected, result.Error
+}
+
+func (db *DB) DeleteExchangeCredential(uuid string) (int64, error) {
+	result := db.GormDB.Where("uuid = ?", uuid).Delete(&ExchangeCredential{})
+	return result.RowsAffected, result.Error
+}
+
+// SetDefaultExchangeCredential unsets the other credentials of the user
+func (db *DB) SetDefaultExchangeCredential(uuid string, userUuid string) error {
+	return db.GormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ExchangeCredential{}).Where("user_uuid = ? AND uuid != ?", userUuid, uuid).Update("is_default", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&ExchangeCredential{}).Where("user_uuid = ? AND uuid = ?", userUuid, uuid).Update("is_default", true).Error
+	})
+}
+
+// GetExchangeCredentialByStrategy returns gorm.ErrRecordNotFound if the strategy uses the default credential
+func (db *DB) GetExchangeCredentialByStrategy(strategyUuid string, userUuid string) (*ExchangeCredential, error) {
+	var ec ExchangeCredential
+	result := db.GormDB.Joins("JOIN contract_strategy_credentials csc ON csc.credential_uuid = exchange_credentials.uuid").
+		Where("csc.strategy_uuid = ? AND exchange_credentials.user_uuid = ?", strategyUuid, userUuid).
+		First(&ec)
+	return &ec, result.Error
+}
+
+// GetStrategyCredentialUuids returns the map of strategy uuid to credential uuid
+func (db *DB) GetStrategyCredentialUuids(strategyUuids []string) (map[string]string, error) {
+	var rows []ContractStrategyCredential
+	result := db.GormDB.Where("strategy_uuid IN ?", strategyUuids).Find(&rows)
+	m := make(map[string]string)
+	for _, r := range rows {
+		m[r.StrategyUuid] = r.CredentialUuid
+	}
+	return m, result.Error
+}
+
+func (db *DB) CountStrategiesByExchangeCredential(credentialUuid string) (int64, error) {
+	var count int64
+	result := db.GormDB.Model(&ContractStrategyCredential{}).Where("credential_uuid = ?", credentialUuid).Count(&count)
+	return count, result.Error
+}
+
+func (db *DB) CreateContractStrategyCredential(strategyUuid string, credentialUuid string) error {
+	return db.GormDB.Create(&ContractStrategyCredential{StrategyUuid: strategyUuid, CredentialUuid: credentialUuid}).Error
+}
+
+func (db *DB) DeleteContractStrategyCredential(strategyUuid string) error {
+	return db.GormDB.Where("strategy_uuid = ?", strategyUuid).Delete(&ContractStrategyCredential{}).Error
+}
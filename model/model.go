@@ -0,0 +1,16 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+// DB for the tables owned by this site, the shared tables (users, contract_strategies, ...) are in crypto-trading-bot-engine/db
+type DB struct {
+	GormDB *gorm.DB
+}
+
+func NewDB(gormDB *gorm.DB) *DB {
+	return &DB{
+		GormDB: gormDB,
+	}
+}
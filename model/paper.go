@@ -0,0 +1,136 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	PAPER_ORDER_MARKET = "market"
+	PAPER_ORDER_STOP   = "stop"
+
+	PAPER_ORDER_OPEN      = "open"
+	PAPER_ORDER_FILLED    = "filled"
+	PAPER_ORDER_CANCELLED = "cancelled"
+)
+
+// PaperAccount is the simulated balance of a paper credential, see util/paper
+type PaperAccount struct {
+	Id             int64
+	CredentialUuid string
+	InitialBalance decimal.Decimal
+	Balance        decimal.Decimal
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type PaperPosition struct {
+	Id             int64
+	CredentialUuid string
+	Symbol         string
+	Side           int64
+	Size           decimal.Decimal
+	EntryPrice     decimal.Decimal
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type PaperOrder struct {
+	Id             int64
+	CredentialUuid string
+	Symbol         string
+	Side           int64 // side of the order, not the position
+	Type           string
+	Size           decimal.Decimal
+	TriggerPrice   decimal.Decimal
+	FillPrice      decimal.Decimal
+	Fee            decimal.Decimal
+	RealizedPnl    decimal.Decimal
+	Status         string
+	FilledAt       *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (db *DB) CreatePaperAccount(pa PaperAccount) (int64, int64, error) {
+	result := db.GormDB.Create(&pa)
+	return pa.Id, result.RowsAffected, result.Error
+}
+
+func (db *DB) GetPaperAccount(credentialUuid string) (*PaperAccount, error) {
+	var pa PaperAccount
+	result := db.GormDB.Where("credential_uuid = ?", credentialUuid).First(&pa)
+	return &pa, result.Error
+}
+
+// DeletePaperAccount deletes the positions and orders as well
+func (db *DB) DeletePaperAccount(credentialUuid string) error {
+	return db.GormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("credential_uuid = ?", credentialUuid).Delete(&PaperOrder{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("credential_uuid = ?", credentialUuid).Delete(&PaperPosition{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("credential_uuid = ?", credentialUuid).Delete(&PaperAccount{}).Error
+	})
+}
+
+// GetPaperPosition returns gorm.ErrRecordNotFound if there's no position
+func (db *DB) GetPaperPosition(credentialUuid string, symbol string) (*PaperPosition, error) {
+	var pp PaperPosition
+	result := db.GormDB.Where("credential_uuid = ? AND symbol = ?", credentialUuid, symbol).First(&pp)
+	return &pp, result.Error
+}
+
+func (db *DB) GetPaperPositions(credentialUuid string) ([]PaperPosition, int64, error) {
+	var positions []PaperPosition
+	result := db.GormDB.Where("credential_uuid = ?", credentialUuid).Order("id ASC").Find(&positions)
+	return positions, result.RowsAffected, result.Error
+}
+
+func (db *DB) CreatePaperOrder(po PaperOrder) (int64, int64, error) {
+	result := db.GormDB.Create(&po)
+	return po.Id, result.RowsAffected, result.Error
+}
+
+func (db *DB) GetPaperOrderByCredential(id int64, credentialUuid string) (*PaperOrder, error) {
+	var po PaperOrder
+	result := db.GormDB.Where("id = ? AND credential_uuid = ?", id, credentialUuid).First(&po)
+	return &po, result.Error
+}
+
+func (db *DB) UpdatePaperOrder(id int64, data map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(&PaperOrder{}).Where("id = ?", id).Updates(data)
+	return result.RowsAffected, result.Error
+}
+
+// GetOpenPaperStopOrders returns the stop orders to be triggered, of all credentials if credentialUuid is empty
+func (db *DB) GetOpenPaperStopOrders(credentialUuid string) ([]PaperOrder, int64, error) {
+	var orders []PaperOrder
+	tx := db.GormDB.Where("status = ? AND type = ?", PAPER_ORDER_OPEN, PAPER_ORDER_STOP)
+	if credentialUuid != "" {
+		tx = tx.Where("credential_uuid = ?", credentialUuid)
+	}
+	result := tx.Order("id ASC").Find(&orders)
+	return orders, result.RowsAffected, result.Error
+}
+
+// SavePaperFill saves the filled order (created if it's new), the position (deleted if the size is zero) and the balance
+func (db *DB) SavePaperFill(po *PaperOrder, pp *PaperPosition, balance decimal.Decimal) error {
+	return db.GormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(po).Error; err != nil {
+			return err
+		}
+		if pp.Size.IsZero() {
+			if err := tx.Where("credential_uuid = ? AND symbol = ?", pp.CredentialUuid, pp.Symbol).Delete(&PaperPosition{}).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Save(pp).Error; err != nil {
+			return err
+		}
+		return tx.Model(&PaperAccount{}).Where("credential_uuid = ?", po.CredentialUuid).Update("balance", balance).Error
+	})
+}
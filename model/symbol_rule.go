@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// SymbolRule is the order rules of the exchange for the symbol, checked before the strategy is saved, see
+// controller/pretrade.go. Zero values are unchecked
+type SymbolRule struct {
+	Id               int64
+	Exchange         string
+	Symbol           string
+	TickSize         decimal.Decimal
+	MinSize          decimal.Decimal
+	MaxLeverage      int64
+	PriceBandPercent decimal.Decimal
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func (db *DB) GetSymbolRule(exchange string, symbol string) (*SymbolRule, error) {
+	var rule SymbolRule
+	result := db.GormDB.Where("exchange = ? AND symbol = ?", exchange, symbol).First(&rule)
+	return &rule, result.Error
+}
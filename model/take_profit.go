@@ -0,0 +1,11 @@
+package model
+
+// GetTakeProfitLadderStrategyUuids returns the enabled strategies whose position is opened with the take-profit levels,
+// NOTE it reads contract_strategies which is owned by crypto-trading-bot-engine
+func (db *DB) GetTakeProfitLadderStrategyUuids() ([]string, error) {
+	var uuids []string
+	result := db.GormDB.Table("contract_strategies").
+		Where("enabled = 1 AND position_status = 1 AND JSON_EXTRACT(params, '$.take_profit_order.levels') IS NOT NULL").
+		Pluck("uuid", &uuids)
+	return uuids, result.Error
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm/clause"
+)
+
+// TrailingStop is the state of the trailing stop-loss of an opened strategy, see controller/trailing.go
+type TrailingStop struct {
+	Id           int64
+	StrategyUuid string
+	PositionAt   time.Time
+	PeakPrice    decimal.Decimal
+	StopPrice    decimal.Decimal
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// GetTrailingStopStrategyUuids returns the enabled strategies whose position is opened with the trailing stop-loss,
+// NOTE it reads contract_strategies which is owned by crypto-trading-bot-engine
+func (db *DB) GetTrailingStopStrategyUuids() ([]string, error) {
+	var uuids []string
+	result := db.GormDB.Table("contract_strategies").
+		Where("enabled = 1 AND position_status = 1 AND JSON_EXTRACT(params, '$.stop_loss_order.trailing') IS NOT NULL").
+		Pluck("uuid", &uuids)
+	return uuids, result.Error
+}
+
+func (db *DB) GetTrailingStop(strategyUuid string) (*TrailingStop, error) {
+	var ts TrailingStop
+	result := db.GormDB.Where("strategy_uuid = ?", strategyUuid).First(&ts)
+	return &ts, result.Error
+}
+
+// SaveTrailingStop creates or overwrites the state of the strategy
+func (db *DB) SaveTrailingStop(ts *TrailingStop) (int64, error) {
+	result := db.GormDB.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"position_at", "peak_price", "stop_price"}),
+	}).Create(ts)
+	return result.RowsAffected, result.Error
+}
+
+func (db *DB) DeleteTrailingStop(strategyUuid string) (int64, error) {
+	result := db.GormDB.Where("strategy_uuid = ?", strategyUuid).Delete(&TrailingStop{})
+	return result.RowsAffected, result.Error
+}
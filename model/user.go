@@ -0,0 +1,17 @@
+package model
+
+import (
+	engineDb "crypto-trading-bot-engine/db"
+)
+
+// GetUsers lists all the users, NOTE the users table is owned by crypto-trading-bot-engine
+func (db *DB) GetUsers() ([]engineDb.User, int64, error) {
+	var users []engineDb.User
+	result := db.GormDB.Order("id ASC").Find(&users)
+	return users, result.RowsAffected, result.Error
+}
+
+func (db *DB) CreateUser(u engineDb.User) (int64, int64, error) {
+	result := db.GormDB.Create(&u)
+	return u.Id, result.RowsAffected, result.Error
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// Actions of audit logs
+const (
+	AUDIT_ACTION_USERNAME_UPDATE         = "username.update"
+	AUDIT_ACTION_TELEGRAM_CHAT_ID_UPDATE = "telegram_chat_id.update"
+	AUDIT_ACTION_TIMEZONE_UPDATE         = "timezone.update"
+	AUDIT_ACTION_NOTIFICATIONS_UPDATE    = "notifications.update"
+	AUDIT_ACTION_ROLE_UPDATE             = "role.update"
+	AUDIT_ACTION_STATUS_UPDATE           = "status.update"
+)
+
+type UserAuditLog struct {
+	Id        int64
+	UserUuid  string
+	ActorUuid string
+	Action    string
+	Detail    datatypes.JSONMap // e.g. {"from": "alice", "to": "bob"}
+	Ip        string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+func (db *DB) CreateUserAuditLog(l UserAuditLog) (int64, int64, error) {
+	result := db.GormDB.Create(&l)
+	return l.Id, result.RowsAffected, result.Error
+}
+
+// GetUserAuditLogsByUser returns the latest logs of the user
+func (db *DB) GetUserAuditLogsByUser(userUuid string, limit int) ([]UserAuditLog, int64, error) {
+	var logs []UserAuditLog
+	result := db.GormDB.Where("user_uuid = ?", userUuid).Order("id DESC").Limit(limit).Find(&logs)
+	return logs, result.RowsAffected, result.Error
+}
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Second factor of login
+const (
+	SECOND_FACTOR_TELEGRAM = "telegram"
+	SECOND_FACTOR_TOTP     = "totp"
+	SECOND_FACTOR_BOTH     = "both"
+)
+
+type UserAuthSetting struct {
+	Id             int64
+	UserUuid       string
+	SecondFactor   string
+	TotpSecret     string // encrypted by AES
+	TotpEnabledAt  *time.Time
+	TotpLastStep   int64 // the last accepted time step, to prevent the same code from being used twice
+	SessionVersion int64 // signed in session cookies, increasing it invalidates all the sessions of the user
+	DisabledAt     *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type UserRecoveryCode struct {
+	Id        int64
+	UserUuid  string
+	CodeHash  string
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// NewUserAuthSetting returns the default setting for the users who haven't set it
+func NewUserAuthSetting(userUuid string) *UserAuthSetting {
+	return &UserAuthSetting{
+		UserUuid:     userUuid,
+		SecondFactor: SECOND_FACTOR_TELEGRAM,
+	}
+}
+
+func (s *UserAuthSetting) TotpEnabled() bool {
+	return s.TotpEnabledAt != nil
+}
+
+func (s *UserAuthSetting) IsDisabled() bool {
+	return s.DisabledAt != nil
+}
+
+func (s *UserAuthSetting) RequiresTelegram() bool {
+	return s.SecondFactor == SECOND_FACTOR_TELEGRAM || s.SecondFactor == SECOND_FACTOR_BOTH
+}
+
+func (s *UserAuthSetting) RequiresTotp() bool {
+	return s.SecondFactor == SECOND_FACTOR_TOTP || s.SecondFactor == SECOND_FACTOR_BOTH
+}
+
+func (db *DB) GetUserAuthSettingByUser(userUuid string) (*UserAuthSetting, error) {
+	var s UserAuthSetting
+	result := db.GormDB.Where("user_uuid = ?", userUuid).First(&s)
+	return &s, result.Error
+}
+
+// GetUserAuthSettingsWithTotpSecret lists the settings which have encrypted TOTP secret
+func (db *DB) GetUserAuthSettingsWithTotpSecret() ([]UserAuthSetting, int64, error) {
+	var settings []UserAuthSetting
+	result := db.GormDB.Where("totp_secret != ''").Order("id ASC").Find(&settings)
+	return settings, result.RowsAffected, result.Error
+}
+
+// SaveUserAuthSetting creates the setting if it doesn't exist
+func (db *DB) SaveUserAuthSetting(userUuid string, data map[string]interface{}) error {
+	var s UserAuthSetting
+	result := db.GormDB.Where(UserAuthSetting{UserUuid: userUuid}).
+		Attrs(UserAuthSetting{SecondFactor: SECOND_FACTOR_TELEGRAM}).
+		Assign(data).
+		FirstOrCreate(&s)
+	return result.Error
+}
+
+// GetDisabledUserUuids returns the set of disabled users
+func (db *DB) GetDisabledUserUuids() (map[string]bool, error) {
+	var uuids []string
+	result := db.GormDB.Model(&UserAuthSetting{}).Where("disabled_at IS NOT NULL").Pluck("user_uuid", &uuids)
+	set := make(map[string]bool)
+	for _, uuid := range uuids {
+		set[uuid] = true
+	}
+	return set, result.Error
+}
+
+// IncrUserSessionVersion creates the setting if it doesn't exist
+func (db *DB) IncrUserSessionVersion(userUuid string) error {
+	var s UserAuthSetting
+	result := db.GormDB.Where(UserAuthSetting{UserUuid: userUuid}).
+		Attrs(UserAuthSetting{SecondFactor: SECOND_FACTOR_TELEGRAM}).
+		FirstOrCreate(&s)
+	if result.Error != nil {
+		return result.Error
+	}
+	result = db.GormDB.Model(&UserAuthSetting{}).
+		Where("user_uuid = ?", userUuid).
+		Update("session_version", gorm.Expr("session_version + 1"))
+	return result.Error
+}
+
+// UpdateTotpLastStep returns false if the step has been used
+func (db *DB) UpdateTotpLastStep(userUuid string, step int64) (bool, error) {
+	result := db.GormDB.Model(&UserAuthSetting{}).
+		Where("user_uuid = ? AND totp_last_step < ?", userUuid, step).
+		Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReplaceUserRecoveryCodes removes all the existing codes of the user
+func (db *DB) ReplaceUserRecoveryCodes(userUuid string, codeHashes []string) error {
+	return db.GormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_uuid = ?", userUuid).Delete(&UserRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, h := range codeHashes {
+			if err := tx.Create(&UserRecoveryCode{UserUuid: userUuid, CodeHash: h}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseUserRecoveryCode returns false if the code doesn't exist or has been used
+func (db *DB) UseUserRecoveryCode(userUuid string, codeHash string) (bool, error) {
+	result := db.GormDB.Model(&UserRecoveryCode{}).
+		Where("user_uuid = ? AND code_hash = ? AND used_at IS NULL", userUuid, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func (db *DB) CountUnusedUserRecoveryCodes(userUuid string) (int64, error) {
+	var count int64
+	result := db.GormDB.Model(&UserRecoveryCode{}).Where("user_uuid = ? AND used_at IS NULL", userUuid).Count(&count)
+	return count, result.Error
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	engineDb "crypto-trading-bot-engine/db"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrUserInviteUnavailable = errors.New("invite has been used, revoked or expired")
+
+type UserInvite struct {
+	Id             int64
+	Uuid           string
+	TokenHash      string
+	Role           int64
+	TelegramChatId int64 // 0 means filled in by the invitee
+	CreatedBy      string
+	UserUuid       *string
+	ExpiresAt      time.Time
+	UsedAt         *time.Time
+	RevokedAt      *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (db *DB) CreateUserInvite(i UserInvite) (int64, int64, error) {
+	result := db.GormDB.Create(&i)
+	return i.Id, result.RowsAffected, result.Error
+}
+
+func (db *DB) GetUserInvites() ([]UserInvite, int64, error) {
+	var invites []UserInvite
+	result := db.GormDB.Order("id DESC").Find(&invites)
+	return invites, result.RowsAffected, result.Error
+}
+
+func (db *DB) GetUserInviteByHash(tokenHash string) (*UserInvite, error) {
+	var i UserInvite
+	result := db.GormDB.Where("token_hash = ?", tokenHash).First(&i)
+	return &i, result.Error
+}
+
+func (db *DB) UpdateUserInvite(uuid string, data map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(&UserInvite{}).Where("uuid = ?", uuid).Updates(data)
+	return result.RowsAffected, result.Error
+}
+
+// CreateUserByInvite marks the invite as used and creates the user in a transaction,
+// returns ErrUserInviteUnavailable if the invite can't be used
+func (db *DB) CreateUserByInvite(inviteUuid string, user engineDb.User) error {
+	return db.GormDB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&UserInvite{}).
+			Where("uuid = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?", inviteUuid, time.Now()).
+			Updates(map[string]interface{}{
+				"used_at":   time.Now(),
+				"user_uuid": user.Uuid,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrUserInviteUnavailable
+		}
+		return tx.Create(&user).Error
+	})
+}
+
+func (i *UserInvite) IsAvailable() bool {
+	return i.UsedAt == nil && i.RevokedAt == nil && time.Now().Before(i.ExpiresAt)
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserProfile keeps the preferences which aren't in the users table. NOTE the notify_* columns are for
+// crypto-trading-bot-engine, which sends the trade notifications, they don't take effect until it reads them
+type UserProfile struct {
+	Id                    int64
+	UserUuid              string
+	Timezone              string // IANA name, empty means the server timezone
+	NotifyEntry           bool
+	NotifyStopLoss        bool
+	NotifyTakeProfit      bool
+	NotifyError           bool
+	PendingTelegramChatId int64 // saved to users.telegram_chat_id once verified
+	TelegramCodeHash      string
+	TelegramCodeAttempts  int64
+	TelegramCodeSentAt    *time.Time
+	TelegramCodeExpiresAt *time.Time
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+// NewUserProfile returns the default profile for the users who haven't set it
+func NewUserProfile(userUuid string) *UserProfile {
+	return &UserProfile{
+		UserUuid:         userUuid,
+		NotifyEntry:      true,
+		NotifyStopLoss:   true,
+		NotifyTakeProfit: true,
+		NotifyError:      true,
+	}
+}
+
+// Location falls back to the server timezone if it's not set or invalid
+func (p *UserProfile) Location() *time.Location {
+	if p.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+func (p *UserProfile) HasPendingTelegramChatId() bool {
+	return p.PendingTelegramChatId != 0 && p.TelegramCodeExpiresAt != nil && time.Now().Before(*p.TelegramCodeExpiresAt)
+}
+
+func (db *DB) GetUserProfileByUser(userUuid string) (*UserProfile, error) {
+	var p UserProfile
+	result := db.GormDB.Where("user_uuid = ?", userUuid).First(&p)
+	return &p, result.Error
+}
+
+// SaveUserProfile creates the profile with the default preferences if it doesn't exist
+func (db *DB) SaveUserProfile(userUuid string, data map[string]interface{}) error {
+	var p UserProfile
+	defaults := NewUserProfile(userUuid)
+	result := db.GormDB.Where(UserProfile{UserUuid: userUuid}).
+		Attrs(map[string]interface{}{
+			"notify_entry":       defaults.NotifyEntry,
+			"notify_stop_loss":   defaults.NotifyStopLoss,
+			"notify_take_profit": defaults.NotifyTakeProfit,
+			"notify_error":       defaults.NotifyError,
+		}).
+		Assign(data).
+		FirstOrCreate(&p)
+	return result.Error
+}
+
+// IncrTelegramCodeAttempts returns false if the attempts have reached the limit
+func (db *DB) IncrTelegramCodeAttempts(userUuid string, maxAttempts int64) (bool, error) {
+	result := db.GormDB.Model(&UserProfile{}).
+		Where("user_uuid = ? AND telegram_code_attempts < ?", userUuid, maxAttempts).
+		Update("telegram_code_attempts", gorm.Expr("telegram_code_attempts + 1"))
+	return result.RowsAffected == 1, result.Error
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"time"
+)
+
+// UserSession is the server side record of a login session, the cookie only carries its uuid
+type UserSession struct {
+	Id         int64
+	Uuid       string
+	UserUuid   string
+	Ip         string
+	UserAgent  string
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (db *DB) CreateUserSession(s UserSession) (int64, int64, error) {
+	result := db.GormDB.Create(&s)
+	return s.Id, result.RowsAffected, result.Error
+}
+
+func (db *DB) GetUserSessionByUuid(uuid string) (*UserSession, error) {
+	var s UserSession
+	result := db.GormDB.Where("uuid = ?", uuid).First(&s)
+	return &s, result.Error
+}
+
+// GetActiveUserSessionsByUser returns the sessions which are neither revoked nor expired
+func (db *DB) GetActiveUserSessionsByUser(userUuid string) ([]UserSession, int64, error) {
+	var sessions []UserSession
+	result := db.GormDB.Where("user_uuid = ? AND revoked_at IS NULL AND expires_at > ?", userUuid, time.Now()).Order("last_seen_at DESC").Find(&sessions)
+	return sessions, result.RowsAffected, result.Error
+}
+
+func (db *DB) UpdateUserSession(uuid string, data map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(&UserSession{}).Where("uuid = ?", uuid).Updates(data)
+	return result.RowsAffected, result.Error
+}
+
+func (db *DB) RevokeUserSessionByUser(uuid string, userUuid string) (int64, error) {
+	result := db.GormDB.Model(&UserSession{}).Where("uuid = ? AND user_uuid = ? AND revoked_at IS NULL", uuid, userUuid).Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// RevokeUserSessionsByUser revokes all the sessions of the user except `exceptUuid`, pass empty string to revoke all
+func (db *DB) RevokeUserSessionsByUser(userUuid string, exceptUuid string) (int64, error) {
+	result := db.GormDB.Model(&UserSession{}).Where("user_uuid = ? AND uuid != ? AND revoked_at IS NULL", userUuid, exceptUuid).Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+func (s *UserSession) IsActive() bool {
+	if s.RevokedAt != nil {
+		return false
+	}
+	return time.Now().Before(s.ExpiresAt)
+}
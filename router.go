@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto-trading-bot-api/controller"
+	"html/template"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,46 +13,114 @@ func setRouter(r *gin.Engine, c *controller.Controller) {
 	r.StaticFile("/robots.txt", "assets/robots.txt")
 
 	// Html template
+	r.SetFuncMap(template.FuncMap{
+		"can": controller.HasPermission,
+	})
 	r.LoadHTMLGlob("view/*")
 
+	// CSRF protection for all the routes below
+	r.Use(c.CSRF)
+
 	// Health check
 	r.GET("/ping", c.Ping)
 
+	// Permissions
+	viewStrategy := c.Require(controller.PERM_STRATEGY_VIEW)
+	editStrategy := c.Require(controller.PERM_STRATEGY_EDIT)
+	tradeStrategy := c.Require(controller.PERM_STRATEGY_TRADE)
+	closeStrategy := c.Require(controller.PERM_STRATEGY_CLOSE)
+	manageApiKey := c.Require(controller.PERM_APIKEY_MANAGE)
+	viewEngine := c.Require(controller.PERM_ENGINE_VIEW)
+	manageUser := c.Require(controller.PERM_USER_MANAGE)
+
 	// Release log
-	r.GET("/release_log", c.ReleaseLog)
+	r.GET("/release_log", viewStrategy, c.ReleaseLog)
 
 	// Admin
-	r.GET("/engine", c.Engine)
+	r.GET("/engine", viewEngine, c.Engine)
+	r.GET("/admin/users", manageUser, c.AdminListUsers)
+	r.POST("/admin/users", manageUser, c.AdminCreateUser)
+	r.PATCH("/admin/users/:uuid", manageUser, c.AdminUpdateUser)
+	r.POST("/admin/invites", manageUser, c.AdminCreateInvite)
+	r.DELETE("/admin/invites/:uuid", manageUser, c.AdminRevokeInvite)
+	r.POST("/admin/sessions/revoke", manageUser, c.AdminRevokeSessions)
 
 	// User
 	r.GET("/login", c.LoginPage)
-	r.POST("/login", c.LoginAPI)
-	r.POST("/otp", c.OTP)
+	r.POST("/login", c.LoginRateLimit, c.LoginAPI)
+	r.POST("/otp", c.OTPRateLimit, c.OTP)
 	r.GET("/logout", c.Logout)
-	r.GET("/user/apikey/new", c.NewApiKey)
-	r.POST("/user/apikey/update", c.UpdateApiKey)
-	r.GET("/user/apikey/test", c.TestApiKey)
-	r.DELETE("/user/apikey", c.DeleteApiKey)
+	r.GET("/invite/:token", c.InvitePage)
+	r.POST("/invite/:token", c.LoginRateLimit, c.AcceptInvite)
+	r.GET("/user/credentials", manageApiKey, c.ListCredentials)
+	r.POST("/user/credentials", manageApiKey, c.CreateCredential)
+	r.POST("/user/credentials/paper", manageApiKey, c.CreatePaperCredential)
+	r.DELETE("/user/credentials/:uuid", manageApiKey, c.DeleteCredential)
+	r.POST("/user/credentials/:uuid/default", manageApiKey, c.SetDefaultCredential)
+	r.GET("/user/credentials/:uuid/test", manageApiKey, c.TestCredential)
+	r.GET("/user/apitokens", c.ListApiTokens)
+	r.POST("/user/apitokens", c.CreateApiToken)
+	r.DELETE("/user/apitokens/:uuid", c.RevokeApiToken)
+	r.GET("/user/profile", c.ProfilePage)
+	r.PATCH("/user/profile", c.UpdateProfile)
+	r.POST("/user/profile/telegram", c.SendTelegramCode)
+	r.POST("/user/profile/telegram/verify", c.VerifyTelegramCode)
+	r.GET("/user/security", c.SecurityPage)
+	r.POST("/user/second_factor", c.UpdateSecondFactor)
+	r.POST("/user/totp/setup", c.SetupTotp)
+	r.POST("/user/totp/enable", c.EnableTotp)
+	r.POST("/user/totp/disable", c.DisableTotp)
+	r.POST("/user/recovery_codes", c.RegenerateRecoveryCodes)
+	r.GET("/user/sessions", c.ListSessions)
+	r.DELETE("/user/sessions/:uuid", c.RevokeSession)
+	r.POST("/user/sessions/revoke_others", c.RevokeOtherSessions)
 
 	// Strategy
-	r.GET("/", c.ListStrategies)
-	r.GET("/strategy/new_trendline", c.NewStrategy)
-	r.GET("/strategy/new_limit", c.NewStrategy)
-	r.POST("/strategy", c.CreateStrategy)
-	r.GET("/strategy/:uuid", c.ShowStrategy)
-	r.DELETE("/strategy/:uuid", c.DeleteStrategy)
-	r.GET("/strategy/:uuid/edit_trendline", c.EditTrendline)
-	r.GET("/strategy/:uuid/edit_limit", c.EditLimit)
-	r.PATCH("/strategy/:uuid", c.UpdateStrategy)
-	r.GET("/strategy/:uuid/tpsl/edit", c.EditTpSl)
-	r.PATCH("/strategy/:uuid/tpsl", c.UpdateTpSl)
-	r.PATCH("/strategy/:uuid/orders_details", c.UpdateOrdersDetails)
+	r.GET("/", viewStrategy, c.ListStrategies)
+	r.GET("/strategy/new_trendline", editStrategy, c.NewStrategy)
+	r.GET("/strategy/new_limit", editStrategy, c.NewStrategy)
+	r.GET("/strategy/new_market", editStrategy, c.NewStrategy)
+	r.POST("/strategy", editStrategy, c.CreateStrategy)
+	r.POST("/strategy/backtest", viewStrategy, c.RunBacktest)
+	r.POST("/strategy/trendline_preview", editStrategy, c.PreviewTrendline)
+	r.POST("/strategy/margin", editStrategy, c.CalculateMargin)
+	r.GET("/strategy/:uuid", viewStrategy, c.ShowStrategy)
+	r.GET("/strategy/:uuid/backtest", viewStrategy, c.BacktestPage)
+	r.DELETE("/strategy/:uuid", editStrategy, c.DeleteStrategy)
+	r.GET("/strategy/:uuid/edit_trendline", editStrategy, c.EditTrendline)
+	r.GET("/strategy/:uuid/edit_limit", editStrategy, c.EditLimit)
+	r.GET("/strategy/:uuid/edit_market", editStrategy, c.EditLimit)
+	r.PATCH("/strategy/:uuid", editStrategy, c.UpdateStrategy)
+	r.GET("/strategy/:uuid/tpsl/edit", editStrategy, c.EditTpSl)
+	r.PATCH("/strategy/:uuid/tpsl", editStrategy, c.UpdateTpSl)
+	r.PATCH("/strategy/:uuid/orders_details", editStrategy, c.UpdateOrdersDetails)
 
 	// Action
-	r.GET("/action/enable_strategy/:uuid", c.EnableStrategy)
-	r.GET("/action/disable_strategy/:uuid", c.DisableStrategy)
-	r.GET("/action/reset_strategy/:uuid", c.ResetStrategy)
-	r.GET("/action/close_position/:uuid", c.ClosePosition)
+	r.POST("/action/enable_strategy/:uuid", tradeStrategy, c.EnableStrategy)
+	r.POST("/action/disable_strategy/:uuid", tradeStrategy, c.DisableStrategy)
+	r.POST("/action/reset_strategy/:uuid", tradeStrategy, c.ResetStrategy)
+	r.POST("/action/close_position/:uuid", closeStrategy, c.ClosePosition)
 	// TODO
-	r.GET("/action/share_strategy/:uuid", c.ShareStrategy)
+	r.POST("/action/share_strategy/:uuid", tradeStrategy, c.ShareStrategy)
+
+	// TODO remove after the transition period, actions used to be GET requests
+	r.GET("/action/enable_strategy/:uuid", c.MovedToPost)
+	r.GET("/action/disable_strategy/:uuid", c.MovedToPost)
+	r.GET("/action/reset_strategy/:uuid", c.MovedToPost)
+	r.GET("/action/close_position/:uuid", c.MovedToPost)
+	r.GET("/action/share_strategy/:uuid", c.MovedToPost)
+
+	// API
+	api := r.Group("/api/v1")
+	api.GET("/strategies", viewStrategy, c.APIListStrategies)
+	api.POST("/strategies", editStrategy, c.APICreateStrategy)
+	api.GET("/strategies/:uuid", viewStrategy, c.APIGetStrategy)
+	api.PATCH("/strategies/:uuid", editStrategy, c.APIUpdateStrategy)
+	api.DELETE("/strategies/:uuid", editStrategy, c.APIDeleteStrategy)
+	api.PATCH("/strategies/:uuid/tpsl", editStrategy, c.APIUpdateTpSl)
+	api.POST("/backtests", viewStrategy, c.APIRunBacktest)
+	api.GET("/admin/users", manageUser, c.APIAdminListUsers)
+	api.POST("/admin/users", manageUser, c.APIAdminCreateUser)
+	api.PATCH("/admin/users/:uuid", manageUser, c.APIAdminUpdateUser)
+	api.POST("/admin/invites", manageUser, c.APIAdminCreateInvite)
 }
@@ -0,0 +1,250 @@
+// Package backtest replays the entry, stop-loss and take-profit triggers of a contract against historical candles
+package backtest
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Reasons of the fills
+const (
+	FILL_ENTRY       = "entry"
+	FILL_STOP_LOSS   = "stop_loss"
+	FILL_TAKE_PROFIT = "take_profit"
+	FILL_END         = "end" // still opened at the last candle, closed at its close price
+)
+
+type Params struct {
+	Side     order.Side
+	Contract *contract.Contract
+	Margin   decimal.Decimal // notional of the position, same as contract_strategies.margin
+	FeeRate  decimal.Decimal
+}
+
+type Fill struct {
+	Time   time.Time
+	Reason string
+	Price  decimal.Decimal
+	Size   decimal.Decimal
+	Fee    decimal.Decimal
+}
+
+// Point is a bar of the chart, the trigger prices are zero if they're not set at the time
+type Point struct {
+	Time       time.Time
+	Open       decimal.Decimal
+	High       decimal.Decimal
+	Low        decimal.Decimal
+	Close      decimal.Decimal
+	Trendline  decimal.Decimal // the line of trendline strategies
+	Entry      decimal.Decimal
+	StopLoss   decimal.Decimal
+	TakeProfit decimal.Decimal
+	Equity     decimal.Decimal // margin plus PnL
+}
+
+type Result struct {
+	Fills              []Fill
+	Pnl                decimal.Decimal // fees are deducted
+	PnlPercent         decimal.Decimal // of margin
+	Fees               decimal.Decimal
+	MaxDrawdown        decimal.Decimal
+	MaxDrawdownPercent decimal.Decimal // of the peak equity
+	Points             []Point
+}
+
+var hundred = decimal.NewFromInt(100)
+
+// Run replays the candles bar by bar like the engine does with the ticker prices, the strategy is closed after
+// stop-loss or take-profit, the same as the engine disables it. Within a bar, stop-loss is assumed to happen
+// before take-profit, and triggers are filled at the trigger price or the open price if it gaps over
+func Run(p Params, candles []Candle) (*Result, error) {
+	if len(candles) == 0 {
+		return nil, errors.New("candles are empty")
+	}
+	if !p.Margin.IsPositive() {
+		return nil, errors.New("margin is invalid")
+	}
+
+	r := &Result{}
+	entryTrigger := p.Contract.EntryOrder.GetTrigger()
+	entryOperator := entryTrigger.GetOperator()
+	entry, _ := p.Contract.EntryOrder.(*order.Entry)
+
+	// Flip the operator if the price is already on the other side of the line when it starts, e.g. the breakout
+	// becomes the pullback
+	if entry != nil && entry.FlipOperatorEnabled && isCrossed(entryOperator, candles[0].Open, entryTrigger.GetPrice(candles[0].Time)) {
+		entryOperator = flip(entryOperator)
+	}
+
+	var size, entryPrice, entryLine decimal.Decimal
+	opened, closed := false, false
+	peak := p.Margin
+	for _, c := range candles {
+		point := Point{Time: c.Time, Open: c.Open, High: c.High, Low: c.Low, Close: c.Close}
+		if entry != nil && entry.TrendlineTrigger != nil {
+			point.Trendline = entry.TrendlineTrigger.GetPrice(c.Time)
+		}
+
+		if !opened && !closed {
+			point.Entry = entryTrigger.GetPrice(c.Time)
+			if price, ok := triggeredPrice(entryOperator, point.Entry, c); ok {
+				opened = true
+				entryPrice = price
+				entryLine = point.Trendline
+				size = p.Margin.Div(price)
+				r.Fills = append(r.Fills, r.fill(c.Time, FILL_ENTRY, price, size, p.FeeRate))
+			}
+		}
+
+		if opened && !closed {
+			point.StopLoss = stopLossPrice(p, entryPrice, entryLine, point.Trendline, c.Time)
+			point.TakeProfit = triggerPrice(p.Contract.TakeProfitOrder, c.Time)
+
+			// Stop-loss is on the losing side of the position, take-profit is on the other side
+			slOperator, tpOperator := "<=", ">="
+			if p.Side == order.SHORT {
+				slOperator, tpOperator = ">=", "<="
+			}
+			if p.Contract.StopLossOrder != nil && p.Contract.StopLossOrder.GetTrigger() != nil {
+				slOperator = p.Contract.StopLossOrder.GetTrigger().GetOperator()
+			}
+			if p.Contract.TakeProfitOrder != nil && p.Contract.TakeProfitOrder.GetTrigger() != nil {
+				tpOperator = p.Contract.TakeProfitOrder.GetTrigger().GetOperator()
+			}
+			if reason, price, ok := exit(slOperator, point.StopLoss, tpOperator, point.TakeProfit, c); ok {
+				closed = true
+				r.Fills = append(r.Fills, r.fill(c.Time, reason, price, size, p.FeeRate))
+				r.Pnl = r.Pnl.Add(pnl(p.Side, entryPrice, price, size))
+			}
+		}
+
+		// Equity of the bar, the worst price of the bar is used for drawdown while the position is opened
+		equity := p.Margin.Add(r.Pnl).Sub(r.Fees)
+		worst := equity
+		if opened && !closed {
+			worstPrice := c.Low
+			if p.Side == order.SHORT {
+				worstPrice = c.High
+			}
+			equity = equity.Add(pnl(p.Side, entryPrice, c.Close, size))
+			worst = worst.Add(pnl(p.Side, entryPrice, worstPrice, size))
+		}
+		point.Equity = equity
+		if equity.GreaterThan(peak) {
+			peak = equity
+		}
+		if drawdown := peak.Sub(worst); drawdown.GreaterThan(r.MaxDrawdown) {
+			r.MaxDrawdown = drawdown
+			r.MaxDrawdownPercent = drawdown.Div(peak).Mul(hundred)
+		}
+		r.Points = append(r.Points, point)
+	}
+
+	// Close at the last candle
+	if opened && !closed {
+		last := candles[len(candles)-1]
+		r.Fills = append(r.Fills, r.fill(last.Time, FILL_END, last.Close, size, p.FeeRate))
+		r.Pnl = r.Pnl.Add(pnl(p.Side, entryPrice, last.Close, size))
+	}
+
+	r.Pnl = r.Pnl.Sub(r.Fees)
+	r.PnlPercent = r.Pnl.Div(p.Margin).Mul(hundred)
+	return r, nil
+}
+
+func (r *Result) fill(t time.Time, reason string, price decimal.Decimal, size decimal.Decimal, feeRate decimal.Decimal) Fill {
+	f := Fill{Time: t, Reason: reason, Price: price, Size: size, Fee: price.Mul(size).Mul(feeRate)}
+	r.Fees = r.Fees.Add(f.Fee)
+	return f
+}
+
+// stopLossPrice uses the trigger of limit strategies, trendline strategies set it by loss_tolerance_percent after
+// entry, below (long) or above (short) the entry price, or the trendline if trendline_readjustment_enabled
+func stopLossPrice(p Params, entryPrice decimal.Decimal, entryLine decimal.Decimal, line decimal.Decimal, t time.Time) decimal.Decimal {
+	if price := triggerPrice(p.Contract.StopLossOrder, t); price.IsPositive() {
+		return price
+	}
+	stopLoss, ok := p.Contract.StopLossOrder.(*order.StopLoss)
+	if !ok || stopLoss == nil {
+		return decimal.Zero
+	}
+	base := entryPrice
+	if !entryLine.IsZero() {
+		base = entryLine
+	}
+	if stopLoss.TrendlineReadjustmentEnabled && !line.IsZero() {
+		base = line
+	}
+	tolerance := decimal.NewFromFloat(stopLoss.LossTolerancePercent)
+	if p.Side == order.SHORT {
+		return base.Mul(decimal.NewFromInt(1).Add(tolerance))
+	}
+	return base.Mul(decimal.NewFromInt(1).Sub(tolerance))
+}
+
+func triggerPrice(o order.TradingOrder, t time.Time) decimal.Decimal {
+	if o == nil || o.GetTrigger() == nil {
+		return decimal.Zero
+	}
+	return o.GetTrigger().GetPrice(t)
+}
+
+// exit returns the reason and the fill price if the stop-loss or take-profit is reached within the bar, stop-loss first
+// if both are
+func exit(slOperator string, stopLoss decimal.Decimal, tpOperator string, takeProfit decimal.Decimal, c Candle) (string, decimal.Decimal, bool) {
+	if price, ok := triggeredPrice(slOperator, stopLoss, c); ok {
+		return FILL_STOP_LOSS, price, true
+	}
+	if price, ok := triggeredPrice(tpOperator, takeProfit, c); ok {
+		return FILL_TAKE_PROFIT, price, true
+	}
+	return "", decimal.Zero, false
+}
+
+// triggeredPrice returns the fill price if the price reaches the trigger within the bar
+func triggeredPrice(operator string, price decimal.Decimal, c Candle) (decimal.Decimal, bool) {
+	if !price.IsPositive() {
+		return decimal.Zero, false
+	}
+	switch operator {
+	case ">=", ">":
+		if c.Open.GreaterThanOrEqual(price) {
+			return c.Open, true
+		}
+		return price, c.High.GreaterThanOrEqual(price)
+	case "<=", "<":
+		if c.Open.LessThanOrEqual(price) {
+			return c.Open, true
+		}
+		return price, c.Low.LessThanOrEqual(price)
+	}
+	return decimal.Zero, false
+}
+
+func isCrossed(operator string, price decimal.Decimal, line decimal.Decimal) bool {
+	_, ok := triggeredPrice(operator, line, Candle{Open: price, High: price, Low: price})
+	return ok
+}
+
+func flip(operator string) string {
+	switch operator {
+	case ">=", ">":
+		return "<="
+	case "<=", "<":
+		return ">="
+	}
+	return operator
+}
+
+func pnl(side order.Side, entryPrice decimal.Decimal, exitPrice decimal.Decimal, size decimal.Decimal) decimal.Decimal {
+	diff := exitPrice.Sub(entryPrice).Mul(size)
+	if side == order.SHORT {
+		return diff.Neg()
+	}
+	return diff
+}
@@ -0,0 +1,101 @@
+package backtest
+
+import (
+	"crypto-trading-bot-engine/strategy/order"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func candle(open, high, low, close string) Candle {
+	return Candle{
+		Open:  decimal.RequireFromString(open),
+		High:  decimal.RequireFromString(high),
+		Low:   decimal.RequireFromString(low),
+		Close: decimal.RequireFromString(close),
+	}
+}
+
+func TestTriggeredPrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator string
+		price    string
+		candle   Candle
+		want     string
+		wantOk   bool
+	}{
+		{"reached by high", ">=", "105", candle("100", "110", "95", "102"), "105", true},
+		{"not reached by high", ">=", "115", candle("100", "110", "95", "102"), "0", false},
+		{"gap up fills at open", ">=", "105", candle("108", "110", "107", "109"), "108", true},
+		{"reached by low", "<=", "97", candle("100", "110", "95", "102"), "97", true},
+		{"not reached by low", "<=", "90", candle("100", "110", "95", "102"), "0", false},
+		{"gap down fills at open", "<=", "97", candle("92", "94", "90", "93"), "92", true},
+		{"open at the price", "<=", "100", candle("100", "110", "95", "102"), "100", true},
+		{"unset price", ">=", "0", candle("100", "110", "95", "102"), "0", false},
+		{"unknown operator", "==", "105", candle("100", "110", "95", "102"), "0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := triggeredPrice(tt.operator, decimal.RequireFromString(tt.price), tt.candle)
+			if ok != tt.wantOk || (ok && !got.Equal(decimal.RequireFromString(tt.want))) {
+				t.Errorf("triggeredPrice() = %s, %v, want %s, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestExit(t *testing.T) {
+	tests := []struct {
+		name       string
+		slOperator string
+		stopLoss   string
+		tpOperator string
+		takeProfit string
+		candle     Candle
+		wantReason string
+		wantPrice  string
+	}{
+		// Long, stop-loss at 95 and take-profit at 110
+		{"long, both reached, stop-loss first", "<=", "95", ">=", "110", candle("100", "112", "90", "105"), FILL_STOP_LOSS, "95"},
+		{"long, take-profit only", "<=", "95", ">=", "110", candle("100", "112", "98", "111"), FILL_TAKE_PROFIT, "110"},
+		{"long, stop-loss gapped down", "<=", "95", ">=", "110", candle("90", "93", "88", "92"), FILL_STOP_LOSS, "90"},
+		{"long, take-profit gapped up", "<=", "95", ">=", "110", candle("115", "118", "112", "116"), FILL_TAKE_PROFIT, "115"},
+		{"long, neither reached", "<=", "95", ">=", "110", candle("100", "105", "97", "102"), "", "0"},
+		{"long, no stop-loss", "<=", "0", ">=", "110", candle("100", "112", "90", "105"), FILL_TAKE_PROFIT, "110"},
+		// Short, stop-loss at 105 and take-profit at 90
+		{"short, both reached, stop-loss first", ">=", "105", "<=", "90", candle("100", "108", "88", "95"), FILL_STOP_LOSS, "105"},
+		{"short, stop-loss gapped up", ">=", "105", "<=", "90", candle("110", "112", "108", "111"), FILL_STOP_LOSS, "110"},
+		{"short, take-profit gapped down", ">=", "105", "<=", "90", candle("85", "88", "84", "86"), FILL_TAKE_PROFIT, "85"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, price, ok := exit(tt.slOperator, decimal.RequireFromString(tt.stopLoss), tt.tpOperator, decimal.RequireFromString(tt.takeProfit), tt.candle)
+			if reason != tt.wantReason || ok != (tt.wantReason != "") || !price.Equal(decimal.RequireFromString(tt.wantPrice)) {
+				t.Errorf("exit() = %s, %s, %v, want %s, %s", reason, price, ok, tt.wantReason, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestPnl(t *testing.T) {
+	tests := []struct {
+		name string
+		side order.Side
+		exit int64
+		want int64
+	}{
+		{"long profit", order.LONG, 110, 20},
+		{"long loss", order.LONG, 90, -20},
+		{"short profit", order.SHORT, 90, 20},
+		{"short loss", order.SHORT, 110, -20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pnl(tt.side, decimal.NewFromInt(100), decimal.NewFromInt(tt.exit), decimal.NewFromInt(2))
+			if !got.Equal(decimal.NewFromInt(tt.want)) {
+				t.Errorf("pnl() = %s, want %d", got, tt.want)
+			}
+		})
+	}
+}
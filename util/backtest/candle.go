@@ -0,0 +1,87 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Max candles of a backtest, e.g. a year of 1h candles
+const MAX_CANDLES = 10000
+
+type Candle struct {
+	Time   time.Time // open time
+	Open   decimal.Decimal
+	High   decimal.Decimal
+	Low    decimal.Decimal
+	Close  decimal.Decimal
+	Volume decimal.Decimal
+}
+
+// ParseCSV reads `<time>,<open>,<high>,<low>,<close>[,<volume>]`, time is unix seconds, unix milliseconds or RFC3339,
+// the header is optional
+func ParseCSV(r io.Reader) ([]Candle, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var candles []Candle
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < 5 {
+			return nil, fmt.Errorf("line %d: at least 5 columns are required", line)
+		}
+		t, err := parseTime(row[0])
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: time is invalid", line)
+		}
+
+		c := Candle{Time: t}
+		for i, p := range []*decimal.Decimal{&c.Open, &c.High, &c.Low, &c.Close} {
+			if *p, err = decimal.NewFromString(row[i+1]); err != nil {
+				return nil, fmt.Errorf("line %d: price is invalid", line)
+			}
+		}
+		if len(row) > 5 && row[5] != "" {
+			if c.Volume, err = decimal.NewFromString(row[5]); err != nil {
+				return nil, fmt.Errorf("line %d: volume is invalid", line)
+			}
+		}
+		if c.High.LessThan(c.Low) {
+			return nil, fmt.Errorf("line %d: high is lower than low", line)
+		}
+		candles = append(candles, c)
+		if len(candles) > MAX_CANDLES {
+			return nil, fmt.Errorf("at most %d candles", MAX_CANDLES)
+		}
+	}
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time.Before(candles[j].Time) })
+	return candles, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		// Milliseconds, e.g. Binance klines
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,27 @@
+package backtest
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+)
+
+// Preview returns the trendline, the entry trigger with trendline_offset_percent and the stop-loss of
+// loss_tolerance_percent at the time of the candles, as if the entry is triggered at that time. The prices of the
+// candles are kept as is, they're zero if the candle is missing
+func Preview(side order.Side, c *contract.Contract, candles []Candle) []Point {
+	p := Params{Side: side, Contract: c}
+	entry, _ := c.EntryOrder.(*order.Entry)
+
+	points := make([]Point, len(candles))
+	for i, candle := range candles {
+		point := Point{Time: candle.Time, Open: candle.Open, High: candle.High, Low: candle.Low, Close: candle.Close}
+		if entry != nil && entry.TrendlineTrigger != nil {
+			point.Trendline = entry.TrendlineTrigger.GetPrice(candle.Time)
+		}
+		point.Entry = triggerPrice(c.EntryOrder, candle.Time)
+		point.StopLoss = stopLossPrice(p, point.Entry, point.Trendline, point.Trendline, candle.Time)
+		point.TakeProfit = triggerPrice(c.TakeProfitOrder, candle.Time)
+		points[i] = point
+	}
+	return points
+}
@@ -0,0 +1,135 @@
+// Package captcha verifies the human-verification responses posted by the login page
+package captcha
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Providers, selected by config `CAPTCHA_PROVIDER`
+const (
+	PROVIDER_RECAPTCHA = "recaptcha" // reCAPTCHA v3
+	PROVIDER_HCAPTCHA  = "hcaptcha"
+	PROVIDER_TURNSTILE = "turnstile" // Cloudflare Turnstile
+	PROVIDER_DISABLED  = "disabled"  // always pass, for local development only
+)
+
+const (
+	RECAPTCHA_VERIFY_URL = "https://www.google.com/recaptcha/api/siteverify"
+	HCAPTCHA_VERIFY_URL  = "https://api.hcaptcha.com/siteverify"
+	TURNSTILE_VERIFY_URL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+	VERIFY_TIMEOUT_SECOND = 10
+
+	// reCAPTCHA v3 scores from 0.0 (bot) to 1.0, Google suggests 0.5 to start with
+	DEFAULT_MIN_SCORE = 0.5
+)
+
+type Verifier interface {
+	// Verify returns false if the response isn't accepted, `action` is only checked by reCAPTCHA v3
+	Verify(response string, remoteIp string, action string) (bool, error)
+}
+
+type Config struct {
+	Provider string
+	Secret   string
+
+	// reCAPTCHA v3 only, responses with lower score are rejected
+	MinScore float64
+}
+
+func NewVerifier(cfg Config) (Verifier, error) {
+	switch cfg.Provider {
+	case PROVIDER_RECAPTCHA:
+		return NewSiteVerifier(RECAPTCHA_VERIFY_URL, cfg.Secret, cfg.MinScore, true), nil
+	case PROVIDER_HCAPTCHA:
+		return NewSiteVerifier(HCAPTCHA_VERIFY_URL, cfg.Secret, 0, false), nil
+	case PROVIDER_TURNSTILE:
+		return NewSiteVerifier(TURNSTILE_VERIFY_URL, cfg.Secret, 0, false), nil
+	case PROVIDER_DISABLED:
+		return &DisabledVerifier{}, nil
+	}
+	return nil, fmt.Errorf("captcha provider '%s' not supported", cfg.Provider)
+}
+
+// SiteVerifier works with the providers sharing the same `siteverify` protocol
+type SiteVerifier struct {
+	VerifyURL   string
+	Secret      string
+	MinScore    float64
+	CheckAction bool
+	Client      *http.Client
+}
+
+// Response of `siteverify`, score and action are only returned by reCAPTCHA v3
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"`
+	Action     string   `json:"action"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func NewSiteVerifier(verifyURL string, secret string, minScore float64, checkAction bool) *SiteVerifier {
+	return &SiteVerifier{
+		VerifyURL:   verifyURL,
+		Secret:      secret,
+		MinScore:    minScore,
+		CheckAction: checkAction,
+		Client:      &http.Client{Timeout: time.Second * VERIFY_TIMEOUT_SECOND},
+	}
+}
+
+func (v *SiteVerifier) Verify(response string, remoteIp string, action string) (bool, error) {
+	if response == "" {
+		return false, nil
+	}
+
+	form := url.Values{}
+	form.Set("secret", v.Secret)
+	form.Set("response", response)
+	if remoteIp != "" {
+		form.Set("remoteip", remoteIp)
+	}
+	resp, err := v.Client.PostForm(v.VerifyURL, form)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("siteverify responded with status %d", resp.StatusCode)
+	}
+
+	var r siteVerifyResponse
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return false, err
+	}
+	if !r.Success {
+		if len(r.ErrorCodes) > 0 {
+			return false, fmt.Errorf("siteverify failed, error-codes: %s", strings.Join(r.ErrorCodes, ","))
+		}
+		return false, nil
+	}
+	if v.CheckAction && action != "" && r.Action != action {
+		return false, fmt.Errorf("action '%s' doesn't match '%s'", r.Action, action)
+	}
+	if v.MinScore > 0 {
+		if r.Score == nil {
+			return false, fmt.Errorf("score is missing")
+		}
+		if *r.Score < v.MinScore {
+			return false, fmt.Errorf("score %.2f is lower than %.2f", *r.Score, v.MinScore)
+		}
+	}
+	return true, nil
+}
+
+// DisabledVerifier accepts everything
+type DisabledVerifier struct{}
+
+func (v *DisabledVerifier) Verify(response string, remoteIp string, action string) (bool, error) {
+	return true, nil
+}
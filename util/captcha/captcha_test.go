@@ -0,0 +1,89 @@
+package captcha
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Only this response is accepted by the fake server
+const fakeValidResponse = "fake-valid-response"
+
+// newFakeServer starts a local `siteverify` endpoint, the score and action are returned along with every successful
+// response
+func newFakeServer(secret string, score float64, action string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		resp := map[string]interface{}{
+			"success": false,
+		}
+		switch {
+		case r.PostForm.Get("secret") != secret:
+			resp["error-codes"] = []string{"invalid-input-secret"}
+		case r.PostForm.Get("response") != fakeValidResponse:
+			resp["error-codes"] = []string{"invalid-input-response"}
+		default:
+			resp["success"] = true
+			resp["score"] = score
+			resp["action"] = action
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestSiteVerifier(t *testing.T) {
+	tests := []struct {
+		name        string
+		score       float64
+		action      string
+		minScore    float64
+		checkAction bool
+		secret      string
+		response    string
+		wantOk      bool
+		wantErr     bool
+	}{
+		{"accepted", 0.9, "login", DEFAULT_MIN_SCORE, true, "secret", fakeValidResponse, true, false},
+		{"score equals the min score", 0.5, "login", DEFAULT_MIN_SCORE, true, "secret", fakeValidResponse, true, false},
+		{"score lower than the min score", 0.1, "login", DEFAULT_MIN_SCORE, true, "secret", fakeValidResponse, false, true},
+		{"action mismatched", 0.9, "signup", DEFAULT_MIN_SCORE, true, "secret", fakeValidResponse, false, true},
+		{"action unchecked", 0.9, "signup", 0, false, "secret", fakeValidResponse, true, false},
+		{"invalid response", 0.9, "login", DEFAULT_MIN_SCORE, true, "secret", "bot", false, true},
+		{"empty response", 0.9, "login", DEFAULT_MIN_SCORE, true, "secret", "", false, false},
+		{"wrong secret", 0.9, "login", DEFAULT_MIN_SCORE, true, "other", fakeValidResponse, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer("secret", tt.score, tt.action)
+			defer srv.Close()
+
+			v := NewSiteVerifier(srv.URL, tt.secret, tt.minScore, tt.checkAction)
+			ok, err := v.Verify(tt.response, "127.0.0.1", "login")
+			if ok != tt.wantOk || (err != nil) != tt.wantErr {
+				t.Errorf("Verify() = %v, %v, want %v, error %v", ok, err, tt.wantOk, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	for _, provider := range []string{PROVIDER_RECAPTCHA, PROVIDER_HCAPTCHA, PROVIDER_TURNSTILE, PROVIDER_DISABLED} {
+		if _, err := NewVerifier(Config{Provider: provider}); err != nil {
+			t.Errorf("NewVerifier(%s) err: %v", provider, err)
+		}
+	}
+	if _, err := NewVerifier(Config{Provider: "unknown"}); err == nil {
+		t.Error("NewVerifier(unknown) should fail")
+	}
+}
@@ -0,0 +1,124 @@
+// Package exchangeinfo describes the exchanges supported by crypto-trading-bot-engine/exchange, e.g. the symbol format
+// and the fields of the API key, so that the site doesn't need to assume a single exchange
+package exchangeinfo
+
+import (
+	"strings"
+)
+
+// Names are the same as crypto-trading-bot-engine/exchange and contract_strategies.exchange
+const (
+	EXCHANGE_FTX     = "FTX"
+	EXCHANGE_BINANCE = "BINANCE" // USDⓈ-M futures
+	EXCHANGE_BYBIT   = "BYBIT"   // USDT perpetual
+)
+
+// Paper accounts are simulated by util/paper with the symbols of the underlying exchange, e.g. PAPER_FTX,
+// crypto-trading-bot-engine/exchange doesn't know the names so they can't be traded with real money by mistake
+const PAPER_PREFIX = "PAPER_"
+
+// CredentialField is an input of the API key form, saved in the encrypted data by the same name
+type CredentialField struct {
+	Name     string
+	Label    string
+	Required bool
+}
+
+type Exchange struct {
+	Name        string
+	DisplayName string
+
+	// e.g. `-` of `BTC-PERP`, empty if the symbol is `BTCUSDT` style
+	SymbolSeparator string
+	// Quote assets to split the symbols without separator, the longer ones first
+	QuoteAssets []string
+
+	CredentialFields []CredentialField
+}
+
+var (
+	apiKeyField    = CredentialField{Name: "api_key", Label: "API Key", Required: true}
+	apiSecretField = CredentialField{Name: "api_secret", Label: "API Secret", Required: true}
+)
+
+// NOTE keep the order, it's the order of the options
+var exchanges = []*Exchange{
+	{
+		Name:            EXCHANGE_FTX,
+		DisplayName:     "FTX",
+		SymbolSeparator: "-",
+		CredentialFields: []CredentialField{
+			apiKeyField,
+			apiSecretField,
+			{Name: "subaccount", Label: "Subaccount name", Required: true},
+		},
+	},
+	{
+		Name:             EXCHANGE_BINANCE,
+		DisplayName:      "Binance USDⓈ-M",
+		QuoteAssets:      []string{"USDT", "BUSD", "USDC"},
+		CredentialFields: []CredentialField{apiKeyField, apiSecretField},
+	},
+	{
+		Name:             EXCHANGE_BYBIT,
+		DisplayName:      "Bybit",
+		QuoteAssets:      []string{"USDT", "USDC"},
+		CredentialFields: []CredentialField{apiKeyField, apiSecretField},
+	},
+}
+
+// Get returns nil if the exchange isn't supported
+func Get(name string) *Exchange {
+	for _, e := range exchanges {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// All returns the supported exchanges
+func All() []*Exchange {
+	return exchanges
+}
+
+// SplitSymbol returns the base and quote of the symbol, e.g. `BTC` and `PERP` of `BTC-PERP`, `BTC` and `USDT` of `BTCUSDT`
+func (e *Exchange) SplitSymbol(symbol string) (string, string) {
+	if e.SymbolSeparator != "" {
+		parts := strings.SplitN(symbol, e.SymbolSeparator, 2)
+		if len(parts) == 2 {
+			return parts[0], parts[1]
+		}
+		return symbol, ""
+	}
+	for _, quote := range e.QuoteAssets {
+		if strings.HasSuffix(symbol, quote) && len(symbol) > len(quote) {
+			return strings.TrimSuffix(symbol, quote), quote
+		}
+	}
+	return symbol, ""
+}
+
+func IsPaper(name string) bool {
+	return strings.HasPrefix(name, PAPER_PREFIX)
+}
+
+// Underlying returns the exchange of the paper account, or the name itself
+func Underlying(name string) string {
+	return strings.TrimPrefix(name, PAPER_PREFIX)
+}
+
+func PaperName(name string) string {
+	return PAPER_PREFIX + name
+}
+
+// SplitSymbol works with the unknown exchanges as well, e.g. the strategies created before the exchange is removed
+func SplitSymbol(exchangeName string, symbol string) (string, string) {
+	if e := Get(Underlying(exchangeName)); e != nil {
+		return e.SplitSymbol(symbol)
+	}
+	if parts := strings.SplitN(symbol, "-", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return symbol, ""
+}
@@ -0,0 +1,89 @@
+package keycheck
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	BINANCE_API_URL = "https://api.binance.com"
+
+	// Returns the permissions of the key, e.g. `enableFutures`, `enableWithdrawals` and `ipRestrict`
+	BINANCE_API_RESTRICTIONS_PATH = "/sapi/v1/account/apiRestrictions"
+
+	// Binance returns the same code for invalid key, IP and permissions
+	BINANCE_CODE_REJECTED      = -2015
+	BINANCE_CODE_INVALID_KEY   = -2014
+	BINANCE_CODE_INVALID_SIGN  = -1022
+	BINANCE_RECV_WINDOW_MILLIS = 5000
+)
+
+type BinanceChecker struct {
+	ApiURL string
+	Client *http.Client
+}
+
+type binanceRestrictions struct {
+	// Only returned on error
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+
+	IpRestrict        bool `json:"ipRestrict"`
+	EnableReading     bool `json:"enableReading"`
+	EnableFutures     bool `json:"enableFutures"`
+	EnableWithdrawals bool `json:"enableWithdrawals"`
+}
+
+func NewBinanceChecker(apiURL string, timeout time.Duration) *BinanceChecker {
+	return &BinanceChecker{
+		ApiURL: apiURL,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Check ignores subaccount, the keys of Binance sub-accounts are created in the sub-accounts
+func (ch *BinanceChecker) Check(apiKey string, apiSecret string, subaccount string) (*Result, error) {
+	query := url.Values{}
+	query.Set("timestamp", strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10))
+	query.Set("recvWindow", strconv.Itoa(BINANCE_RECV_WINDOW_MILLIS))
+	mac := hmac.New(sha256.New, []byte(apiSecret))
+	mac.Write([]byte(query.Encode()))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	req, err := http.NewRequest(http.MethodGet, ch.ApiURL+BINANCE_API_RESTRICTIONS_PATH+"?"+query.Encode()+"&signature="+signature, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-MBX-APIKEY", apiKey)
+
+	resp, err := ch.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r binanceRestrictions
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("Binance responded with status %d, err: %v", resp.StatusCode, err)
+	}
+	if r.Code != 0 {
+		switch r.Code {
+		case BINANCE_CODE_REJECTED, BINANCE_CODE_INVALID_KEY, BINANCE_CODE_INVALID_SIGN:
+			return nil, ErrInvalidKey
+		}
+		return nil, fmt.Errorf("Binance responded with status %d, code: %d, msg: %s", resp.StatusCode, r.Code, r.Msg)
+	}
+
+	return &Result{
+		CanTrade:     r.EnableFutures,
+		CanWithdraw:  r.EnableWithdrawals,
+		IpRestricted: &r.IpRestrict,
+	}, nil
+}
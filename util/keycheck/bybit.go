@@ -0,0 +1,103 @@
+package keycheck
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	BYBIT_API_URL = "https://api.bybit.com"
+
+	// Returns the permissions of the key, e.g. `readOnly`, `ips` and `permissions`
+	BYBIT_QUERY_API_PATH = "/v5/user/query-api"
+
+	BYBIT_CODE_INVALID_KEY   = 10003
+	BYBIT_CODE_INVALID_SIGN  = 10004
+	BYBIT_CODE_IP_MISMATCH   = 10010
+	BYBIT_RECV_WINDOW_MILLIS = 5000
+)
+
+type BybitChecker struct {
+	ApiURL string
+	Client *http.Client
+}
+
+type bybitResponse struct {
+	RetCode int         `json:"retCode"`
+	RetMsg  string      `json:"retMsg"`
+	Result  bybitApiKey `json:"result"`
+}
+
+type bybitApiKey struct {
+	ReadOnly    int                 `json:"readOnly"` // 0: read and write, 1: read only
+	Ips         []string            `json:"ips"`      // `*` if it's not restricted
+	Permissions map[string][]string `json:"permissions"`
+}
+
+func NewBybitChecker(apiURL string, timeout time.Duration) *BybitChecker {
+	return &BybitChecker{
+		ApiURL: apiURL,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Check ignores subaccount, the keys of Bybit sub-accounts are created for the sub-accounts
+func (ch *BybitChecker) Check(apiKey string, apiSecret string, subaccount string) (*Result, error) {
+	req, err := http.NewRequest(http.MethodGet, ch.ApiURL+BYBIT_QUERY_API_PATH, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Signature of `<ts><api key><recv window><query string>`
+	ts := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	recvWindow := strconv.Itoa(BYBIT_RECV_WINDOW_MILLIS)
+	mac := hmac.New(sha256.New, []byte(apiSecret))
+	mac.Write([]byte(ts + apiKey + recvWindow))
+	req.Header.Set("X-BAPI-API-KEY", apiKey)
+	req.Header.Set("X-BAPI-TIMESTAMP", ts)
+	req.Header.Set("X-BAPI-RECV-WINDOW", recvWindow)
+	req.Header.Set("X-BAPI-SIGN", hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := ch.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r bybitResponse
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("Bybit responded with status %d, err: %v", resp.StatusCode, err)
+	}
+	switch r.RetCode {
+	case 0:
+	case BYBIT_CODE_INVALID_KEY, BYBIT_CODE_INVALID_SIGN:
+		return nil, ErrInvalidKey
+	case BYBIT_CODE_IP_MISMATCH:
+		return nil, ErrIpNotAllowed
+	default:
+		return nil, fmt.Errorf("Bybit responded with status %d, retCode: %d, retMsg: %s", resp.StatusCode, r.RetCode, r.RetMsg)
+	}
+
+	result := &Result{
+		CanTrade: r.Result.ReadOnly == 0 && len(r.Result.Permissions["ContractTrade"]) > 0,
+	}
+	for _, p := range r.Result.Permissions["Wallet"] {
+		if p == "Withdraw" {
+			result.CanWithdraw = true
+		}
+	}
+	restricted := true
+	for _, ip := range r.Result.Ips {
+		if ip == "*" {
+			restricted = false
+		}
+	}
+	result.IpRestricted = &restricted
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package keycheck
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FTX_API_URL = "https://ftx.com/api"
+
+	// Returns the permissions of the key, e.g. `readOnly` and `withdrawalEnabled`
+	FTX_LOGIN_STATUS_PATH = "/login_status"
+)
+
+type FTXChecker struct {
+	ApiURL string
+	Client *http.Client
+}
+
+type ftxResponse struct {
+	Success bool           `json:"success"`
+	Error   string         `json:"error"`
+	Result  ftxLoginStatus `json:"result"`
+}
+
+type ftxLoginStatus struct {
+	LoggedIn          bool   `json:"loggedIn"`
+	ReadOnly          bool   `json:"readOnly"`
+	WithdrawalEnabled bool   `json:"withdrawalEnabled"`
+	Subaccount        string `json:"subaccount"`
+}
+
+func NewFTXChecker(apiURL string, timeout time.Duration) *FTXChecker {
+	return &FTXChecker{
+		ApiURL: apiURL,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (ch *FTXChecker) Check(apiKey string, apiSecret string, subaccount string) (*Result, error) {
+	req, err := http.NewRequest(http.MethodGet, ch.ApiURL+FTX_LOGIN_STATUS_PATH, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Signature of `<ts><method>/api<path>`
+	ts := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	mac := hmac.New(sha256.New, []byte(apiSecret))
+	mac.Write([]byte(ts + http.MethodGet + req.URL.Path))
+	req.Header.Set("FTX-KEY", apiKey)
+	req.Header.Set("FTX-TS", ts)
+	req.Header.Set("FTX-SIGN", hex.EncodeToString(mac.Sum(nil)))
+	if subaccount != "" {
+		req.Header.Set("FTX-SUBACCOUNT", url.PathEscape(subaccount))
+	}
+
+	resp, err := ch.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r ftxResponse
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("FTX responded with status %d, err: %v", resp.StatusCode, err)
+	}
+	if !r.Success {
+		msg := strings.ToLower(r.Error)
+		switch {
+		case strings.Contains(msg, "ip"):
+			return nil, ErrIpNotAllowed
+		case strings.Contains(msg, "subaccount"):
+			return nil, ErrSubaccountMismatch
+		case resp.StatusCode == http.StatusUnauthorized || strings.Contains(msg, "not logged in"):
+			return nil, ErrInvalidKey
+		}
+		return nil, fmt.Errorf("FTX responded with status %d, error: %s", resp.StatusCode, r.Error)
+	}
+	if !r.Result.LoggedIn {
+		return nil, ErrInvalidKey
+	}
+	if r.Result.Subaccount != subaccount {
+		return nil, ErrSubaccountMismatch
+	}
+
+	// NOTE FTX doesn't return the IP whitelist of the key
+	return &Result{
+		CanTrade:    !r.Result.ReadOnly,
+		CanWithdraw: r.Result.WithdrawalEnabled,
+		Subaccount:  r.Result.Subaccount,
+	}, nil
+}
@@ -0,0 +1,48 @@
+// Package keycheck verifies exchange API keys before they're saved, e.g. permissions and subaccount
+package keycheck
+
+import (
+	"crypto-trading-bot-api/util/exchangeinfo"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const CHECK_TIMEOUT_SECOND = 10
+
+var (
+	// The exchange rejected the key or the signature
+	ErrInvalidKey = errors.New("invalid API key or secret")
+	// The key has IP whitelist which doesn't include this server
+	ErrIpNotAllowed = errors.New("IP is not allowed by the API key")
+	// The key doesn't belong to the subaccount
+	ErrSubaccountMismatch = errors.New("subaccount doesn't match")
+)
+
+// Result of the permissions granted to the key
+type Result struct {
+	CanTrade    bool
+	CanWithdraw bool
+	Subaccount  string // returned by the exchange, empty for the main account
+
+	// nil if the exchange doesn't tell whether the key has IP whitelist
+	IpRestricted *bool
+}
+
+type Checker interface {
+	// Check returns one of the errors above if the key is rejected, or the other errors if the exchange is unavailable
+	Check(apiKey string, apiSecret string, subaccount string) (*Result, error)
+}
+
+// NewChecker returns the checker of the exchange, see util/exchangeinfo for the names
+func NewChecker(exchangeName string) (Checker, error) {
+	switch exchangeName {
+	case exchangeinfo.EXCHANGE_FTX:
+		return NewFTXChecker(FTX_API_URL, time.Second*CHECK_TIMEOUT_SECOND), nil
+	case exchangeinfo.EXCHANGE_BINANCE:
+		return NewBinanceChecker(BINANCE_API_URL, time.Second*CHECK_TIMEOUT_SECOND), nil
+	case exchangeinfo.EXCHANGE_BYBIT:
+		return NewBybitChecker(BYBIT_API_URL, time.Second*CHECK_TIMEOUT_SECOND), nil
+	}
+	return nil, fmt.Errorf("exchange '%s' not supported", exchangeName)
+}
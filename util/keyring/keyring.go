@@ -0,0 +1,124 @@
+// Package keyring encrypts data with AES-GCM under versioned keys, so that the key can be rotated
+// while the data encrypted by the old keys can still be decrypted until it's rewrapped
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Ciphertext format `v2:<key id>:<base64 of nonce and sealed data>`, which can be told apart from the legacy `iv;data`
+const PREFIX = "v2:"
+
+var (
+	ErrUnknownKey    = errors.New("unknown key id")
+	ErrInvalidFormat = errors.New("invalid ciphertext format")
+)
+
+type Keyring struct {
+	currentId string
+	keys      map[string][]byte
+}
+
+// New returns the keyring of hex encoded AES keys (16, 24 or 32 bytes), new data is encrypted by `currentId`
+func New(currentId string, hexKeys map[string]string) (*Keyring, error) {
+	k := &Keyring{
+		currentId: currentId,
+		keys:      make(map[string][]byte),
+	}
+	for id, hexKey := range hexKeys {
+		if id == "" || strings.ContainsAny(id, ":;") {
+			return nil, fmt.Errorf("key id '%s' is invalid", id)
+		}
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("key '%s' is not hex encoded", id)
+		}
+		if _, err = aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("key '%s' is invalid, err: %v", id, err)
+		}
+		k.keys[id] = key
+	}
+	if _, ok := k.keys[currentId]; !ok {
+		return nil, fmt.Errorf("current key '%s' is not in the keyring", currentId)
+	}
+	return k, nil
+}
+
+func (k *Keyring) CurrentId() string {
+	return k.currentId
+}
+
+func (k *Keyring) Encrypt(plaintext []byte) (string, error) {
+	gcm, err := newGCM(k.keys[k.currentId])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	// NOTE the key id is authenticated as well, so that it can't be swapped
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(k.currentId))
+	return PREFIX + k.currentId + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keyring) Decrypt(ciphertext string) ([]byte, error) {
+	id, data, err := parse(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrInvalidFormat
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(id))
+}
+
+// NeedsRewrap returns true if the ciphertext isn't encrypted by the current key, including the legacy format
+func (k *Keyring) NeedsRewrap(ciphertext string) bool {
+	id, _, err := parse(ciphertext)
+	return err != nil || id != k.currentId
+}
+
+// IsVersioned returns false for the legacy format
+func IsVersioned(ciphertext string) bool {
+	return strings.HasPrefix(ciphertext, PREFIX)
+}
+
+func parse(ciphertext string) (id string, data string, err error) {
+	if !IsVersioned(ciphertext) {
+		return "", "", ErrInvalidFormat
+	}
+	parts := strings.SplitN(strings.TrimPrefix(ciphertext, PREFIX), ":", 2)
+	if len(parts) != 2 {
+		return "", "", ErrInvalidFormat
+	}
+	return parts[0], parts[1], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
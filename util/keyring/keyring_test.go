@@ -0,0 +1,133 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "c33a9bbad0a09866a7b7a9fea3e05a84ae0451034ef1f737aaf3c981ede8f5ac"
+	testKey2 = "0f1e2d3c4b5a69788796a5b4c3d2e1f00112233445566778899aabbccddeeff0"
+)
+
+func mustNew(t *testing.T, currentId string, keys map[string]string) *Keyring {
+	t.Helper()
+	k, err := New(currentId, keys)
+	if err != nil {
+		t.Fatalf("New() err: %v", err)
+	}
+	return k
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name      string
+		currentId string
+		keys      map[string]string
+		wantErr   bool
+	}{
+		{"valid", "1", map[string]string{"1": testKey1, "2": testKey2}, false},
+		{"16 bytes key", "1", map[string]string{"1": testKey1[:32]}, false},
+		{"current key missing", "3", map[string]string{"1": testKey1}, true},
+		{"not hex", "1", map[string]string{"1": "not-hex"}, true},
+		{"invalid key size", "1", map[string]string{"1": "c33a"}, true},
+		{"id with colon", "a:b", map[string]string{"a:b": testKey1}, true},
+		{"empty id", "", map[string]string{"": testKey1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.currentId, tt.keys); (err != nil) != tt.wantErr {
+				t.Errorf("New() err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := mustNew(t, "1", map[string]string{"1": testKey1})
+	for _, plaintext := range []string{"", "secret", strings.Repeat("x", 1000)} {
+		ciphertext, err := k.Encrypt([]byte(plaintext))
+		if err != nil {
+			t.Fatalf("Encrypt() err: %v", err)
+		}
+		if !strings.HasPrefix(ciphertext, PREFIX+"1:") {
+			t.Errorf("Encrypt() = %s, want prefix %s1:", ciphertext, PREFIX)
+		}
+		data, err := k.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt() err: %v", err)
+		}
+		if string(data) != plaintext {
+			t.Errorf("Decrypt() = %q, want %q", data, plaintext)
+		}
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	old := mustNew(t, "1", map[string]string{"1": testKey1})
+	ciphertext, err := old.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() err: %v", err)
+	}
+	id, data, _ := parse(ciphertext)
+	sealed, _ := base64.StdEncoding.DecodeString(data)
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 0x01
+
+	tests := []struct {
+		name       string
+		keyring    *Keyring
+		ciphertext string
+		wantErr    error
+		wantFail   bool
+	}{
+		{"rotated keyring keeps the old key", mustNew(t, "2", map[string]string{"1": testKey1, "2": testKey2}), ciphertext, nil, false},
+		{"old key removed", mustNew(t, "2", map[string]string{"2": testKey2}), ciphertext, ErrUnknownKey, true},
+		{"wrong key of the same id", mustNew(t, "1", map[string]string{"1": testKey2}), ciphertext, nil, true},
+		{"tampered data", old, PREFIX + id + ":" + base64.StdEncoding.EncodeToString(tampered), nil, true},
+		{"swapped key id", mustNew(t, "2", map[string]string{"1": testKey1, "2": testKey1}), PREFIX + "2:" + data, nil, true},
+		{"truncated data", old, PREFIX + id + ":" + base64.StdEncoding.EncodeToString(sealed[:4]), ErrInvalidFormat, true},
+		{"not base64", old, PREFIX + id + ":???", ErrInvalidFormat, true},
+		{"legacy format", old, "aXY=;ZGF0YQ==", ErrInvalidFormat, true},
+		{"missing key id", old, PREFIX + data, ErrInvalidFormat, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := tt.keyring.Decrypt(tt.ciphertext)
+			if (err != nil) != tt.wantFail {
+				t.Fatalf("Decrypt() err = %v, want error %v", err, tt.wantFail)
+			}
+			if tt.wantErr != nil && err != tt.wantErr {
+				t.Errorf("Decrypt() err = %v, want %v", err, tt.wantErr)
+			}
+			if !tt.wantFail && string(plaintext) != "secret" {
+				t.Errorf("Decrypt() = %q, want %q", plaintext, "secret")
+			}
+		})
+	}
+}
+
+func TestNeedsRewrap(t *testing.T) {
+	old := mustNew(t, "1", map[string]string{"1": testKey1})
+	rotated := mustNew(t, "2", map[string]string{"1": testKey1, "2": testKey2})
+	ciphertext1, _ := old.Encrypt([]byte("secret"))
+	ciphertext2, _ := rotated.Encrypt([]byte("secret"))
+
+	tests := []struct {
+		name       string
+		ciphertext string
+		want       bool
+	}{
+		{"old key", ciphertext1, true},
+		{"current key", ciphertext2, false},
+		{"legacy format", "aXY=;ZGF0YQ==", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rotated.NeedsRewrap(tt.ciphertext); got != tt.want {
+				t.Errorf("NeedsRewrap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
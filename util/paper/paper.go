@@ -0,0 +1,369 @@
+// Package paper simulates an exchange in-process, it implements crypto-trading-bot-engine/exchange.Exchanger with
+// the balances, positions and orders saved in paper_* tables, and fills at the price of PriceSource
+package paper
+
+import (
+	"crypto-trading-bot-api/model"
+	"crypto-trading-bot-engine/strategy/order"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	// Same message as FTX, which is checked by the callers
+	ErrOrderClosed = errors.New("Order already closed")
+
+	ErrNoPosition         = errors.New("no position")
+	ErrInsufficientMargin = errors.New("insufficient margin")
+)
+
+type Config struct {
+	Leverage decimal.Decimal
+	FeeRate  decimal.Decimal // taker fee of the market orders and the triggered stop orders
+}
+
+type Exchange struct {
+	db             *model.DB
+	credentialUuid string
+	prices         PriceSource
+	cfg            Config
+}
+
+// Orders of an account are filled one by one
+var accountLocks sync.Map
+
+func NewExchange(db *model.DB, credentialUuid string, prices PriceSource, cfg Config) *Exchange {
+	return &Exchange{
+		db:             db,
+		credentialUuid: credentialUuid,
+		prices:         prices,
+		cfg:            cfg,
+	}
+}
+
+func (e *Exchange) lock() func() {
+	mu, _ := accountLocks.LoadOrStore(e.credentialUuid, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// GetAccountInfo returns the same keys as the real exchanges, collateral includes the unrealized PnL
+func (e *Exchange) GetAccountInfo() (map[string]interface{}, error) {
+	if err := e.CheckTriggers(); err != nil {
+		return nil, err
+	}
+	return e.accountInfo()
+}
+
+func (e *Exchange) accountInfo() (map[string]interface{}, error) {
+	account, err := e.db.GetPaperAccount(e.credentialUuid)
+	if err != nil {
+		return nil, err
+	}
+	positions, _, err := e.db.GetPaperPositions(e.credentialUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	collateral := account.Balance
+	usedMargin := decimal.Zero
+	for _, p := range positions {
+		price, err := e.prices.GetPrice(p.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		collateral = collateral.Add(unrealizedPnl(&p, price))
+		usedMargin = usedMargin.Add(p.Size.Mul(price).Div(e.cfg.Leverage))
+	}
+	return map[string]interface{}{
+		"collateral":      collateral,
+		"free_collateral": collateral.Sub(usedMargin),
+		"leverage":        e.cfg.Leverage,
+		"balance":         account.Balance,
+	}, nil
+}
+
+// GetPosition returns size "0" if there's no position
+func (e *Exchange) GetPosition(symbol string) (map[string]interface{}, error) {
+	if err := e.CheckTriggers(); err != nil {
+		return nil, err
+	}
+	position := map[string]interface{}{
+		"symbol":      symbol,
+		"size":        "0",
+		"entry_price": "0",
+	}
+	p, err := e.db.GetPaperPosition(e.credentialUuid, symbol)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return position, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	position["side"] = p.Side
+	position["size"] = p.Size.String()
+	position["entry_price"] = p.EntryPrice.String()
+	return position, nil
+}
+
+// RetryGetPosition doesn't need to retry, it's in-process
+func (e *Exchange) RetryGetPosition(symbol string, count int64, interval int64) (map[string]interface{}, error) {
+	return e.GetPosition(symbol)
+}
+
+// ClosePosition reduces the position of the side by a market order
+func (e *Exchange) ClosePosition(symbol string, side order.Side, size decimal.Decimal) error {
+	unlock := e.lock()
+	defer unlock()
+
+	p, err := e.db.GetPaperPosition(e.credentialUuid, symbol)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && p.Side != int64(side)) {
+		return ErrNoPosition
+	}
+	if err != nil {
+		return err
+	}
+	price, err := e.prices.GetPrice(symbol)
+	if err != nil {
+		return err
+	}
+	_, err = e.fill(&model.PaperOrder{
+		CredentialUuid: e.credentialUuid,
+		Symbol:         symbol,
+		Side:           int64(opposite(side)),
+		Type:           model.PAPER_ORDER_MARKET,
+		Size:           decimal.Min(size, p.Size),
+	}, price)
+	return err
+}
+
+// PlaceEntryOrder opens or adds to the position of the side by a market order
+func (e *Exchange) PlaceEntryOrder(symbol string, side order.Side, size decimal.Decimal) (int64, error) {
+	if err := e.CheckTriggers(); err != nil {
+		return 0, err
+	}
+	unlock := e.lock()
+	defer unlock()
+
+	if !size.IsPositive() {
+		return 0, fmt.Errorf("size %s is invalid", size)
+	}
+	price, err := e.prices.GetPrice(symbol)
+	if err != nil {
+		return 0, err
+	}
+
+	// Margin of the new order and the fee
+	info, err := e.accountInfo()
+	if err != nil {
+		return 0, err
+	}
+	cost := size.Mul(price).Div(e.cfg.Leverage).Add(size.Mul(price).Mul(e.cfg.FeeRate))
+	if info["free_collateral"].(decimal.Decimal).LessThan(cost) {
+		return 0, ErrInsufficientMargin
+	}
+
+	return e.fill(&model.PaperOrder{
+		CredentialUuid: e.credentialUuid,
+		Symbol:         symbol,
+		Side:           int64(side),
+		Type:           model.PAPER_ORDER_MARKET,
+		Size:           size,
+	}, price)
+}
+
+// PlaceStopLossOrder places a trigger order to close the position of the side, see CheckTriggers
+func (e *Exchange) PlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal) (int64, error) {
+	if !price.IsPositive() || !size.IsPositive() {
+		return 0, fmt.Errorf("price %s or size %s is invalid", price, size)
+	}
+	unlock := e.lock()
+	defer unlock()
+
+	id, _, err := e.db.CreatePaperOrder(model.PaperOrder{
+		CredentialUuid: e.credentialUuid,
+		Symbol:         symbol,
+		Side:           int64(opposite(side)),
+		Type:           model.PAPER_ORDER_STOP,
+		Size:           size,
+		TriggerPrice:   price,
+		Status:         model.PAPER_ORDER_OPEN,
+	})
+	return id, err
+}
+
+func (e *Exchange) CancelStopLossOrder(orderId int64) error {
+	unlock := e.lock()
+	defer unlock()
+
+	o, err := e.db.GetPaperOrderByCredential(orderId, e.credentialUuid)
+	if err != nil {
+		return err
+	}
+	if o.Status != model.PAPER_ORDER_OPEN {
+		return ErrOrderClosed
+	}
+	_, err = e.db.UpdatePaperOrder(o.Id, map[string]interface{}{"status": model.PAPER_ORDER_CANCELLED})
+	return err
+}
+
+// RetryCancelOpenTriggerOrder accepts the orders which have been closed
+func (e *Exchange) RetryCancelOpenTriggerOrder(orderId int64, count int64, interval int64) error {
+	err := e.CancelStopLossOrder(orderId)
+	if errors.Is(err, ErrOrderClosed) {
+		return nil
+	}
+	return err
+}
+
+func (e *Exchange) GetMarketPrice(market string) (decimal.Decimal, error) {
+	return e.prices.GetPrice(market)
+}
+
+// CheckTriggers fills the stop orders whose trigger price is reached, the orders of a closed position are cancelled
+func (e *Exchange) CheckTriggers() error {
+	unlock := e.lock()
+	defer unlock()
+
+	orders, _, err := e.db.GetOpenPaperStopOrders(e.credentialUuid)
+	if err != nil {
+		return err
+	}
+	for i := range orders {
+		o := &orders[i]
+		price, err := e.prices.GetPrice(o.Symbol)
+		if err != nil {
+			return err
+		}
+
+		if !isStopTriggered(o, price) {
+			continue
+		}
+
+		p, err := e.db.GetPaperPosition(e.credentialUuid, o.Symbol)
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && p.Side == o.Side) {
+			if _, err = e.db.UpdatePaperOrder(o.Id, map[string]interface{}{"status": model.PAPER_ORDER_CANCELLED}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		o.Size = decimal.Min(o.Size, p.Size)
+		if _, err = e.fill(o, price); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fill applies the order to the position and the balance, NOTE the caller must hold the lock
+func (e *Exchange) fill(o *model.PaperOrder, price decimal.Decimal) (int64, error) {
+	account, err := e.db.GetPaperAccount(e.credentialUuid)
+	if err != nil {
+		return 0, err
+	}
+	p, err := e.db.GetPaperPosition(e.credentialUuid, o.Symbol)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		p = &model.PaperPosition{
+			CredentialUuid: e.credentialUuid,
+			Symbol:         o.Symbol,
+			Side:           o.Side,
+			Size:           decimal.Zero,
+			EntryPrice:     price,
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	pnl := net(p, o.Side, o.Size, price)
+
+	now := time.Now()
+	o.FillPrice = price
+	o.Fee = o.Size.Mul(price).Mul(e.cfg.FeeRate)
+	o.RealizedPnl = pnl
+	o.Status = model.PAPER_ORDER_FILLED
+	o.FilledAt = &now
+	balance := account.Balance.Add(pnl).Sub(o.Fee)
+	if err = e.db.SavePaperFill(o, p, balance); err != nil {
+		return 0, err
+	}
+	return o.Id, nil
+}
+
+// isStopTriggered returns true if the price reaches the trigger price of the stop order. Sell stop of long positions
+// triggers when the price falls, buy stop of short positions when it rises
+func isStopTriggered(o *model.PaperOrder, price decimal.Decimal) bool {
+	if o.Side == int64(order.SHORT) {
+		return price.LessThanOrEqual(o.TriggerPrice)
+	}
+	return price.GreaterThanOrEqual(o.TriggerPrice)
+}
+
+// net applies the filled size to the position and returns the realized PnL, e.g. a sell order reduces the long position
+// first, and the rest opens a short position
+func net(p *model.PaperPosition, side int64, size decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	pnl := decimal.Zero
+	remaining := size
+	if p.Side != side && p.Size.IsPositive() {
+		closed := decimal.Min(remaining, p.Size)
+		pnl = unrealizedPnl(&model.PaperPosition{Side: p.Side, Size: closed, EntryPrice: p.EntryPrice}, price)
+		p.Size = p.Size.Sub(closed)
+		remaining = remaining.Sub(closed)
+	}
+	if remaining.IsPositive() {
+		if p.Size.IsZero() {
+			p.Side = side
+			p.EntryPrice = price
+		} else {
+			p.EntryPrice = p.EntryPrice.Mul(p.Size).Add(price.Mul(remaining)).Div(p.Size.Add(remaining))
+		}
+		p.Size = p.Size.Add(remaining)
+	}
+	return pnl
+}
+
+func unrealizedPnl(p *model.PaperPosition, price decimal.Decimal) decimal.Decimal {
+	pnl := price.Sub(p.EntryPrice).Mul(p.Size)
+	if p.Side == int64(order.SHORT) {
+		return pnl.Neg()
+	}
+	return pnl
+}
+
+func opposite(side order.Side) order.Side {
+	if side == order.LONG {
+		return order.SHORT
+	}
+	return order.LONG
+}
+
+// CheckAllTriggers checks the stop orders of all paper accounts, run it periodically
+func CheckAllTriggers(db *model.DB, prices PriceSource, cfg Config) error {
+	orders, _, err := db.GetOpenPaperStopOrders("")
+	if err != nil {
+		return err
+	}
+	var errs []string
+	checked := make(map[string]bool)
+	for _, o := range orders {
+		if checked[o.CredentialUuid] {
+			continue
+		}
+		checked[o.CredentialUuid] = true
+		if err = NewExchange(db, o.CredentialUuid, prices, cfg).CheckTriggers(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", o.CredentialUuid, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
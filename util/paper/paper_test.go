@@ -0,0 +1,72 @@
+package paper
+
+import (
+	"crypto-trading-bot-api/model"
+	"crypto-trading-bot-engine/strategy/order"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestNet(t *testing.T) {
+	long, short := int64(order.LONG), int64(order.SHORT)
+	tests := []struct {
+		name           string
+		position       model.PaperPosition
+		side           int64
+		size           string
+		price          string
+		wantSide       int64
+		wantSize       string
+		wantEntryPrice string
+		wantPnl        string
+	}{
+		{"open long", model.PaperPosition{Side: long, Size: decimal.Zero}, long, "1", "100", long, "1", "100", "0"},
+		{"add to long averages the entry", model.PaperPosition{Side: long, Size: decimal.NewFromInt(1), EntryPrice: decimal.NewFromInt(100)}, long, "3", "200", long, "4", "175", "0"},
+		{"reduce long", model.PaperPosition{Side: long, Size: decimal.NewFromInt(2), EntryPrice: decimal.NewFromInt(100)}, short, "1", "110", long, "1", "100", "10"},
+		{"close long at a loss", model.PaperPosition{Side: long, Size: decimal.NewFromInt(2), EntryPrice: decimal.NewFromInt(100)}, short, "2", "90", long, "0", "100", "-20"},
+		{"reduce short", model.PaperPosition{Side: short, Size: decimal.NewFromInt(2), EntryPrice: decimal.NewFromInt(100)}, long, "1", "90", short, "1", "100", "10"},
+		{"flip long to short", model.PaperPosition{Side: long, Size: decimal.NewFromInt(1), EntryPrice: decimal.NewFromInt(100)}, short, "3", "120", short, "2", "120", "20"},
+		{"flip short to long", model.PaperPosition{Side: short, Size: decimal.NewFromInt(1), EntryPrice: decimal.NewFromInt(100)}, long, "2", "120", long, "1", "120", "-20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.position
+			pnl := net(&p, tt.side, decimal.RequireFromString(tt.size), decimal.RequireFromString(tt.price))
+			if p.Side != tt.wantSide || !p.Size.Equal(decimal.RequireFromString(tt.wantSize)) ||
+				!p.EntryPrice.Equal(decimal.RequireFromString(tt.wantEntryPrice)) {
+				t.Errorf("position = side %d, size %s, entry %s, want side %d, size %s, entry %s",
+					p.Side, p.Size, p.EntryPrice, tt.wantSide, tt.wantSize, tt.wantEntryPrice)
+			}
+			if !pnl.Equal(decimal.RequireFromString(tt.wantPnl)) {
+				t.Errorf("pnl = %s, want %s", pnl, tt.wantPnl)
+			}
+		})
+	}
+}
+
+func TestIsStopTriggered(t *testing.T) {
+	tests := []struct {
+		name  string
+		side  order.Side
+		price string
+		want  bool
+	}{
+		// Sell stop of long positions at 100
+		{"sell stop above the trigger", order.SHORT, "101", false},
+		{"sell stop at the trigger", order.SHORT, "100", true},
+		{"sell stop below the trigger", order.SHORT, "99", true},
+		// Buy stop of short positions at 100
+		{"buy stop below the trigger", order.LONG, "99", false},
+		{"buy stop at the trigger", order.LONG, "100", true},
+		{"buy stop above the trigger", order.LONG, "101", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &model.PaperOrder{Side: int64(tt.side), Type: model.PAPER_ORDER_STOP, TriggerPrice: decimal.NewFromInt(100)}
+			if got := isStopTriggered(o, decimal.RequireFromString(tt.price)); got != tt.want {
+				t.Errorf("isStopTriggered() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package paper
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Price sources, selected by config `PAPER_PRICE_SOURCE`
+const (
+	SOURCE_FEED    = "feed"    // local price feed over HTTP
+	SOURCE_CANDLES = "candles" // replayed candles of csv files
+
+	FEED_TIMEOUT_SECOND = 5
+)
+
+var ErrNoPrice = errors.New("price not available")
+
+// PriceSource returns the fill price of the symbol
+type PriceSource interface {
+	GetPrice(symbol string) (decimal.Decimal, error)
+}
+
+type PriceConfig struct {
+	Source string
+
+	// feed only, `{symbol}` is replaced, e.g. http://127.0.0.1:8081/price/{symbol}
+	FeedURL string
+
+	// candles only, candles of `<dir>/<symbol>.csv` are replayed from the first one when the source is created,
+	// `speed` times faster than the real time
+	CandlesDir  string
+	ReplaySpeed float64
+}
+
+func NewPriceSource(cfg PriceConfig) (PriceSource, error) {
+	switch cfg.Source {
+	case SOURCE_FEED:
+		if cfg.FeedURL == "" {
+			return nil, errors.New("feed url is empty")
+		}
+		return NewFeedPriceSource(cfg.FeedURL), nil
+	case SOURCE_CANDLES:
+		if cfg.CandlesDir == "" {
+			return nil, errors.New("candles dir is empty")
+		}
+		speed := cfg.ReplaySpeed
+		if speed <= 0 {
+			speed = 1
+		}
+		return NewCandleReplay(cfg.CandlesDir, speed), nil
+	}
+	return nil, fmt.Errorf("price source '%s' not supported", cfg.Source)
+}
+
+// FeedPriceSource reads `{"price": "123.4"}` from the URL
+type FeedPriceSource struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewFeedPriceSource(feedURL string) *FeedPriceSource {
+	return &FeedPriceSource{
+		URL:    feedURL,
+		Client: &http.Client{Timeout: time.Second * FEED_TIMEOUT_SECOND},
+	}
+}
+
+func (s *FeedPriceSource) GetPrice(symbol string) (decimal.Decimal, error) {
+	resp, err := s.Client.Get(strings.Replace(s.URL, "{symbol}", url.PathEscape(symbol), -1))
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decimal.Zero, fmt.Errorf("price feed responded with status %d", resp.StatusCode)
+	}
+
+	var r struct {
+		Price decimal.Decimal `json:"price"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return decimal.Zero, err
+	}
+	if !r.Price.IsPositive() {
+		return decimal.Zero, ErrNoPrice
+	}
+	return r.Price, nil
+}
+
+type candle struct {
+	Time  time.Time
+	Close decimal.Decimal
+}
+
+// CandleReplay returns the close price of the candle at the replayed time, the last one after the replay ends.
+// Rows of the csv are `<unix seconds>,<open>,<high>,<low>,<close>[,...]`, the header is optional
+type CandleReplay struct {
+	Dir   string
+	Speed float64
+
+	start   time.Time
+	mu      sync.Mutex
+	candles map[string][]candle
+}
+
+func NewCandleReplay(dir string, speed float64) *CandleReplay {
+	return &CandleReplay{
+		Dir:     dir,
+		Speed:   speed,
+		start:   time.Now(),
+		candles: make(map[string][]candle),
+	}
+}
+
+func (s *CandleReplay) GetPrice(symbol string) (decimal.Decimal, error) {
+	candles, err := s.load(symbol)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	elapsed := time.Duration(float64(time.Since(s.start)) * s.Speed)
+	at := candles[0].Time.Add(elapsed)
+	i := sort.Search(len(candles), func(i int) bool { return candles[i].Time.After(at) })
+	return candles[i-1].Close, nil
+}
+
+func (s *CandleReplay) load(symbol string) ([]candle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if candles, ok := s.candles[symbol]; ok {
+		return candles, nil
+	}
+
+	// NOTE symbols are file names, e.g. BTC-PERP.csv
+	if strings.ContainsAny(symbol, `/\`) {
+		return nil, ErrNoPrice
+	}
+	f, err := os.Open(filepath.Join(s.Dir, symbol+".csv"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var candles []candle
+	for i, row := range rows {
+		if len(row) < 5 {
+			return nil, fmt.Errorf("%s.csv line %d is invalid", symbol, i+1)
+		}
+		ts, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("%s.csv line %d is invalid", symbol, i+1)
+		}
+		price, err := decimal.NewFromString(row[4])
+		if err != nil {
+			return nil, fmt.Errorf("%s.csv line %d is invalid", symbol, i+1)
+		}
+		candles = append(candles, candle{Time: time.Unix(ts, 0), Close: price})
+	}
+	if len(candles) == 0 {
+		return nil, ErrNoPrice
+	}
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time.Before(candles[j].Time) })
+	s.candles[symbol] = candles
+	return candles, nil
+}
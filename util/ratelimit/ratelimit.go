@@ -0,0 +1,178 @@
+// Package ratelimit provides token bucket rate limiting and failure lockouts,
+// the in-memory implementations only work for a single instance, use a shared store (e.g. redis) behind the same interfaces otherwise
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// NOTE buckets and failures which are idle longer than this are removed from memory
+const CLEANUP_INTERVAL_SECOND = 60
+
+// Limiter decides whether the request identified by key is allowed
+type Limiter interface {
+	Allow(key string) (bool, error)
+}
+
+// Lockout locks the key for a while after too many failures
+type Lockout interface {
+	// Fail records a failure, returns true if the key becomes locked by this failure
+	Fail(key string) (bool, error)
+
+	// LockedUntil returns zero time if the key isn't locked
+	LockedUntil(key string) (time.Time, error)
+
+	Reset(key string) error
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryLimiter is a token bucket limiter, which allows `burst` requests at once and refills `rate` tokens per second
+type MemoryLimiter struct {
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	cleanedAt time.Time
+	mu        sync.Mutex
+}
+
+func NewMemoryLimiter(rate float64, burst int) *MemoryLimiter {
+	return &MemoryLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		cleanedAt: time.Now(),
+	}
+}
+
+func (l *MemoryLimiter) Allow(key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updatedAt: now}
+		l.buckets[key] = b
+	}
+
+	// Refill
+	b.tokens += now.Sub(b.updatedAt).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		return false, nil
+	}
+	b.tokens--
+	return true, nil
+}
+
+// cleanup removes the buckets which have been refilled, must be called with lock held
+func (l *MemoryLimiter) cleanup(now time.Time) {
+	if now.Sub(l.cleanedAt) < time.Second*CLEANUP_INTERVAL_SECOND {
+		return
+	}
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updatedAt).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.cleanedAt = now
+}
+
+type failure struct {
+	count       int
+	firstAt     time.Time
+	lockedUntil time.Time
+}
+
+// MemoryLockout locks the key for `duration` after `maxFailures` failures within `window`
+type MemoryLockout struct {
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+	failures    map[string]*failure
+	cleanedAt   time.Time
+	mu          sync.Mutex
+}
+
+func NewMemoryLockout(maxFailures int, window time.Duration, duration time.Duration) *MemoryLockout {
+	return &MemoryLockout{
+		maxFailures: maxFailures,
+		window:      window,
+		duration:    duration,
+		failures:    make(map[string]*failure),
+		cleanedAt:   time.Now(),
+	}
+}
+
+func (l *MemoryLockout) Fail(key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+
+	f, ok := l.failures[key]
+	if !ok || (now.Sub(f.firstAt) > l.window && now.After(f.lockedUntil)) {
+		f = &failure{firstAt: now}
+		l.failures[key] = f
+	}
+
+	// Already locked
+	if now.Before(f.lockedUntil) {
+		return false, nil
+	}
+
+	f.count++
+	if f.count < l.maxFailures {
+		return false, nil
+	}
+
+	// Start over after the lockout
+	f.lockedUntil = now.Add(l.duration)
+	f.count = 0
+	f.firstAt = f.lockedUntil
+	return true, nil
+}
+
+func (l *MemoryLockout) LockedUntil(key string) (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok || time.Now().After(f.lockedUntil) {
+		return time.Time{}, nil
+	}
+	return f.lockedUntil, nil
+}
+
+func (l *MemoryLockout) Reset(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, key)
+	return nil
+}
+
+// cleanup removes the failures which are neither locked nor in the window, must be called with lock held
+func (l *MemoryLockout) cleanup(now time.Time) {
+	if now.Sub(l.cleanedAt) < time.Second*CLEANUP_INTERVAL_SECOND {
+		return
+	}
+	for key, f := range l.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.firstAt) > l.window {
+			delete(l.failures, key)
+		}
+	}
+	l.cleanedAt = now
+}
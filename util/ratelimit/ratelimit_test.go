@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// rewindBucket moves the last update of the bucket back, as if the time has passed
+func rewindBucket(l *MemoryLimiter, key string, d time.Duration) {
+	if b, ok := l.buckets[key]; ok {
+		b.updatedAt = b.updatedAt.Add(-d)
+	}
+}
+
+// rewindFailure moves the times of the failure back, as if the time has passed
+func rewindFailure(l *MemoryLockout, key string, d time.Duration) {
+	if f, ok := l.failures[key]; ok {
+		f.firstAt = f.firstAt.Add(-d)
+		f.lockedUntil = f.lockedUntil.Add(-d)
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	type step struct {
+		elapsed time.Duration
+		want    bool
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{"burst then denied", 1, 2, []step{{0, true}, {0, true}, {0, false}}},
+		{"refilled by rate", 1, 1, []step{{0, true}, {0, false}, {time.Second, true}, {0, false}}},
+		{"partially refilled", 0.5, 1, []step{{0, true}, {time.Second, false}, {time.Second, true}}},
+		{"refill capped at burst", 1, 2, []step{{0, true}, {0, true}, {time.Minute, true}, {0, true}, {0, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewMemoryLimiter(tt.rate, tt.burst)
+			for i, s := range tt.steps {
+				rewindBucket(l, "key", s.elapsed)
+				if got, _ := l.Allow("key"); got != s.want {
+					t.Errorf("step %d: Allow() = %v, want %v", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryLimiterKeys(t *testing.T) {
+	l := NewMemoryLimiter(1, 1)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("Allow(a) = false, want true")
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("Allow(b) = false, want true, keys don't share the bucket")
+	}
+}
+
+func TestMemoryLockout(t *testing.T) {
+	const (
+		maxFailures = 3
+		window      = time.Minute
+		duration    = 10 * time.Minute
+	)
+	type step struct {
+		action     string // "fail" or "reset"
+		elapsed    time.Duration
+		wantLocked bool // returned by Fail
+		wantUntil  bool // LockedUntil isn't zero after the step
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"locked by the last failure", []step{
+			{"fail", 0, false, false},
+			{"fail", 0, false, false},
+			{"fail", 0, true, true},
+		}},
+		{"failures while locked aren't counted", []step{
+			{"fail", 0, false, false},
+			{"fail", 0, false, false},
+			{"fail", 0, true, true},
+			{"fail", 0, false, true},
+		}},
+		{"failures out of the window start over", []step{
+			{"fail", 0, false, false},
+			{"fail", 0, false, false},
+			{"fail", window + time.Second, false, false},
+			{"fail", 0, false, false},
+			{"fail", 0, true, true},
+		}},
+		{"unlocked after the duration", []step{
+			{"fail", 0, false, false},
+			{"fail", 0, false, false},
+			{"fail", 0, true, true},
+			{"fail", duration + time.Second, false, false},
+			{"fail", 0, false, false},
+			{"fail", 0, true, true},
+		}},
+		{"reset", []step{
+			{"fail", 0, false, false},
+			{"fail", 0, false, false},
+			{"fail", 0, true, true},
+			{"reset", 0, false, false},
+			{"fail", 0, false, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewMemoryLockout(maxFailures, window, duration)
+			for i, s := range tt.steps {
+				rewindFailure(l, "key", s.elapsed)
+				if s.action == "reset" {
+					l.Reset("key")
+				} else if locked, _ := l.Fail("key"); locked != s.wantLocked {
+					t.Errorf("step %d: Fail() = %v, want %v", i, locked, s.wantLocked)
+				}
+				if until, _ := l.LockedUntil("key"); until.IsZero() == s.wantUntil {
+					t.Errorf("step %d: LockedUntil() = %v, want locked %v", i, until, s.wantUntil)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Package totp implements time-based one-time passwords (RFC 6238) used by authenticator apps
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Most of authenticator apps only support these values
+	PERIOD_SECOND = 30
+	DIGITS        = 6
+
+	// Allow the clock of the phone to drift by one period
+	SKEW = 1
+
+	SECRET_LENGTH = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random secret encoded by base32
+func GenerateSecret() (string, error) {
+	b := make([]byte, SECRET_LENGTH)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the key uri for QR code, see https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func URI(issuer string, account string, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", DIGITS))
+	v.Set("period", fmt.Sprintf("%d", PERIOD_SECOND))
+	label := url.PathEscape(fmt.Sprintf("%s:%s", issuer, account))
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, v.Encode())
+}
+
+// Step returns the time step of t
+func Step(t time.Time) int64 {
+	return t.Unix() / PERIOD_SECOND
+}
+
+// Code returns the code of the time step
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < DIGITS; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", DIGITS, value%mod), nil
+}
+
+// Validate checks the code around t, and returns the matched step so that the caller can reject the code being reused
+func Validate(secret string, code string, t time.Time) (step int64, ok bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != DIGITS {
+		return 0, false
+	}
+	current := Step(t)
+	for i := int64(-SKEW); i <= SKEW; i++ {
+		expected, err := Code(secret, current+i)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return current + i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,94 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secret of the SHA1 test vectors of RFC 6238, "12345678901234567890" encoded by base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 Appendix B, the last 6 of the 8 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatalf("Code() err: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Code() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	got, err := Code(strings.ToLower(rfcSecret), Step(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Errorf("Code() = %s, %v, want 287082", got, err)
+	}
+	if _, err = Code("not base32!", 1); err == nil {
+		t.Error("Code() should fail with invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOk   bool
+	}{
+		{"current step", "050471", Step(now), true},
+		{"with spaces", " 050471 ", Step(now), true},
+		{"previous step", mustCode(t, Step(now)-1), Step(now) - 1, true},
+		{"next step", mustCode(t, Step(now)+1), Step(now) + 1, true},
+		{"out of skew", mustCode(t, Step(now)-2), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"too short", "05047", 0, false},
+		{"8 digits", "14050471", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.wantOk || step != tt.wantStep {
+				t.Errorf("Validate() = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() err: %v", err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) != SECRET_LENGTH {
+		t.Errorf("GenerateSecret() = %s, decoded %d bytes, err: %v", secret, len(key), err)
+	}
+}
+
+func mustCode(t *testing.T, step int64) string {
+	t.Helper()
+	code, err := Code(rfcSecret, step)
+	if err != nil {
+		t.Fatalf("Code() err: %v", err)
+	}
+	return code
+}